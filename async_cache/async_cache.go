@@ -1,137 +1,336 @@
-/**
-此包用于缓存请求过的数据，会定期刷新所有缓存的key，且无过期时间
+/*
+*
+此包用于缓存请求过的数据，会定期刷新所有缓存的key;
+可以通过 MaxEntries 限制key的数量(按LRU淘汰), 通过 ExpireAfterAccess 淘汰长时间没有被读取的key
 */
 package async_cache
 
 import (
-	"fmt"
+	"container/list"
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/drip-in/eden_lib/gopool"
+	"github.com/go-redis/redis"
 )
 
 // Options .
-type Options struct {
+type Options[K comparable, V any] struct {
 	BlockIfFirst    bool
 	RefreshDuration time.Duration
-	Fetcher         func(key string) (interface{}, error)
-	ErrHandler      func(key string, err error)
-	ChangeHandler   func(key string, oldData, newData interface{})
-	IsSame          func(key string, oldData, newData interface{}) bool
+	// MaxEntries 最多缓存的key数量, 超出后淘汰最久没有被读取的key; <=0 表示不限制
+	MaxEntries int
+	// ExpireAfterAccess key超过该时长没有被Get过就会被淘汰, 不再刷新; <=0 表示不过期
+	ExpireAfterAccess time.Duration
+	Fetcher           func(key K) (V, error)
+	// FetcherCtx 与 Fetcher 相同, 设置后优先使用; 首次拉取时传入的ctx带有 GetCtx 调用方ctx中的值,
+	// 但不随调用方取消, 截止时间为 FetchTimeout; 后台刷新时传入 context.Background()
+	FetcherCtx func(ctx context.Context, key K) (V, error)
+	// FetchTimeout 首次拉取的超时时间, 默认 10s; 调用方的ctx结束时只是不再等待, 拉取继续并共享给其它调用方
+	FetchTimeout time.Duration
+	// BatchFetcher 一次拉取多个key(如 MGET / SQL IN), 设置后刷新时优先使用;
+	// 结果中缺失的key在刷新时保留旧值, 在首次拉取时视为 ErrNotFound
+	BatchFetcher func(keys []K) (map[K]V, error)
+	// RefreshBatchSize 每次调用 BatchFetcher 的key数量, 默认 100
+	RefreshBatchSize int
+	// RefreshConcurrency 每轮刷新同时进行的拉取数量, 默认 1
+	RefreshConcurrency int
+	// RefreshTimeout 每轮刷新的截止时间, 到期后未开始的拉取会被跳过; 默认等于 RefreshDuration
+	RefreshTimeout time.Duration
+	// RefreshJitter 每轮刷新把拉取任务随机打散到该时长内, 避免所有key在同一时刻被拉取
+	RefreshJitter time.Duration
+	// RefreshPool 执行刷新任务的协程池, 默认使用 gopool 的默认池; 使用 TrySubmit 提交,
+	// 队列已满时不按 RejectPolicy 处理, 任务直接跳过, 等下一轮再刷新
+	RefreshPool gopool.Pool
+	// Policy 拉取失败时的退避、旧值可用时长以及"数据不存在"的缓存策略
+	Policy Policy
+	// Namespace 缓存的命名空间, 同一命名空间的实例之间互相广播 Invalidate/Refresh
+	Namespace string
+	// InvalidationClient 设置后通过 redis pub/sub 把 Invalidate/Refresh 广播给其它实例, key需要能被json序列化
+	InvalidationClient *redis.Client
+	ErrHandler         func(key K, err error)
+	ChangeHandler      func(key K, oldData, newData V)
+	IsSame             func(key K, oldData, newData V) bool
+}
+
+// Stats 缓存的统计信息快照
+type Stats struct {
+	Hits            uint64
+	Misses          uint64
+	Evictions       uint64 // 因 MaxEntries 被淘汰的key数量
+	Expirations     uint64 // 因 ExpireAfterAccess 被淘汰的key数量
+	RefreshFailures uint64
+	Entries         int
 }
 
 // Asyncache .
-type Asyncache struct {
-	sfg  *Group
-	opt  Options
-	data sync.Map
+type Asyncache[K comparable, V any] struct {
+	// 统计计数放在开头, 保证32位平台上atomic操作的8字节对齐
+	hits            uint64
+	misses          uint64
+	evictions       uint64
+	expirations     uint64
+	refreshFailures uint64
+
+	sfg  *KeyedGroup[K]
+	opt  Options[K, V]
 	exit chan struct{}
+
+	mu    sync.Mutex
+	ll    *list.List // 队头为最近访问的key
+	items map[K]*list.Element
+
+	instanceId string
+	pubsub     *redis.PubSub
 }
 
 // NewAsyncache .
-func NewAsyncache(opt Options) *Asyncache {
-	c := &Asyncache{
-		sfg:  &Group{},
-		opt:  opt,
-		exit: make(chan struct{}),
+func NewAsyncache[K comparable, V any](opt Options[K, V]) *Asyncache[K, V] {
+	c := &Asyncache[K, V]{
+		sfg:   &KeyedGroup[K]{},
+		opt:   opt,
+		exit:  make(chan struct{}),
+		ll:    list.New(),
+		items: make(map[K]*list.Element),
+	}
+	if opt.InvalidationClient != nil {
+		c.subscribe()
 	}
 	go c.refresher()
 	return c
 }
 
-// Get .
-func (c *Asyncache) Get(key string, defaultVal interface{}) interface{} {
-	val, ok := c.data.Load(key)
-	if ok {
-		return val
-	}
+// Get 返回key对应的值, 没有可用的值时返回defaultVal
+func (c *Asyncache[K, V]) Get(key K, defaultVal V) V {
+	return c.GetCtx(context.Background(), key, defaultVal)
+}
+
+// GetCtx 与 Get 相同, 阻塞拉取时把ctx传给 FetcherCtx
+func (c *Asyncache[K, V]) GetCtx(ctx context.Context, key K, defaultVal V) V {
+	val, _, _ := c.GetWithFreshnessCtx(ctx, key, defaultVal)
+	return val
+}
+
+// GetWithFreshness 返回key对应的值以及它的来源, 调用方可以据此区分默认值和真实值;
+// 返回 FreshnessStale 时 error 为nil, 返回默认值时 error 为最近一次拉取失败的原因(没有则为nil)
+func (c *Asyncache[K, V]) GetWithFreshness(key K, defaultVal V) (V, Freshness, error) {
+	return c.GetWithFreshnessCtx(context.Background(), key, defaultVal)
+}
 
-	if !c.opt.BlockIfFirst {
-		c.data.Store(key, defaultVal)
-		return defaultVal
+// GetWithFreshnessCtx 与 GetWithFreshness 相同, 阻塞拉取时把ctx传给 FetcherCtx
+func (c *Asyncache[K, V]) GetWithFreshnessCtx(ctx context.Context, key K, defaultVal V) (V, Freshness, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.lookup(key, now)
+	if ok {
+		freshness, servable := e.servable(&c.opt.Policy, now)
+		if servable {
+			val, err := e.val, error(nil)
+			if freshness == FreshnessNotFound {
+				val, err = defaultVal, e.lastErr
+			}
+			c.mu.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return val, freshness, err
+		}
+		// 非阻塞模式或者还在退避期内, 等待后台刷新, 避免失败时打垮下游
+		if !c.opt.BlockIfFirst || now.Before(e.nextRetry) {
+			err := e.lastErr
+			c.mu.Unlock()
+			atomic.AddUint64(&c.misses, 1)
+			return defaultVal, FreshnessDefault, err
+		}
+	} else if !c.opt.BlockIfFirst {
+		// 先占位, 由后台刷新拉取真实值
+		c.getOrCreate(key, defaultVal, now)
+		c.mu.Unlock()
+		atomic.AddUint64(&c.misses, 1)
+		return defaultVal, FreshnessDefault, nil
 	}
+	c.mu.Unlock()
+	atomic.AddUint64(&c.misses, 1)
 
-	// 避免启动时, 并发的对同一个key产生大量请求
-	val, err := c.sfg.Do(key, func() (interface{}, error) {
-		return c.opt.Fetcher(key)
+	// 避免启动时, 并发的对同一个key产生大量请求; 拉取不使用调用方的ctx, 一个调用方取消不影响其它调用方
+	v, err, _ := c.sfg.DoCtx(ctx, key, func() (interface{}, error) {
+		fetchCtx, cancel := c.fetchContext(ctx)
+		defer cancel()
+		val, err := c.fetch(fetchCtx, key)
+		c.storeResult(key, defaultVal, val, err)
+		return val, err
 	})
-	if err != nil {
-		if c.opt.ErrHandler != nil {
-			c.opt.ErrHandler(key, err)
-		} else {
-			fmt.Errorf("first fetch %s err: %s, default value: %v", key, err.Error(), val)
-		}
-		val = defaultVal
+	if err == nil {
+		val, _ := v.(V)
+		return val, FreshnessFresh, nil
+	}
+	if ctx.Err() != nil {
+		// 调用方不再等待, 拉取的结果由后续的 Get 读取
+		return defaultVal, FreshnessDefault, err
+	}
+	if c.opt.Policy.isNotFound(err) {
+		return defaultVal, FreshnessNotFound, err
 	}
+	if c.opt.ErrHandler != nil {
+		c.opt.ErrHandler(key, err)
+	}
+	return defaultVal, FreshnessDefault, err
+}
 
-	c.data.Store(key, val)
-	return val
+// Set 直接写入key的值, 视为一次成功的拉取; 设置了 InvalidationClient 时通知其它实例重新拉取该key.
+// 返回的error只表示广播失败, 本实例的写入总是生效的
+func (c *Asyncache[K, V]) Set(key K, val V) error {
+	now := time.Now()
+	c.mu.Lock()
+	e := c.getOrCreate(key, val, now)
+	e.lastAccess = now
+	e.setVal(val, now)
+	c.mu.Unlock()
+	c.sfg.Forget(key)
+	return c.publish(refreshOp, key)
 }
 
 // Pop returns key-value pairs that match the given condition and removes them
 // from the cache. The toRemove takes a key and return a boolean value indicating
 // whether the key should be removed.
-func (c *Asyncache) Pop(toRemove func(key string) bool) (keys []string, vals []interface{}) {
-	c.data.Range(func(key, value interface{}) bool {
-		k := key.(string)
-		if toRemove(k) {
-			keys = append(keys, k)
-			vals = append(vals, value)
-			c.data.Delete(key)
+func (c *Asyncache[K, V]) Pop(toRemove func(key K) bool) (keys []K, vals []V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.items {
+		if toRemove(key) {
+			keys = append(keys, key)
+			vals = append(vals, elem.Value.(*entry[K, V]).val)
+			c.removeElement(elem)
 		}
-		return true
-	})
+	}
 	return
 }
 
 // Dump .
-func (c *Asyncache) Dump() map[string]interface{} {
-	data := make(map[string]interface{})
-	c.data.Range(func(key, value interface{}) bool {
-		k, ok := key.(string)
-		// 如果不是string就打印日志然后跳过
-		if !ok {
-			fmt.Errorf("invalid key: %v, type: %T is not string", k, k)
-			return true
-		}
-		data[k] = value
-		return true
-	})
+func (c *Asyncache[K, V]) Dump() map[K]V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := make(map[K]V, len(c.items))
+	for key, elem := range c.items {
+		data[key] = elem.Value.(*entry[K, V]).val
+	}
 	return data
 }
 
+// Len 返回当前缓存的key数量
+func (c *Asyncache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Stats 返回缓存统计信息的快照
+func (c *Asyncache[K, V]) Stats() Stats {
+	return Stats{
+		Hits:            atomic.LoadUint64(&c.hits),
+		Misses:          atomic.LoadUint64(&c.misses),
+		Evictions:       atomic.LoadUint64(&c.evictions),
+		Expirations:     atomic.LoadUint64(&c.expirations),
+		RefreshFailures: atomic.LoadUint64(&c.refreshFailures),
+		Entries:         c.Len(),
+	}
+}
+
 // Close .
-func (c *Asyncache) Close() {
+func (c *Asyncache[K, V]) Close() {
 	close(c.exit)
+	if c.pubsub != nil {
+		_ = c.pubsub.Close()
+	}
 }
 
-func (c *Asyncache) refresher() {
-	ch := time.Tick(c.opt.RefreshDuration)
-	for {
-		select {
-		case <-c.exit:
-			return
-		case <-ch:
-			c.refresh()
-		}
+// lookup 查找key并更新访问时间, 已过期的key视为不存在; 调用方需持有锁
+func (c *Asyncache[K, V]) lookup(key K, now time.Time) (*entry[K, V], bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
 	}
+	e := elem.Value.(*entry[K, V])
+	if c.expired(e, now) {
+		c.removeElement(elem)
+		atomic.AddUint64(&c.expirations, 1)
+		return nil, false
+	}
+	e.lastAccess = now
+	c.ll.MoveToFront(elem)
+	return e, true
 }
 
-func (c *Asyncache) refresh() {
-	oldData := c.Dump()
-	for key, oldVal := range oldData {
-		newVal, err := c.opt.Fetcher(key)
-		if err != nil {
-			if c.opt.ErrHandler != nil {
-				go c.opt.ErrHandler(key, err)
-			}
+// getOrCreate 返回key对应的entry, 不存在时以defaultVal占位创建, 并按 MaxEntries 淘汰; 调用方需持有锁
+func (c *Asyncache[K, V]) getOrCreate(key K, defaultVal V, now time.Time) *entry[K, V] {
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		return elem.Value.(*entry[K, V])
+	}
+	e := &entry[K, V]{key: key, val: defaultVal, lastAccess: now}
+	c.items[key] = c.ll.PushFront(e)
+	for c.opt.MaxEntries > 0 && c.ll.Len() > c.opt.MaxEntries {
+		c.removeElement(c.ll.Back())
+		atomic.AddUint64(&c.evictions, 1)
+	}
+	return e
+}
+
+// storeResult 记录Get中首次拉取的结果
+func (c *Asyncache[K, V]) storeResult(key K, defaultVal, val V, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	e := c.getOrCreate(key, defaultVal, now)
+	e.lastAccess = now
+	if err != nil {
+		e.setErr(&c.opt.Policy, err, now)
+		return
+	}
+	e.setVal(val, now)
+}
+
+// update 记录刷新的结果, 只处理仍在缓存中的key, 不影响访问时间和LRU顺序
+func (c *Asyncache[K, V]) update(key K, val V, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return
+	}
+	e := elem.Value.(*entry[K, V])
+	if err != nil {
+		e.setErr(&c.opt.Policy, err, time.Now())
+		return
+	}
+	e.setVal(val, time.Now())
+}
+
+func (c *Asyncache[K, V]) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*entry[K, V]).key)
+}
+
+func (c *Asyncache[K, V]) expired(e *entry[K, V], now time.Time) bool {
+	return c.opt.ExpireAfterAccess > 0 && now.Sub(e.lastAccess) > c.opt.ExpireAfterAccess
+}
+
+// liveData 淘汰过期的key, 返回本轮需要刷新的key的快照(跳过退避中和"数据不存在"缓存未到期的key)
+func (c *Asyncache[K, V]) liveData() map[K]V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	data := make(map[K]V, len(c.items))
+	for key, elem := range c.items {
+		e := elem.Value.(*entry[K, V])
+		if c.expired(e, now) {
+			c.removeElement(elem)
+			atomic.AddUint64(&c.expirations, 1)
 			continue
 		}
-		if c.opt.IsSame != nil && !c.opt.IsSame(key, oldVal, newVal) {
-			if c.opt.ChangeHandler != nil {
-				go c.opt.ChangeHandler(key, oldVal, newVal)
-			}
+		if e.needRefresh(now) {
+			data[key] = e.val
 		}
-
-		c.data.Store(key, newVal)
 	}
+	return data
 }
@@ -0,0 +1,420 @@
+package async_cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drip-in/eden_lib/gopool"
+	"github.com/drip-in/eden_lib/logs"
+)
+
+func TestMain(m *testing.M) {
+	// 测试中没有初始化日志, 使用空实现
+	nop := func(msg string, fields ...logs.Field) {}
+	ctxNop := func(ctx context.Context, msg string, fields ...logs.Field) {}
+	logs.Info, logs.Warn, logs.Error = nop, nop, nop
+	logs.CtxInfo, logs.CtxWarn, logs.CtxError = ctxNop, ctxNop, ctxNop
+	os.Exit(m.Run())
+}
+
+func TestAsyncacheLRU(t *testing.T) {
+	c := NewAsyncache(Options[int, string]{
+		BlockIfFirst: true,
+		MaxEntries:   2,
+		Fetcher: func(key int) (string, error) {
+			return strconv.Itoa(key), nil
+		},
+	})
+	defer c.Close()
+
+	c.Get(1, "")
+	c.Get(2, "")
+	c.Get(1, "") // 1 变为最近访问
+	c.Get(3, "") // 淘汰 2
+
+	data := c.Dump()
+	if _, ok := data[2]; ok || len(data) != 2 {
+		t.Fatalf("unexpected data after eviction: %v", data)
+	}
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAsyncacheExpireAfterAccess(t *testing.T) {
+	var fetched int32
+	c := NewAsyncache(Options[string, int]{
+		BlockIfFirst:      true,
+		ExpireAfterAccess: 20 * time.Millisecond,
+		Fetcher: func(key string) (int, error) {
+			return int(atomic.AddInt32(&fetched, 1)), nil
+		},
+	})
+	defer c.Close()
+
+	if v := c.Get("a", 0); v != 1 {
+		t.Fatalf("first get: %v", v)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if v := c.Get("a", 0); v != 2 {
+		t.Fatalf("expired key should be fetched again, got %v", v)
+	}
+	if stats := c.Stats(); stats.Expirations != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAsyncacheRefresh(t *testing.T) {
+	var version int32
+	changed := make(chan int32, 1)
+	c := NewAsyncache(Options[string, int32]{
+		BlockIfFirst:    true,
+		RefreshDuration: 10 * time.Millisecond,
+		Fetcher: func(key string) (int32, error) {
+			v := atomic.LoadInt32(&version)
+			if v < 0 {
+				return 0, errors.New("fetch failed")
+			}
+			return v, nil
+		},
+		IsSame: func(key string, oldData, newData int32) bool {
+			return oldData == newData
+		},
+		ChangeHandler: func(key string, oldData, newData int32) {
+			select {
+			case changed <- newData:
+			default:
+			}
+		},
+	})
+	defer c.Close()
+
+	c.Get("k", -1)
+	atomic.StoreInt32(&version, 1)
+	select {
+	case v := <-changed:
+		if v != 1 {
+			t.Fatalf("unexpected change: %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("change handler not called")
+	}
+
+	atomic.StoreInt32(&version, -1)
+	time.Sleep(50 * time.Millisecond)
+	if v := c.Get("k", -1); v != 1 {
+		t.Fatalf("failed refresh should keep old value, got %v", v)
+	}
+	if stats := c.Stats(); stats.RefreshFailures == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestAsyncacheBatchRefresh(t *testing.T) {
+	var calls, running, maxRunning int32
+	var version int32
+	c := NewAsyncache(Options[int, int32]{
+		RefreshDuration:    20 * time.Millisecond,
+		RefreshBatchSize:   10,
+		RefreshConcurrency: 2,
+		RefreshJitter:      5 * time.Millisecond,
+		BatchFetcher: func(keys []int) (map[int]int32, error) {
+			atomic.AddInt32(&calls, 1)
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			data := make(map[int]int32, len(keys))
+			for _, key := range keys {
+				data[key] = atomic.LoadInt32(&version)
+			}
+			return data, nil
+		},
+	})
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		c.Get(i, 0)
+	}
+	atomic.StoreInt32(&version, 1)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		done := true
+		for _, v := range c.Dump() {
+			if v != 1 {
+				done = false
+				break
+			}
+		}
+		if done {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for k, v := range c.Dump() {
+		if v != 1 {
+			t.Fatalf("key %v not refreshed: %v", k, v)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n < 10 {
+		t.Fatalf("expected at least one round of 10 batches, got %v calls", n)
+	}
+	if m := atomic.LoadInt32(&maxRunning); m > 2 {
+		t.Fatalf("concurrency limit exceeded: %v", m)
+	}
+}
+
+func TestAsyncachePolicy(t *testing.T) {
+	var fetched, failing int32
+	failErr := errors.New("downstream unavailable")
+	c := NewAsyncache(Options[string, int32]{
+		BlockIfFirst:    true,
+		RefreshDuration: 10 * time.Millisecond,
+		Policy: Policy{
+			MaxStaleness: 50 * time.Millisecond,
+			BaseBackoff:  time.Hour,
+			NegativeTTL:  time.Hour,
+		},
+		Fetcher: func(key string) (int32, error) {
+			atomic.AddInt32(&fetched, 1)
+			if key == "missing" {
+				return 0, ErrNotFound
+			}
+			if atomic.LoadInt32(&failing) == 1 {
+				return 0, failErr
+			}
+			return 1, nil
+		},
+		ErrHandler: func(key string, err error) {},
+	})
+	defer c.Close()
+
+	if v, freshness, err := c.GetWithFreshness("missing", -1); v != -1 || freshness != FreshnessNotFound || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unexpected not found result: %v %v %v", v, freshness, err)
+	}
+	if _, freshness, _ := c.GetWithFreshness("missing", -1); freshness != FreshnessNotFound {
+		t.Fatalf("not found should be cached, got %v", freshness)
+	}
+
+	if v, freshness, err := c.GetWithFreshness("k", -1); v != 1 || freshness != FreshnessFresh || err != nil {
+		t.Fatalf("unexpected fresh result: %v %v %v", v, freshness, err)
+	}
+	atomic.StoreInt32(&failing, 1)
+	time.Sleep(30 * time.Millisecond)
+	if v, freshness, err := c.GetWithFreshness("k", -1); v != 1 || freshness != FreshnessStale || err != nil {
+		t.Fatalf("unexpected stale result: %v %v %v", v, freshness, err)
+	}
+
+	// 退避期内不会再次拉取, 超过 MaxStaleness 后返回默认值
+	before := atomic.LoadInt32(&fetched)
+	time.Sleep(60 * time.Millisecond)
+	if v, freshness, err := c.GetWithFreshness("k", -1); v != -1 || freshness != FreshnessDefault || err != failErr {
+		t.Fatalf("unexpected result beyond max staleness: %v %v %v", v, freshness, err)
+	}
+	if after := atomic.LoadInt32(&fetched); after != before {
+		t.Fatalf("key in backoff should not be fetched, fetched %v times", after-before)
+	}
+	if n := atomic.LoadInt32(&fetched); n != 3 {
+		t.Fatalf("unexpected fetch count: %v", n)
+	}
+}
+
+func TestAsyncacheInvalidateAndRefresh(t *testing.T) {
+	var version int32
+	c := NewAsyncache(Options[string, int32]{
+		BlockIfFirst: true,
+		Fetcher: func(key string) (int32, error) {
+			return atomic.LoadInt32(&version), nil
+		},
+	})
+	defer c.Close()
+
+	c.Get("k", -1)
+	atomic.StoreInt32(&version, 1)
+	if err := c.Refresh("k"); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Get("k", -1); v != 1 {
+		t.Fatalf("Refresh should refetch the key, got %v", v)
+	}
+
+	atomic.StoreInt32(&version, 2)
+	if err := c.Invalidate("k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Len(); n != 0 {
+		t.Fatalf("Invalidate should remove the key, len %v", n)
+	}
+	if v := c.Get("k", -1); v != 2 {
+		t.Fatalf("invalidated key should be fetched again, got %v", v)
+	}
+
+	// 其它实例发来的消息
+	c.instanceId = "self"
+	atomic.StoreInt32(&version, 3)
+	c.handleInvalidation(`{"op":"invalidate","key":"k","source":"other"}`)
+	if v := c.Get("k", -1); v != 3 {
+		t.Fatalf("remote invalidation not applied, got %v", v)
+	}
+}
+
+func TestAsyncacheFlightKey(t *testing.T) {
+	// 两个key格式化后的字符串相同, 并发的首次拉取不能合并
+	type key struct{ a, b string }
+	k1, k2 := key{"x y", ""}, key{"x", "y "}
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	c := NewAsyncache(Options[key, string]{
+		BlockIfFirst: true,
+		Fetcher: func(k key) (string, error) {
+			started <- struct{}{}
+			<-release
+			return k.a + "|" + k.b, nil
+		},
+	})
+	defer c.Close()
+
+	results := make(chan string, 2)
+	go func() { results <- c.Get(k1, "") }()
+	<-started
+	go func() { results <- c.Get(k2, "") }()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("fetch of the second key was merged into the first")
+	}
+	close(release)
+	got := map[string]bool{<-results: true, <-results: true}
+	if !got["x y|"] || !got["x|y "] {
+		t.Fatalf("unexpected results: %v", got)
+	}
+}
+
+func TestAsyncacheGetCtxCancel(t *testing.T) {
+	release := make(chan struct{})
+	var fetched int32
+	c := NewAsyncache(Options[string, int]{
+		BlockIfFirst:    true,
+		RefreshDuration: time.Hour,
+		FetcherCtx: func(ctx context.Context, key string) (int, error) {
+			atomic.AddInt32(&fetched, 1)
+			select {
+			case <-release:
+				return 1, nil
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		},
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, _, err := c.GetWithFreshnessCtx(ctx, "a", 0)
+		canceled <- err
+	}()
+	for atomic.LoadInt32(&fetched) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	result := make(chan int, 1)
+	go func() {
+		result <- c.Get("a", 0)
+	}()
+
+	// 第一个调用方取消后拉取继续, 并发的调用方仍然拿到结果
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Fatalf("canceled caller should get context.Canceled, got %v", err)
+	}
+	close(release)
+	if v := <-result; v != 1 {
+		t.Fatalf("concurrent caller should get the value, got %v", v)
+	}
+	// 取消不会被记录为拉取失败
+	if v, _, err := c.GetWithFreshness("a", 0); v != 1 || err != nil {
+		t.Fatalf("GetWithFreshness = %v, %v", v, err)
+	}
+	if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Fatalf("fetched %v times, want 1", n)
+	}
+}
+
+func TestAsyncacheRefreshRejected(t *testing.T) {
+	// 任何拒绝策略下, 被拒绝的任务都不会阻塞刷新
+	for _, policy := range []gopool.RejectPolicy{gopool.RejectError, gopool.RejectDrop, gopool.RejectBlock} {
+		testRefreshRejected(t, policy)
+	}
+}
+
+func testRefreshRejected(t *testing.T, policy gopool.RejectPolicy) {
+	// worker 被阻塞且队列已满的 pool
+	pool := gopool.NewPool(1, gopool.WithRejectPolicy(policy, 0))
+	release, started := make(chan struct{}), make(chan struct{})
+	_ = pool.TrySubmit(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	_ = pool.TrySubmit(context.Background(), func() {})
+
+	var fetched int32
+	c := NewAsyncache(Options[string, int32]{
+		BlockIfFirst:    true,
+		RefreshDuration: time.Hour,
+		RefreshTimeout:  time.Minute,
+		RefreshPool:     pool,
+		Fetcher: func(key string) (int32, error) {
+			return atomic.AddInt32(&fetched, 1), nil
+		},
+	})
+	defer c.Close()
+	c.Get("a", 0)
+	c.Get("b", 0)
+
+	refreshDone := func(msg string) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			c.refresh()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("policy %v: %v", policy, msg)
+		}
+	}
+	// 被拒绝的任务释放并发额度, 本轮刷新不会一直等待
+	refreshDone("refresh should not hang on a full pool")
+	// 其它实例发来的刷新消息也不会阻塞订阅协程
+	handled := make(chan struct{})
+	go func() {
+		c.handleInvalidation(`{"op":"refresh","key":"a","source":"other"}`)
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatalf("policy %v: invalidation should not hang on a full pool", policy)
+	}
+	close(release)
+	pool.Close()
+	refreshDone("refresh should not hang on a closed pool")
+	if n := atomic.LoadInt32(&fetched); n != 2 {
+		t.Fatalf("policy %v: rejected refresh tasks should not run, fetched %v times", policy, n)
+	}
+	if v := c.Get("a", 0); v != 1 {
+		t.Fatalf("policy %v: old value should be kept, got %v", policy, v)
+	}
+}
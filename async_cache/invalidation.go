@@ -0,0 +1,137 @@
+package async_cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	invalidateOp = "invalidate"
+	refreshOp    = "refresh"
+)
+
+// invalidation 通过 redis pub/sub 广播给同一命名空间其它实例的消息
+type invalidation struct {
+	Op     string              `json:"op"`
+	Key    jsoniter.RawMessage `json:"key"`
+	Source string              `json:"source"`
+}
+
+// Invalidate 删除本实例中的key, 下次Get时重新拉取; 设置了 InvalidationClient 时同时通知其它实例删除该key.
+// 返回的error只表示广播失败, 本实例的删除总是生效的
+func (c *Asyncache[K, V]) Invalidate(key K) error {
+	c.invalidate(key)
+	return c.publish(invalidateOp, key)
+}
+
+// Refresh 立即重新拉取本实例中的key(key不在缓存中时不做任何事); 设置了 InvalidationClient 时同时通知其它实例重新拉取.
+// 本实例拉取失败时返回拉取的错误, 否则返回广播的错误
+func (c *Asyncache[K, V]) Refresh(key K) error {
+	fetchErr := c.refreshKey(key)
+	pubErr := c.publish(refreshOp, key)
+	if fetchErr != nil {
+		return fetchErr
+	}
+	return pubErr
+}
+
+func (c *Asyncache[K, V]) invalidate(key K) {
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	c.mu.Unlock()
+	c.sfg.Forget(key)
+}
+
+func (c *Asyncache[K, V]) refreshKey(key K) error {
+	c.mu.Lock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+	oldVal := elem.Value.(*entry[K, V]).val
+	c.mu.Unlock()
+
+	newVal, err := c.fetch(context.Background(), key)
+	if err != nil {
+		c.onRefreshErr(key, err)
+		return err
+	}
+	c.applyRefresh(key, oldVal, newVal)
+	return nil
+}
+
+func (c *Asyncache[K, V]) invalidationChannel() string {
+	return fmt.Sprintf("ASYNC_CACHE_INVALIDATION_%v", c.opt.Namespace)
+}
+
+func (c *Asyncache[K, V]) publish(op string, key K) error {
+	if c.opt.InvalidationClient == nil {
+		return nil
+	}
+	rawKey, err := jsoniter.Marshal(key)
+	if err != nil {
+		return err
+	}
+	msg, err := jsoniter.MarshalToString(&invalidation{Op: op, Key: rawKey, Source: c.instanceId})
+	if err != nil {
+		return err
+	}
+	err = c.opt.InvalidationClient.Publish(c.invalidationChannel(), msg).Err()
+	if err != nil {
+		logs.Warn("[Asyncache] publish invalidation", logs.String("err", err.Error()), logs.String("msg", msg))
+		return err
+	}
+	return nil
+}
+
+// subscribe 订阅命名空间的失效通知, 在 Close 时退出
+func (c *Asyncache[K, V]) subscribe() {
+	c.instanceId = fmt.Sprintf("%d_%d", time.Now().UnixNano(), rand.Int63())
+	c.pubsub = c.opt.InvalidationClient.Subscribe(c.invalidationChannel())
+	ch := c.pubsub.Channel()
+	go func() {
+		for msg := range ch {
+			c.handleInvalidation(msg.Payload)
+		}
+	}()
+}
+
+func (c *Asyncache[K, V]) handleInvalidation(payload string) {
+	msg := &invalidation{}
+	if err := jsoniter.UnmarshalFromString(payload, msg); err != nil {
+		logs.Warn("[Asyncache] invalid invalidation", logs.String("err", err.Error()), logs.String("payload", payload))
+		return
+	}
+	if msg.Source == c.instanceId {
+		return
+	}
+	var key K
+	if err := jsoniter.Unmarshal(msg.Key, &key); err != nil {
+		logs.Warn("[Asyncache] invalid invalidation key", logs.String("err", err.Error()), logs.String("payload", payload))
+		return
+	}
+
+	switch msg.Op {
+	case invalidateOp:
+		c.invalidate(key)
+	case refreshOp:
+		// 在订阅协程中执行, 不能阻塞后面的消息; pool 已满时放弃本次刷新, 等下一轮定时刷新
+		ctx := context.Background()
+		err := c.goRefresh(ctx, func() {
+			if err := c.refreshKey(key); err != nil {
+				logs.CtxWarn(ctx, "[Asyncache] refresh invalidated key", logs.String("err", err.Error()), logs.String("payload", payload))
+			}
+		})
+		if err != nil {
+			logs.CtxWarn(ctx, "[Asyncache] submit invalidation refresh", logs.String("err", err.Error()), logs.String("payload", payload))
+		}
+	}
+}
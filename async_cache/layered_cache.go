@@ -0,0 +1,151 @@
+package async_cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drip-in/eden_lib/el_tool"
+	"github.com/drip-in/eden_lib/logs"
+)
+
+// LayeredOptions 两级缓存的配置
+type LayeredOptions[K comparable, V any] struct {
+	// L1 进程内缓存的配置, 其中的 Fetcher/FetcherCtx/BatchFetcher 会被替换为读取L2, BlockIfFirst 总是为true
+	L1 Options[K, V]
+	// Storage L2存储, 一般是 el_tool.NewStorage 创建的redis存储
+	Storage el_tool.IStorage
+	// L2TTL 写入L2的过期时间, 0 表示不过期
+	L2TTL time.Duration
+	// Codec L2中值的序列化方式, 默认 el_tool.JsonCodec
+	Codec el_tool.Codec
+	// StorageKey 生成key在L2中的名字, 默认为 fmt.Sprint(key)
+	StorageKey func(key K) string
+	// Loader L2未命中时从数据源(如DB)加载, 数据不存在时返回 ErrNotFound
+	Loader func(ctx context.Context, key K) (V, error)
+	// Saver Set 时先写入数据源, 为nil时只写缓存
+	Saver func(ctx context.Context, key K, val V) error
+	// Deleter Delete 时先删除数据源, 为nil时只删缓存
+	Deleter func(ctx context.Context, key K) error
+}
+
+// LayeredCache 进程内 Asyncache(L1) + IStorage(L2) + 数据源 的三层读取,
+// 写入和删除会依次穿透数据源、L2、L1; 同一个key并发的L2未命中只会加载一次数据源
+type LayeredCache[K comparable, V any] struct {
+	opt LayeredOptions[K, V]
+	l1  *Asyncache[K, V]
+	sfg *Group
+}
+
+// NewLayeredCache .
+func NewLayeredCache[K comparable, V any](opt LayeredOptions[K, V]) *LayeredCache[K, V] {
+	if opt.Storage == nil || opt.Loader == nil {
+		panic("invalid layered cache config: Storage and Loader are required")
+	}
+	if opt.Codec == nil {
+		opt.Codec = el_tool.JsonCodec
+	}
+	if opt.StorageKey == nil {
+		opt.StorageKey = func(key K) string {
+			return fmt.Sprint(key)
+		}
+	}
+
+	c := &LayeredCache[K, V]{
+		opt: opt,
+		sfg: &Group{},
+	}
+	l1Opt := opt.L1
+	l1Opt.BlockIfFirst = true
+	l1Opt.BatchFetcher = nil
+	l1Opt.Fetcher = nil
+	l1Opt.FetcherCtx = c.loadThrough
+	c.l1 = NewAsyncache(l1Opt)
+	return c
+}
+
+// Get 依次从L1、L2、数据源读取; 数据不存在时返回 ErrNotFound
+func (c *LayeredCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	var zero V
+	val, freshness, err := c.l1.GetWithFreshnessCtx(ctx, key, zero)
+	if freshness == FreshnessNotFound {
+		return zero, ErrNotFound
+	}
+	return val, err
+}
+
+// Set 写入数据源(设置了 Saver 时)、L2和L1
+func (c *LayeredCache[K, V]) Set(ctx context.Context, key K, val V) error {
+	if c.opt.Saver != nil {
+		if err := c.opt.Saver(ctx, key, val); err != nil {
+			return err
+		}
+	}
+	if err := c.setL2(ctx, key, val); err != nil {
+		// L2写失败时删除旧值, 避免其它实例读到脏数据
+		_ = c.opt.Storage.Del(ctx, c.opt.StorageKey(key))
+		return err
+	}
+	return c.l1.Set(key, val)
+}
+
+// Delete 删除数据源(设置了 Deleter 时)、L2和L1中的key
+func (c *LayeredCache[K, V]) Delete(ctx context.Context, key K) error {
+	if c.opt.Deleter != nil {
+		if err := c.opt.Deleter(ctx, key); err != nil {
+			return err
+		}
+	}
+	storageKey := c.opt.StorageKey(key)
+	c.sfg.Forget(storageKey)
+	if err := c.opt.Storage.Del(ctx, storageKey); err != nil {
+		return err
+	}
+	return c.l1.Invalidate(key)
+}
+
+// L1 返回进程内缓存, 用于查看统计信息等
+func (c *LayeredCache[K, V]) L1() *Asyncache[K, V] {
+	return c.l1
+}
+
+// Close .
+func (c *LayeredCache[K, V]) Close() {
+	c.l1.Close()
+}
+
+// loadThrough L1未命中或刷新时调用: 先读L2, L2未命中时加载数据源并回填L2
+func (c *LayeredCache[K, V]) loadThrough(ctx context.Context, key K) (V, error) {
+	storageKey := c.opt.StorageKey(key)
+	val, err := c.getL2(ctx, storageKey)
+	if err == nil {
+		return val, nil
+	}
+	if !el_tool.IsNotFound(err) {
+		logs.CtxWarn(ctx, "[LayeredCache] get from storage", logs.String("err", err.Error()), logs.String("key", storageKey))
+	}
+
+	// 避免冷启动时大量请求同时打到数据源; 加载不使用调用方的ctx, 一个调用方取消不影响其它调用方
+	v, err, _ := c.sfg.DoCtx(ctx, storageKey, func() (interface{}, error) {
+		loadCtx, cancel := c.l1.fetchContext(ctx)
+		defer cancel()
+		val, err := c.opt.Loader(loadCtx, key)
+		if err != nil {
+			return val, err
+		}
+		if err := c.setL2(loadCtx, key, val); err != nil {
+			logs.CtxWarn(ctx, "[LayeredCache] set storage", logs.String("err", err.Error()), logs.String("key", storageKey))
+		}
+		return val, nil
+	})
+	val, _ = v.(V)
+	return val, err
+}
+
+func (c *LayeredCache[K, V]) getL2(ctx context.Context, storageKey string) (V, error) {
+	return el_tool.GetAs[V](ctx, c.opt.Storage, storageKey, c.opt.Codec)
+}
+
+func (c *LayeredCache[K, V]) setL2(ctx context.Context, key K, val V) error {
+	return el_tool.SetAs(ctx, c.opt.Storage, c.opt.StorageKey(key), val, c.opt.L2TTL, c.opt.Codec)
+}
@@ -0,0 +1,141 @@
+package async_cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drip-in/eden_lib/el_tool"
+	"github.com/go-redis/redis"
+)
+
+type mapStorage struct {
+	sync.Map
+}
+
+func (s *mapStorage) Set(ctx context.Context, key string, val interface{}, expiration time.Duration) error {
+	s.Store(key, val)
+	return nil
+}
+
+func (s *mapStorage) SetNX(ctx context.Context, key string, val interface{}, expiration time.Duration) error {
+	s.LoadOrStore(key, val)
+	return nil
+}
+
+func (s *mapStorage) Get(ctx context.Context, key string) (interface{}, error) {
+	val, ok := s.Load(key)
+	if !ok {
+		return nil, redis.Nil
+	}
+	return val, nil
+}
+
+func (s *mapStorage) Del(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		s.Delete(key)
+	}
+	return nil
+}
+
+type layeredValue struct {
+	Name string
+}
+
+func TestLayeredCache(t *testing.T) {
+	ctx := context.Background()
+	storage := &mapStorage{}
+	var loads int32
+	db := map[int]layeredValue{1: {Name: "a"}}
+	c := NewLayeredCache(LayeredOptions[int, layeredValue]{
+		Storage: storage,
+		Codec:   el_tool.GzipJsonCodec,
+		Loader: func(ctx context.Context, key int) (layeredValue, error) {
+			atomic.AddInt32(&loads, 1)
+			time.Sleep(10 * time.Millisecond)
+			val, ok := db[key]
+			if !ok {
+				return val, ErrNotFound
+			}
+			return val, nil
+		},
+		Saver: func(ctx context.Context, key int, val layeredValue) error {
+			db[key] = val
+			return nil
+		},
+	})
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if val, err := c.Get(ctx, 1); err != nil || val.Name != "a" {
+				t.Errorf("Get = %v, %v", val, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("concurrent cold gets should load once, loaded %v times", n)
+	}
+	if _, ok := storage.Load("1"); !ok {
+		t.Fatal("loaded value should be written to L2")
+	}
+
+	// 另一个实例可以直接从L2读取
+	other := NewLayeredCache(LayeredOptions[int, layeredValue]{
+		Storage: storage,
+		Codec:   el_tool.GzipJsonCodec,
+		Loader: func(ctx context.Context, key int) (layeredValue, error) {
+			t.Fatal("should read from L2")
+			return layeredValue{}, nil
+		},
+	})
+	defer other.Close()
+	if val, err := other.Get(ctx, 1); err != nil || val.Name != "a" {
+		t.Fatalf("Get from L2 = %v, %v", val, err)
+	}
+
+	if err := c.Set(ctx, 1, layeredValue{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := c.Get(ctx, 1); val.Name != "b" || db[1].Name != "b" {
+		t.Fatalf("write through failed: %v %v", val, db[1])
+	}
+	if err := c.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storage.Load("1"); ok || c.L1().Len() != 0 {
+		t.Fatal("delete through failed")
+	}
+
+	if _, err := c.Get(ctx, 2); err != ErrNotFound {
+		t.Fatalf("missing key should return ErrNotFound, got %v", err)
+	}
+}
+
+type layeredCtxKey struct{}
+
+func TestLayeredCacheCtx(t *testing.T) {
+	var got interface{}
+	c := NewLayeredCache(LayeredOptions[int, layeredValue]{
+		Storage: &mapStorage{},
+		Loader: func(ctx context.Context, key int) (layeredValue, error) {
+			got = ctx.Value(layeredCtxKey{})
+			return layeredValue{Name: "a"}, nil
+		},
+	})
+	defer c.Close()
+
+	ctx := context.WithValue(context.Background(), layeredCtxKey{}, "logid")
+	if _, err := c.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got != "logid" {
+		t.Fatalf("Loader should receive the ctx of Get, got %v", got)
+	}
+}
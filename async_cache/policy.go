@@ -0,0 +1,141 @@
+package async_cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound Fetcher/BatchFetcher 返回该错误(可以被wrap)表示数据不存在, 配置了 NegativeTTL 时会被缓存
+var ErrNotFound = errors.New("async_cache: not found")
+
+// Freshness 描述 GetWithFreshness 返回值的来源
+type Freshness int
+
+const (
+	// FreshnessDefault 返回的是调用方传入的默认值
+	FreshnessDefault Freshness = iota
+	// FreshnessFresh 返回的是最近一次拉取成功的值
+	FreshnessFresh
+	// FreshnessStale 最近的刷新失败了, 返回的是仍在 MaxStaleness 内的旧值
+	FreshnessStale
+	// FreshnessNotFound 命中了"数据不存在"的缓存, 返回的是默认值
+	FreshnessNotFound
+)
+
+func (f Freshness) String() string {
+	switch f {
+	case FreshnessFresh:
+		return "fresh"
+	case FreshnessStale:
+		return "stale"
+	case FreshnessNotFound:
+		return "not_found"
+	default:
+		return "default"
+	}
+}
+
+// Policy 拉取失败时的处理策略, 零值表示: 旧值一直可用, 失败后下一轮立即重试, 不缓存"数据不存在"
+type Policy struct {
+	// MaxStaleness 最后一次拉取成功之后, 旧值最多还能被使用多久; 超过后 Get 视为未命中. <=0 表示不限制
+	MaxStaleness time.Duration
+	// BaseBackoff 拉取失败后等待多久再重试, 之后每次失败翻倍; <=0 表示不退避
+	BaseBackoff time.Duration
+	// MaxBackoff 退避时长的上限, <=0 表示不限制
+	MaxBackoff time.Duration
+	// NegativeTTL 拉取结果为 ErrNotFound 时的缓存时长, <=0 表示不缓存, 当作普通错误处理
+	NegativeTTL time.Duration
+}
+
+func (p *Policy) backoff(failures int) time.Duration {
+	if p.BaseBackoff <= 0 || failures <= 0 {
+		return 0
+	}
+	d := p.BaseBackoff
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+		// 溢出保护
+		if d <= 0 {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+func (p *Policy) isNotFound(err error) bool {
+	return p.NegativeTTL > 0 && errors.Is(err, ErrNotFound)
+}
+
+type entry[K comparable, V any] struct {
+	key        K
+	val        V
+	hasVal     bool // false 表示 val 是默认值, 还没有拉取成功过
+	lastAccess time.Time
+	updatedAt  time.Time // 最后一次拉取成功的时间
+
+	failures  int
+	lastErr   error
+	nextRetry time.Time
+
+	notFoundUntil time.Time
+}
+
+func (e *entry[K, V]) notFound(now time.Time) bool {
+	return now.Before(e.notFoundUntil)
+}
+
+// setVal 记录一次成功的拉取
+func (e *entry[K, V]) setVal(val V, now time.Time) {
+	e.val = val
+	e.hasVal = true
+	e.updatedAt = now
+	e.failures = 0
+	e.lastErr = nil
+	e.nextRetry = time.Time{}
+	e.notFoundUntil = time.Time{}
+}
+
+// setErr 记录一次失败的拉取
+func (e *entry[K, V]) setErr(p *Policy, err error, now time.Time) {
+	if p.isNotFound(err) {
+		var zero V
+		e.val = zero
+		e.hasVal = false
+		e.failures = 0
+		e.lastErr = err
+		e.nextRetry = time.Time{}
+		e.notFoundUntil = now.Add(p.NegativeTTL)
+		return
+	}
+	e.failures++
+	e.lastErr = err
+	e.nextRetry = now.Add(p.backoff(e.failures))
+}
+
+// servable 判断缓存的值能否直接返回给调用方
+func (e *entry[K, V]) servable(p *Policy, now time.Time) (Freshness, bool) {
+	if e.notFound(now) {
+		return FreshnessNotFound, true
+	}
+	if !e.hasVal {
+		return FreshnessDefault, false
+	}
+	if e.failures == 0 {
+		return FreshnessFresh, true
+	}
+	if p.MaxStaleness > 0 && now.Sub(e.updatedAt) > p.MaxStaleness {
+		return FreshnessStale, false
+	}
+	return FreshnessStale, true
+}
+
+// needRefresh 判断key在本轮是否需要刷新
+func (e *entry[K, V]) needRefresh(now time.Time) bool {
+	return !e.notFound(now) && !now.Before(e.nextRetry)
+}
@@ -0,0 +1,253 @@
+package async_cache
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drip-in/eden_lib/gopool"
+	"github.com/drip-in/eden_lib/logs"
+)
+
+const (
+	defaultRefreshBatchSize   = 100
+	defaultRefreshConcurrency = 1
+	defaultFetchTimeout       = 10 * time.Second
+)
+
+func (c *Asyncache[K, V]) refresher() {
+	if c.opt.RefreshDuration <= 0 {
+		return
+	}
+	// Ticker 在消费不及时的时候会丢弃tick, 刷新耗时超过 RefreshDuration 时不会堆积
+	ticker := time.NewTicker(c.opt.RefreshDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.exit:
+			return
+		case <-ticker.C:
+			c.refresh()
+		}
+	}
+}
+
+// refresh 执行一轮刷新: 把存活的key切分成任务, 在 RefreshJitter 内打散后并发拉取;
+// 超过 RefreshTimeout 还没开始的任务直接跳过, 等待已开始的任务结束后返回
+func (c *Asyncache[K, V]) refresh() {
+	oldData := c.liveData()
+	if len(oldData) == 0 {
+		return
+	}
+
+	timeout := c.opt.RefreshTimeout
+	if timeout <= 0 {
+		timeout = c.opt.RefreshDuration
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tasks := c.splitRefreshTasks(oldData)
+	var interval time.Duration
+	if c.opt.RefreshJitter > 0 {
+		interval = c.opt.RefreshJitter / time.Duration(len(tasks))
+	}
+
+	sem := make(chan struct{}, c.refreshConcurrency())
+	wg := sync.WaitGroup{}
+	for i, keys := range tasks {
+		if i > 0 && interval > 0 && !c.sleep(ctx, interval) {
+			break
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		case <-c.exit:
+		}
+		if ctx.Err() != nil || c.closed() {
+			break
+		}
+
+		keys := keys
+		wg.Add(1)
+		err := c.goRefresh(ctx, func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			c.refreshKeys(keys, oldData)
+		})
+		if err != nil {
+			// 任务没有被执行, 这些key等下一轮再刷新
+			<-sem
+			wg.Done()
+			logs.CtxWarn(ctx, "[Asyncache] submit refresh task", logs.String("err", err.Error()))
+			if err == gopool.ErrPoolClosed {
+				break
+			}
+		}
+	}
+	wg.Wait()
+}
+
+// splitRefreshTasks 把key打乱后切分, 使用 BatchFetcher 时每个任务包含 RefreshBatchSize 个key
+func (c *Asyncache[K, V]) splitRefreshTasks(data map[K]V) [][]K {
+	keys := make([]K, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	rand.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+
+	batchSize := 1
+	if c.opt.BatchFetcher != nil {
+		batchSize = c.opt.RefreshBatchSize
+		if batchSize <= 0 {
+			batchSize = defaultRefreshBatchSize
+		}
+	}
+	tasks := make([][]K, 0, (len(keys)+batchSize-1)/batchSize)
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		tasks = append(tasks, keys[start:end])
+	}
+	return tasks
+}
+
+func (c *Asyncache[K, V]) refreshKeys(keys []K, oldData map[K]V) {
+	if c.opt.BatchFetcher == nil {
+		for _, key := range keys {
+			newVal, err := c.fetchOne(context.Background(), key)
+			if err != nil {
+				c.onRefreshErr(key, err)
+				continue
+			}
+			c.applyRefresh(key, oldData[key], newVal)
+		}
+		return
+	}
+
+	newData, err := c.opt.BatchFetcher(keys)
+	if err != nil {
+		for _, key := range keys {
+			c.onRefreshErr(key, err)
+		}
+		return
+	}
+	for _, key := range keys {
+		if newVal, ok := newData[key]; ok {
+			c.applyRefresh(key, oldData[key], newVal)
+		}
+	}
+}
+
+func (c *Asyncache[K, V]) applyRefresh(key K, oldVal, newVal V) {
+	if c.opt.IsSame != nil && !c.opt.IsSame(key, oldVal, newVal) {
+		if c.opt.ChangeHandler != nil {
+			go c.opt.ChangeHandler(key, oldVal, newVal)
+		}
+	}
+
+	c.update(key, newVal, nil)
+}
+
+func (c *Asyncache[K, V]) onRefreshErr(key K, err error) {
+	var zero V
+	c.update(key, zero, err)
+	if c.opt.Policy.isNotFound(err) {
+		return
+	}
+	atomic.AddUint64(&c.refreshFailures, 1)
+	if c.opt.ErrHandler != nil {
+		go c.opt.ErrHandler(key, err)
+	}
+}
+
+// fetch 拉取单个key, 没有设置 Fetcher/FetcherCtx 时使用 BatchFetcher
+func (c *Asyncache[K, V]) fetch(ctx context.Context, key K) (V, error) {
+	if c.opt.Fetcher != nil || c.opt.FetcherCtx != nil || c.opt.BatchFetcher == nil {
+		return c.fetchOne(ctx, key)
+	}
+	var val V
+	data, err := c.opt.BatchFetcher([]K{key})
+	if err != nil {
+		return val, err
+	}
+	val, ok := data[key]
+	if !ok {
+		return val, ErrNotFound
+	}
+	return val, nil
+}
+
+// fetchOne 使用 FetcherCtx 或 Fetcher 拉取单个key
+func (c *Asyncache[K, V]) fetchOne(ctx context.Context, key K) (V, error) {
+	if c.opt.FetcherCtx != nil {
+		return c.opt.FetcherCtx(ctx, key)
+	}
+	return c.opt.Fetcher(key)
+}
+
+// fetchContext 返回首次拉取使用的ctx: 保留调用方ctx中的值, 不随调用方取消, 截止时间为 FetchTimeout
+func (c *Asyncache[K, V]) fetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := c.opt.FetchTimeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	return context.WithTimeout(detachedContext{ctx}, timeout)
+}
+
+// detachedContext 只继承ctx中的值, 没有截止时间, 也不会被取消
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c *Asyncache[K, V]) refreshConcurrency() int {
+	if c.opt.RefreshConcurrency > 0 {
+		return c.opt.RefreshConcurrency
+	}
+	return defaultRefreshConcurrency
+}
+
+// goRefresh 提交刷新任务, 返回错误时f不会被执行;
+// 不使用 Submit, RejectDrop 策略下被丢弃的任务返回nil, 本轮刷新会一直等待
+func (c *Asyncache[K, V]) goRefresh(ctx context.Context, f func()) error {
+	if c.opt.RefreshPool != nil {
+		return c.opt.RefreshPool.TrySubmit(ctx, f)
+	}
+	gopool.CtxGo(ctx, f)
+	return nil
+}
+
+// sleep 等待d, 刷新截止或者缓存关闭时返回false
+func (c *Asyncache[K, V]) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-c.exit:
+		return false
+	}
+}
+
+func (c *Asyncache[K, V]) closed() bool {
+	select {
+	case <-c.exit:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,64 +1,320 @@
 package async_cache
 
 /*
-Copyright 2012 Google Inc.
-Licensed under the Apache License, Version 2.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-     http://www.apache.org/licenses/LICENSE-2.0
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
+Copyright 2013 The Go Authors. All rights reserved.
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file.
 */
 
 // Package singleflight provides a duplicate function call suppression
 // mechanism.
 
-// COPIED FROM https://github.com/golang/groupcache/blob/master/singleflight/singleflight.go
+// BASED ON https://github.com/golang/sync/blob/master/singleflight/singleflight.go
+// 在此基础上增加了 DoCtx 以及成功结果的短时缓存(ResultTTL)
 
-import "sync"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
 
-// call is an in-flight or completed Do call
+// errGoexit indicates the runtime.Goexit was called in
+// the user given function.
+var errGoexit = errors.New("runtime.Goexit was called")
+
+// A panicError is an arbitrary value recovered from a panic
+// with the stack trace during the execution of given function.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+// Error implements error interface.
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+func (p *panicError) Unwrap() error {
+	err, ok := p.value.(error)
+	if !ok {
+		return nil
+	}
+	return err
+}
+
+func newPanicError(v interface{}) error {
+	stack := debug.Stack()
+
+	// The first line of the stack trace is of the form "goroutine N [status]:"
+	// but by the time the panic reaches Do the goroutine may no longer exist
+	// and its status will have changed. Trim out the misleading line.
+	if line := bytes.IndexByte(stack, '\n'); line >= 0 {
+		stack = stack[line+1:]
+	}
+	return &panicError{value: v, stack: stack}
+}
+
+// call is an in-flight or completed singleflight.Do call
 type call struct {
-	wg  sync.WaitGroup
+	// done is closed when the call completes.
+	done chan struct{}
+
+	// These fields are written once before done is closed
+	// and are only read after done is closed.
 	val interface{}
 	err error
+
+	// These fields are read and written with the singleflight
+	// mutex held before done is closed, and are read but
+	// not written after done is closed.
+	dups  int
+	chans []chan<- Result
 }
 
-// Group represents a class of work and forms a namespace in which
-// units of work can be executed with duplicate suppression.
-type Group struct {
-	mu sync.Mutex       // protects m
-	m  map[string]*call // lazily initialized
+// cachedCall 执行成功后在 ResultTTL 内继续共享的结果
+type cachedCall struct {
+	val      interface{}
+	expireAt time.Time
+}
+
+// KeyedGroup represents a class of work and forms a namespace in
+// which units of work can be executed with duplicate suppression.
+// 与 Group 相同, 但直接以K作为key, 不同的key不会因为格式化成相同的字符串而合并
+type KeyedGroup[K comparable] struct {
+	// ResultTTL 执行成功的结果在返回后继续共享多久, 期间相同key的调用直接拿到该结果(Shared为true);
+	// 0 表示不缓存. 返回错误或panic的结果不会被缓存
+	ResultTTL time.Duration
+
+	mu     sync.Mutex        // protects m and cached
+	m      map[K]*call       // lazily initialized
+	cached map[K]*cachedCall // lazily initialized
+}
+
+// Group 以string作为key的 KeyedGroup
+type Group = KeyedGroup[string]
+
+// Result holds the results of Do, so they can be passed
+// on a channel.
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool
 }
 
 // Do executes and returns the results of the given function, making
 // sure that only one execution is in-flight for a given key at a
 // time. If a duplicate comes in, the duplicate caller waits for the
 // original to complete and receives the same results.
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// A panic or runtime.Goexit in fn is propagated to every waiting caller.
+func (g *KeyedGroup[K]) Do(key K, fn func() (interface{}, error)) (interface{}, error) {
+	v, err, _ := g.DoShared(key, fn)
+	return v, err
+}
+
+// DoShared is like Do but also reports whether v was given to multiple callers.
+func (g *KeyedGroup[K]) DoShared(key K, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
+	if v, ok := g.loadCached(key); ok {
+		g.mu.Unlock()
+		return v, nil, true
+	}
 	if g.m == nil {
-		g.m = make(map[string]*call)
+		g.m = make(map[K]*call)
 	}
 	if c, ok := g.m[key]; ok {
+		c.dups++
 		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		return g.wait(context.Background(), c, true)
 	}
-	c := new(call)
-	c.wg.Add(1)
+	c := &call{done: make(chan struct{})}
 	g.m[key] = c
 	g.mu.Unlock()
 
-	c.val, c.err = fn()
-	c.wg.Done()
+	g.doCall(c, key, fn, false)
+	return c.val, c.err, c.dups > 0
+}
 
+// DoChan is like Do but returns a channel that will receive the
+// results when they are ready.
+//
+// The returned channel will not be closed.
+func (g *KeyedGroup[K]) DoChan(key K, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+	if v, ok := g.loadCached(key); ok {
+		g.mu.Unlock()
+		ch <- Result{Val: v, Shared: true}
+		return ch
+	}
+	if g.m == nil {
+		g.m = make(map[K]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := &call{done: make(chan struct{}), chans: []chan<- Result{ch}}
+	g.m[key] = c
+	g.mu.Unlock()
+
+	go g.doCall(c, key, fn, false)
+
+	return ch
+}
+
+// DoCtx is like DoShared but the caller stops waiting when ctx is done and gets ctx.Err().
+// fn always runs in its own goroutine, so abandoning the wait does not cancel it:
+// it keeps running for the other callers, and its result is still shared
+// (and cached if ResultTTL is set). A panic or runtime.Goexit in fn is
+// propagated to the callers that are still waiting.
+func (g *KeyedGroup[K]) DoCtx(ctx context.Context, key K, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if v, ok := g.loadCached(key); ok {
+		g.mu.Unlock()
+		return v, nil, true
+	}
+	if g.m == nil {
+		g.m = make(map[K]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		return g.wait(ctx, c, true)
+	}
+	c := &call{done: make(chan struct{})}
+	g.m[key] = c
+	g.mu.Unlock()
+
+	go g.doCall(c, key, fn, true)
+	return g.wait(ctx, c, false)
+}
+
+// Forget tells the singleflight to forget about a key. Future calls
+// to Do for this key will call the function rather than waiting for
+// an earlier call to complete or using a cached result.
+func (g *KeyedGroup[K]) Forget(key K) {
 	g.mu.Lock()
 	delete(g.m, key)
+	delete(g.cached, key)
 	g.mu.Unlock()
+}
+
+// wait 等待c执行结束并返回结果, fn中的panic和Goexit会在调用方的goroutine中重新触发
+func (g *KeyedGroup[K]) wait(ctx context.Context, c *call, dup bool) (interface{}, error, bool) {
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return nil, ctx.Err(), false
+	}
 
-	return c.val, c.err
+	if e, ok := c.err.(*panicError); ok {
+		panic(e)
+	} else if c.err == errGoexit {
+		runtime.Goexit()
+	}
+	return c.val, c.err, dup || c.dups > 0
+}
+
+// loadCached 需持有锁
+func (g *KeyedGroup[K]) loadCached(key K) (interface{}, bool) {
+	cc, ok := g.cached[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(cc.expireAt) {
+		delete(g.cached, key)
+		return nil, false
+	}
+	return cc.val, true
+}
+
+// doCall handles the single call for a key.
+// detached 为true时由 wait 负责把panic传给调用方, doCall 自身不再panic
+func (g *KeyedGroup[K]) doCall(c *call, key K, fn func() (interface{}, error), detached bool) {
+	normalReturn := false
+	recovered := false
+
+	// use double-defer to distinguish panic from runtime.Goexit,
+	// more details see https://golang.org/cl/134395
+	defer func() {
+		// the given function invoked runtime.Goexit
+		if !normalReturn && !recovered {
+			c.err = errGoexit
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		close(c.done)
+		if g.m[key] == c {
+			delete(g.m, key)
+			if c.err == nil && g.ResultTTL > 0 {
+				g.cache(key, c.val)
+			}
+		}
+
+		if e, ok := c.err.(*panicError); ok {
+			// In order to prevent the waiting channels from being blocked forever,
+			// needs to ensure that this panic cannot be recovered.
+			if len(c.chans) > 0 {
+				go panic(e)
+				select {} // Keep this goroutine around so that it will appear in the crash dump.
+			} else if !detached {
+				panic(e)
+			}
+		} else if c.err == errGoexit {
+			// Already in the process of goexit, no need to call again
+		} else {
+			// Normal return
+			for _, ch := range c.chans {
+				ch <- Result{c.val, c.err, c.dups > 0}
+			}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				// Ideally, we would wait to take a stack trace until we've determined
+				// whether this is a panic or a runtime.Goexit.
+				//
+				// Unfortunately, the only way we can distinguish the two is to see
+				// whether the recover stopped the goroutine from terminating, and by
+				// the time we know that, the part of the stack trace relevant to the
+				// panic has been discarded.
+				if r := recover(); r != nil {
+					c.err = newPanicError(r)
+				}
+			}
+		}()
+
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
+}
+
+// cache 需持有锁
+func (g *KeyedGroup[K]) cache(key K, val interface{}) {
+	if g.cached == nil {
+		g.cached = make(map[K]*cachedCall)
+	}
+	cc := &cachedCall{val: val, expireAt: time.Now().Add(g.ResultTTL)}
+	g.cached[key] = cc
+	time.AfterFunc(g.ResultTTL, func() {
+		g.mu.Lock()
+		if g.cached[key] == cc {
+			delete(g.cached, key)
+		}
+		g.mu.Unlock()
+	})
 }
@@ -0,0 +1,121 @@
+package async_cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupDo(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	results := make(chan bool, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.DoShared("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "bar", nil
+			})
+			if v != "bar" || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+			results <- shared
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("number of calls = %d; want 1", n)
+	}
+	for shared := range results {
+		if !shared {
+			t.Fatal("result of concurrent calls should be shared")
+		}
+	}
+}
+
+func TestGroupDoCtxAbandon(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err, _ := g.DoCtx(ctx, "key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DoCtx err = %v", err)
+	}
+
+	// 放弃等待不会取消正在执行的函数, 后来的调用方共享它的结果
+	ch := g.DoChan("key", func() (interface{}, error) {
+		return 2, nil
+	})
+	close(release)
+	res := <-ch
+	if res.Val != 1 || !res.Shared {
+		t.Fatalf("DoChan = %+v", res)
+	}
+}
+
+func TestGroupPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	var recovered int32
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					atomic.AddInt32(&recovered, 1)
+				}
+			}()
+			_, _ = g.Do("key", func() (interface{}, error) {
+				<-release
+				panic("boom")
+			})
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&recovered); n != 3 {
+		t.Fatalf("panic should reach every caller, got %d", n)
+	}
+}
+
+func TestGroupResultTTLAndForget(t *testing.T) {
+	g := Group{ResultTTL: time.Hour}
+	var calls int32
+	fn := func() (interface{}, error) {
+		return atomic.AddInt32(&calls, 1), nil
+	}
+	v1, _ := g.Do("key", fn)
+	v2, _, shared := g.DoShared("key", fn)
+	if v1 != v2 || !shared {
+		t.Fatalf("cached result not shared: %v %v %v", v1, v2, shared)
+	}
+	g.Forget("key")
+	if v3, _ := g.Do("key", fn); v3 == v1 {
+		t.Fatal("Forget should drop the cached result")
+	}
+
+	if _, err := g.Do("err", func() (interface{}, error) { return nil, errors.New("fail") }); err == nil {
+		t.Fatal("expected error")
+	}
+	if v, err := g.Do("err", func() (interface{}, error) { return "ok", nil }); v != "ok" || err != nil {
+		t.Fatalf("errors should not be cached: %v %v", v, err)
+	}
+}
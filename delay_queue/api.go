@@ -1,7 +1,25 @@
 package delay_queue
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotInitialized 还没有创建过延迟队列实例, 包级函数没有可用的全局默认实例
+var ErrNotInitialized = errors.New("delay_queue: default delay queue not initialized")
 
 func PublishEvent(ctx context.Context, event *EventEntity) error {
-	return GetDelayQueue().PublishEvent(ctx, event)
+	q := GetDelayQueue()
+	if q == nil {
+		return ErrNotInitialized
+	}
+	return q.PublishEvent(ctx, event)
+}
+
+func PublishEvents(ctx context.Context, events []*EventEntity) ([]*EventEntity, error) {
+	q := GetDelayQueue()
+	if q == nil {
+		return nil, ErrNotInitialized
+	}
+	return q.PublishEvents(ctx, events)
 }
@@ -0,0 +1,75 @@
+package delay_queue
+
+import (
+	"context"
+	"time"
+)
+
+// IBackend 延迟队列的存储后端, 方法需要并发安全.
+// 事件的生命周期: Schedule 写入事件池和Bucket -> MoveDue 到期后移到待消费队列 -> Pop 出队 -> Ack 结束投递
+type IBackend interface {
+	// Schedule 原子的写入事件池和Bucket, EventId 已经存在的事件不做修改, 返回每个事件是否写入成功
+	Schedule(ctx context.Context, events []*EventEntity) ([]bool, error)
+	// MoveDue 把Bucket中生效时间不晚于now的事件按生效时间顺序移到待消费队列, 最多limit个, 返回移动的数量和Bucket中最早的生效时间(为空时返回零值);
+	// epoch>0 时与 NextEpoch 最后分配的任期号不一致则不移动并返回 ErrFenced
+	MoveDue(ctx context.Context, topic string, now time.Time, limit int64, epoch int64) (int64, time.Time, error)
+	// Pop 从待消费队列取出一个事件, 队列为空时最多等待wait, 仍然为空时返回的id为0;
+	// deadline 不为零值时放入处理中集合直到 Ack 或者超过deadline(按等待的时间顺延), 否则同时从事件池删除.
+	// 事件已经出队但读取不到内容时同时返回id和错误, 内容不存在时错误为 ErrEventNotFound
+	Pop(ctx context.Context, topic string, wait time.Duration, deadline time.Time) (int64, *EventEntity, error)
+	// Touch 延长处理中事件的可见性截止时间, 已经不在处理中集合的事件不做修改
+	Touch(ctx context.Context, topic string, eventId int64, deadline time.Time) error
+	// Reap 把可见性截止时间不晚于now的事件放回待消费队列, 最多limit个
+	Reap(ctx context.Context, topic string, now time.Time, limit int64) (int64, error)
+	// Ack 结束一次投递: 从处理中集合删除; retry不为空时更新事件池并放回Bucket, 否则从事件池删除; 同时写入死信
+	Ack(ctx context.Context, topic string, eventId int64, retry *EventEntity, dead []*DeadLetter) error
+
+	// Lookup 查询事件池中的事件, 不存在时返回 ErrEventNotFound
+	Lookup(ctx context.Context, topic string, eventId int64) (*EventEntity, error)
+	// Cancel 从Bucket、待消费队列和事件池中删除事件, 处理中的事件返回 ErrEventProcessing
+	Cancel(ctx context.Context, topic string, eventId int64) error
+	// Reschedule 修改事件的生效时间并放回Bucket, 处理中的事件返回 ErrEventProcessing
+	Reschedule(ctx context.Context, topic string, eventId int64, effectTime time.Time) error
+	// Count 返回Bucket、待消费队列和处理中集合的事件数量
+	Count(ctx context.Context, topic string) (pending, ready, processing int64, err error)
+	// ListPending 按生效时间顺序列出Bucket中生效时间在[from, to]之间的事件, limit<=0 表示不限制数量
+	ListPending(ctx context.Context, topic string, from, to time.Time, limit int64) ([]*EventEntity, error)
+
+	// ListDeadLetters 分页列出死信, cursor 从0开始, 返回的 nextCursor 为0表示已经遍历完
+	ListDeadLetters(ctx context.Context, topic string, cursor uint64, count int64) ([]*DeadLetter, uint64, error)
+	// GetDeadLetter 不存在时返回 ErrDeadLetterNotFound
+	GetDeadLetter(ctx context.Context, topic string, id string) (*DeadLetter, error)
+	// ReplayDeadLetter 原子的删除死信并把event写入事件池和Bucket, 死信不存在时返回 ErrDeadLetterNotFound
+	ReplayDeadLetter(ctx context.Context, topic string, id string, event *EventEntity) error
+	// PurgeDeadLetters 删除指定的死信, 不指定id时删除topic的全部死信
+	PurgeDeadLetters(ctx context.Context, topic string, ids ...string) error
+
+	// AddRecurring 原子的添加周期事件并写入第一次触发的事件, 同名的周期事件已经存在时返回 ErrScheduleExists
+	AddRecurring(ctx context.Context, s *RecurringSchedule, event *EventEntity) error
+	// GetRecurring 不存在时返回 ErrScheduleNotFound
+	GetRecurring(ctx context.Context, topic string, name string) (*RecurringSchedule, error)
+	// AdvanceRecurring 周期事件等待的触发仍为prevEventId时, 原子的更新为next并写入event, 返回是否更新
+	AdvanceRecurring(ctx context.Context, prevEventId int64, next *RecurringSchedule, event *EventEntity) (bool, error)
+	ListRecurring(ctx context.Context, topic string) ([]*RecurringSchedule, error)
+	// RemoveRecurring 删除周期事件并返回删除前的内容, 不存在时返回 ErrScheduleNotFound
+	RemoveRecurring(ctx context.Context, topic string, name string) (*RecurringSchedule, error)
+
+	// NextEpoch 为新当选的leader分配递增的任期号
+	NextEpoch(ctx context.Context) (int64, error)
+	// Notify 通知所有订阅了的实例topic有即将生效的事件
+	Notify(ctx context.Context, topic string) error
+	// Subscribe 接收 Notify 的通知, 返回取消订阅的函数
+	Subscribe(fn func(topic string)) (cancel func())
+}
+
+// sleepCtx 等待d, ctx结束时提前返回false
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
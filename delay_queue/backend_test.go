@@ -0,0 +1,444 @@
+package delay_queue
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 存储后端的一致性测试: 内存和redis后端需要有相同的行为
+
+func newBackendEvent(id int64, effectTime time.Time) *EventEntity {
+	return &EventEntity{EventId: id, Topic: "topic", Body: fmt.Sprintf("body_%v", id), EffectTime: effectTime}
+}
+
+func checkCount(t *testing.T, b IBackend, pending, ready, processing int64) {
+	t.Helper()
+	p, r, c, err := b.Count(context.Background(), "topic")
+	if err != nil || p != pending || r != ready || c != processing {
+		t.Fatalf("Count = %v, %v, %v, %v, want %v, %v, %v", p, r, c, err, pending, ready, processing)
+	}
+}
+
+func eventIds(events []*EventEntity) []int64 {
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.EventId)
+	}
+	return ids
+}
+
+func TestBackendScheduleAndMoveDue(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		b := newBackend()
+		ctx := context.Background()
+		now := time.Now()
+		future := now.Add(time.Hour)
+
+		events := []*EventEntity{
+			newBackendEvent(3, now.Add(-time.Second)),
+			newBackendEvent(1, now.Add(-3*time.Second)),
+			newBackendEvent(2, now.Add(-2*time.Second)),
+			newBackendEvent(4, future),
+		}
+		if added, err := b.Schedule(ctx, events); err != nil || !reflect.DeepEqual(added, []bool{true, true, true, true}) {
+			t.Fatalf("Schedule = %v, %v", added, err)
+		}
+		// 已经存在的事件不做修改
+		if added, err := b.Schedule(ctx, []*EventEntity{newBackendEvent(1, future), newBackendEvent(5, future)}); err != nil || !reflect.DeepEqual(added, []bool{false, true}) {
+			t.Fatalf("Schedule duplicate = %v, %v", added, err)
+		}
+		if got, err := b.Lookup(ctx, "topic", 1); err != nil || got.Body != "body_1" || got.EffectTime.Unix() != events[1].EffectTime.Unix() {
+			t.Fatalf("Lookup = %+v, %v", got, err)
+		}
+		if _, err := b.Lookup(ctx, "topic", 6); err != ErrEventNotFound {
+			t.Fatalf("Lookup missing event = %v", err)
+		}
+		checkCount(t, b, 5, 0, 0)
+
+		// 按生效时间顺序搬运, 最多limit个
+		if moved, _, err := b.MoveDue(ctx, "topic", now, 2, 0); err != nil || moved != 2 {
+			t.Fatalf("MoveDue = %v, %v", moved, err)
+		}
+		moved, earliest, err := b.MoveDue(ctx, "topic", now, 10, 0)
+		if err != nil || moved != 1 {
+			t.Fatalf("MoveDue rest = %v, %v", moved, err)
+		}
+		if d := earliest.Sub(future); d < -time.Millisecond || d > memoryWheelTick {
+			t.Fatalf("earliest = %v, want %v", earliest, future)
+		}
+		checkCount(t, b, 2, 3, 0)
+
+		pending, err := b.ListPending(ctx, "topic", now, future, 0)
+		if err != nil || !reflect.DeepEqual(eventIds(pending), []int64{4, 5}) {
+			t.Fatalf("ListPending = %v, %v", eventIds(pending), err)
+		}
+		if pending, err = b.ListPending(ctx, "topic", now, future, 1); err != nil || len(pending) != 1 {
+			t.Fatalf("ListPending with limit = %v, %v", eventIds(pending), err)
+		}
+
+		// 待消费队列按搬运的顺序出队, 非可靠模式出队后从事件池删除
+		for _, want := range []int64{1, 2, 3} {
+			id, event, err := b.Pop(ctx, "topic", 10*time.Millisecond, time.Time{})
+			if err != nil || id != want || event.Body != fmt.Sprintf("body_%v", want) {
+				t.Fatalf("Pop = %v, %+v, %v, want %v", id, event, err, want)
+			}
+		}
+		if _, err = b.Lookup(ctx, "topic", 1); err != ErrEventNotFound {
+			t.Fatalf("Lookup popped event = %v", err)
+		}
+		checkCount(t, b, 2, 0, 0)
+
+		// Bucket为空时earliest为零值
+		for _, id := range []int64{4, 5} {
+			if err = b.Cancel(ctx, "topic", id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if moved, earliest, err = b.MoveDue(ctx, "topic", now, 10, 0); err != nil || moved != 0 || !earliest.IsZero() {
+			t.Fatalf("MoveDue empty bucket = %v, %v, %v", moved, earliest, err)
+		}
+	})
+}
+
+func TestBackendReliablePop(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		b := newBackend()
+		ctx := context.Background()
+		if _, err := b.Schedule(ctx, []*EventEntity{newBackendEvent(1, time.Now().Add(-time.Second))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := b.MoveDue(ctx, "topic", time.Now(), 10, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(time.Minute)
+		if id, event, err := b.Pop(ctx, "topic", 10*time.Millisecond, deadline); err != nil || id != 1 || event.Body != "body_1" {
+			t.Fatalf("Pop = %v, %+v, %v", id, event, err)
+		}
+		// 队列为空时等待后返回0
+		if id, _, err := b.Pop(ctx, "topic", 10*time.Millisecond, deadline); err != nil || id != 0 {
+			t.Fatalf("Pop empty queue = %v, %v", id, err)
+		}
+		checkCount(t, b, 0, 0, 1)
+		// 处理中的事件留在事件池, 不能取消或者修改
+		if _, err := b.Lookup(ctx, "topic", 1); err != nil {
+			t.Fatalf("Lookup processing event = %v", err)
+		}
+		if err := b.Cancel(ctx, "topic", 1); err != ErrEventProcessing {
+			t.Fatalf("Cancel processing event = %v", err)
+		}
+		if err := b.Reschedule(ctx, "topic", 1, time.Now()); err != ErrEventProcessing {
+			t.Fatalf("Reschedule processing event = %v", err)
+		}
+
+		// 截止时间之前不会被放回待消费队列
+		if n, err := b.Reap(ctx, "topic", time.Now(), 10); err != nil || n != 0 {
+			t.Fatalf("Reap before deadline = %v, %v", n, err)
+		}
+		if err := b.Touch(ctx, "topic", 1, time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := b.Reap(ctx, "topic", time.Now(), 10); err != nil || n != 1 {
+			t.Fatalf("Reap after deadline = %v, %v", n, err)
+		}
+		checkCount(t, b, 0, 1, 0)
+
+		// 确认后从处理中集合和事件池删除
+		if id, _, err := b.Pop(ctx, "topic", 10*time.Millisecond, deadline); err != nil || id != 1 {
+			t.Fatalf("Pop redelivered = %v, %v", id, err)
+		}
+		if err := b.Ack(ctx, "topic", 1, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Lookup(ctx, "topic", 1); err != ErrEventNotFound {
+			t.Fatalf("Lookup acked event = %v", err)
+		}
+		// 不在处理中集合的事件不做修改
+		if err := b.Touch(ctx, "topic", 1, deadline); err != nil {
+			t.Fatal(err)
+		}
+		checkCount(t, b, 0, 0, 0)
+	})
+}
+
+func TestBackendAckAndDeadLetters(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		b := newBackend()
+		ctx := context.Background()
+		now := time.Now()
+		if _, err := b.Schedule(ctx, []*EventEntity{newBackendEvent(1, now.Add(-2*time.Second)), newBackendEvent(2, now.Add(-time.Second))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := b.MoveDue(ctx, "topic", now, 10, 0); err != nil {
+			t.Fatal(err)
+		}
+		popped := make(map[int64]*EventEntity)
+		for i := 0; i < 2; i++ {
+			id, event, err := b.Pop(ctx, "topic", 10*time.Millisecond, now.Add(time.Minute))
+			if err != nil || id == 0 {
+				t.Fatalf("Pop = %v, %v", id, err)
+			}
+			popped[id] = event
+		}
+
+		// 重试: 更新事件池并放回Bucket
+		retry := *popped[1]
+		retry.Attempt = 1
+		retry.Subscribers = []string{"a"}
+		retry.EffectTime = now.Add(time.Hour)
+		if err := b.Ack(ctx, "topic", 1, &retry, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := b.Lookup(ctx, "topic", 1); err != nil || got.Attempt != 1 || !reflect.DeepEqual(got.Subscribers, []string{"a"}) {
+			t.Fatalf("Lookup retried event = %+v, %v", got, err)
+		}
+		if pending, err := b.ListPending(ctx, "topic", now, now.Add(2*time.Hour), 0); err != nil || !reflect.DeepEqual(eventIds(pending), []int64{1}) {
+			t.Fatalf("ListPending = %v, %v", eventIds(pending), err)
+		}
+
+		// 死信: 从事件池删除并写入死信
+		var dead []*DeadLetter
+		for _, name := range []string{"a", "b", "c"} {
+			dead = append(dead, &DeadLetter{Id: genDeadLetterId(2, name), Event: popped[2], Subscriber: name, LastError: "mock", DeadTime: now})
+		}
+		if err := b.Ack(ctx, "topic", 2, nil, dead); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Lookup(ctx, "topic", 2); err != ErrEventNotFound {
+			t.Fatalf("Lookup dead event = %v", err)
+		}
+		checkCount(t, b, 1, 0, 0)
+		letters, cursor, err := b.ListDeadLetters(ctx, "topic", 0, 10)
+		var ids []string
+		for _, letter := range letters {
+			ids = append(ids, letter.Id)
+		}
+		sort.Strings(ids)
+		if err != nil || cursor != 0 || !reflect.DeepEqual(ids, []string{"2:a", "2:b", "2:c"}) {
+			t.Fatalf("ListDeadLetters = %v, %v, %v", ids, cursor, err)
+		}
+		if letter, err := b.GetDeadLetter(ctx, "topic", "2:a"); err != nil || letter.LastError != "mock" || letter.Event.Body != "body_2" {
+			t.Fatalf("GetDeadLetter = %+v, %v", letter, err)
+		}
+		if _, err := b.GetDeadLetter(ctx, "topic", "2:d"); err != ErrDeadLetterNotFound {
+			t.Fatalf("GetDeadLetter missing = %v", err)
+		}
+
+		// 重放: 删除死信并写入事件池和Bucket
+		replay := *popped[2]
+		replay.Subscribers = []string{"a"}
+		if err = b.ReplayDeadLetter(ctx, "topic", "2:a", &replay); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = b.GetDeadLetter(ctx, "topic", "2:a"); err != ErrDeadLetterNotFound {
+			t.Fatalf("GetDeadLetter replayed = %v", err)
+		}
+		if got, err := b.Lookup(ctx, "topic", 2); err != nil || !reflect.DeepEqual(got.Subscribers, []string{"a"}) {
+			t.Fatalf("Lookup replayed event = %+v, %v", got, err)
+		}
+		checkCount(t, b, 2, 0, 0)
+		if err = b.ReplayDeadLetter(ctx, "topic", "2:a", &replay); err != ErrDeadLetterNotFound {
+			t.Fatalf("ReplayDeadLetter twice = %v", err)
+		}
+
+		// 按id删除, 不指定id时全部删除
+		if err = b.PurgeDeadLetters(ctx, "topic", "2:b"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = b.GetDeadLetter(ctx, "topic", "2:b"); err != ErrDeadLetterNotFound {
+			t.Fatalf("GetDeadLetter purged = %v", err)
+		}
+		if _, err = b.GetDeadLetter(ctx, "topic", "2:c"); err != nil {
+			t.Fatalf("GetDeadLetter not purged = %v", err)
+		}
+		if err = b.PurgeDeadLetters(ctx, "topic"); err != nil {
+			t.Fatal(err)
+		}
+		if letters, _, err = b.ListDeadLetters(ctx, "topic", 0, 10); err != nil || len(letters) != 0 {
+			t.Fatalf("ListDeadLetters after purge = %v, %v", len(letters), err)
+		}
+	})
+}
+
+func TestBackendCancelAndReschedule(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		b := newBackend()
+		ctx := context.Background()
+		now := time.Now()
+		if _, err := b.Schedule(ctx, []*EventEntity{newBackendEvent(1, now.Add(-time.Second)), newBackendEvent(2, now.Add(time.Hour))}); err != nil {
+			t.Fatal(err)
+		}
+		if moved, _, err := b.MoveDue(ctx, "topic", now, 10, 0); err != nil || moved != 1 {
+			t.Fatalf("MoveDue = %v, %v", moved, err)
+		}
+
+		// 已经到期进入待消费队列的事件也会被移回Bucket
+		if err := b.Reschedule(ctx, "topic", 1, now.Add(2*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		checkCount(t, b, 2, 0, 0)
+		pending, err := b.ListPending(ctx, "topic", now, now.Add(3*time.Hour), 0)
+		if err != nil || !reflect.DeepEqual(eventIds(pending), []int64{2, 1}) {
+			t.Fatalf("ListPending = %v, %v", eventIds(pending), err)
+		}
+		if pending[1].EffectTime.Unix() != now.Add(2*time.Hour).Unix() {
+			t.Fatalf("rescheduled effect time = %v", pending[1].EffectTime)
+		}
+		if err = b.Reschedule(ctx, "topic", 3, now); err != ErrEventNotFound {
+			t.Fatalf("Reschedule missing event = %v", err)
+		}
+
+		if err = b.Cancel(ctx, "topic", 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = b.Lookup(ctx, "topic", 1); err != ErrEventNotFound {
+			t.Fatalf("Lookup canceled event = %v", err)
+		}
+		if err = b.Cancel(ctx, "topic", 1); err != ErrEventNotFound {
+			t.Fatalf("Cancel twice = %v", err)
+		}
+		checkCount(t, b, 1, 0, 0)
+	})
+}
+
+func TestBackendRecurring(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		b := newBackend()
+		ctx := context.Background()
+		next := time.Now().Add(time.Hour)
+		s := &RecurringSchedule{Name: "tick", Topic: "topic", Interval: time.Second, EventId: 1, NextTime: next, CreateTime: time.Now()}
+		first := newBackendEvent(1, next)
+		first.Recurring = "tick"
+		if err := b.AddRecurring(ctx, s, first); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.AddRecurring(ctx, s, newBackendEvent(3, next)); err != ErrScheduleExists {
+			t.Fatalf("AddRecurring duplicate = %v", err)
+		}
+		if _, err := b.Lookup(ctx, "topic", 3); err != ErrEventNotFound {
+			t.Fatalf("event of duplicate schedule should not be written: %v", err)
+		}
+		checkCount(t, b, 1, 0, 0)
+		if got, err := b.GetRecurring(ctx, "topic", "tick"); err != nil || got.EventId != 1 || got.Interval != time.Second {
+			t.Fatalf("GetRecurring = %+v, %v", got, err)
+		}
+		if _, err := b.GetRecurring(ctx, "topic", "other"); err != ErrScheduleNotFound {
+			t.Fatalf("GetRecurring missing = %v", err)
+		}
+
+		// 等待的触发不是prevEventId时不更新
+		advanced := *s
+		advanced.EventId = 2
+		advanced.NextTime = next.Add(time.Second)
+		second := newBackendEvent(2, advanced.NextTime)
+		second.Recurring = "tick"
+		if ok, err := b.AdvanceRecurring(ctx, 3, &advanced, second); err != nil || ok {
+			t.Fatalf("AdvanceRecurring with other prev = %v, %v", ok, err)
+		}
+		if _, err := b.Lookup(ctx, "topic", 2); err != ErrEventNotFound {
+			t.Fatalf("event should not be written: %v", err)
+		}
+		if ok, err := b.AdvanceRecurring(ctx, 1, &advanced, second); err != nil || !ok {
+			t.Fatalf("AdvanceRecurring = %v, %v", ok, err)
+		}
+		if _, err := b.Lookup(ctx, "topic", 2); err != nil {
+			t.Fatalf("Lookup next occurrence = %v", err)
+		}
+		if schedules, err := b.ListRecurring(ctx, "topic"); err != nil || len(schedules) != 1 || schedules[0].EventId != 2 {
+			t.Fatalf("ListRecurring = %v, %v", schedules, err)
+		}
+
+		if removed, err := b.RemoveRecurring(ctx, "topic", "tick"); err != nil || removed.EventId != 2 {
+			t.Fatalf("RemoveRecurring = %+v, %v", removed, err)
+		}
+		if _, err := b.RemoveRecurring(ctx, "topic", "tick"); err != ErrScheduleNotFound {
+			t.Fatalf("RemoveRecurring twice = %v", err)
+		}
+	})
+}
+
+func TestBackendEpochAndNotify(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		b := newBackend()
+		ctx := context.Background()
+		stale, err := b.NextEpoch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		epoch, err := b.NextEpoch(ctx)
+		if err != nil || epoch <= stale {
+			t.Fatalf("NextEpoch = %v, %v, previous %v", epoch, err, stale)
+		}
+		if _, _, err = b.MoveDue(ctx, "topic", time.Now(), 10, stale); err != ErrFenced {
+			t.Fatalf("MoveDue with stale epoch = %v", err)
+		}
+		if _, _, err = b.MoveDue(ctx, "topic", time.Now(), 10, epoch); err != nil {
+			t.Fatalf("MoveDue with current epoch = %v", err)
+		}
+
+		notified := make(chan string, 1)
+		cancel := b.Subscribe(func(topic string) {
+			select {
+			case notified <- topic:
+			default:
+			}
+		})
+		defer cancel()
+		// redis 的订阅是异步建立的, 收到之前持续通知
+		waitUntil(t, time.Second, func() bool {
+			if err := b.Notify(ctx, "topic"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case topic := <-notified:
+				return topic == "topic"
+			case <-time.After(10 * time.Millisecond):
+				return false
+			}
+		}, "Subscribe should receive Notify")
+	})
+}
+
+func TestRedisBackendLegacyScore(t *testing.T) {
+	client, ns := testRedisClient(t)
+	b := NewRedisBackend(ns, client)
+	ctx := context.Background()
+	now := time.Now()
+	future := now.Add(time.Hour)
+	if _, err := b.Schedule(ctx, []*EventEntity{newBackendEvent(1, future), newBackendEvent(2, now.Add(-time.Second))}); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟旧版本写入的秒级score
+	if err := client.ZAdd(b.genBucketKey("topic"),
+		redis.Z{Score: float64(future.Unix()), Member: "1"},
+		redis.Z{Score: float64(now.Unix() - 1), Member: "2"}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	moved, earliest, err := b.MoveDue(ctx, "topic", now, 10, 0)
+	if err != nil || moved != 1 || earliest.Unix() != future.Unix() {
+		t.Fatalf("MoveDue = %v, %v, %v", moved, earliest, err)
+	}
+	if score, err := client.ZScore(b.genBucketKey("topic"), "1").Result(); err != nil || int64(score) != future.Unix()*1000 {
+		t.Fatalf("legacy score should be rescaled, got %v, %v", score, err)
+	}
+
+	// 处理中集合的秒级截止时间同样换算
+	if id, _, err := b.Pop(ctx, "topic", 10*time.Millisecond, future); err != nil || id != 2 {
+		t.Fatalf("Pop = %v, %v", id, err)
+	}
+	if err = client.ZAdd(b.genProcessingKey("topic"), redis.Z{Score: float64(future.Unix()), Member: "2"}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.Reap(ctx, "topic", now, 10); err != nil || n != 0 {
+		t.Fatalf("Reap before the legacy deadline = %v, %v", n, err)
+	}
+	if n, err := b.Reap(ctx, "topic", future.Add(time.Second), 10); err != nil || n != 1 {
+		t.Fatalf("Reap after the legacy deadline = %v, %v", n, err)
+	}
+}
@@ -0,0 +1,212 @@
+package delay_queue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 周期事件的触发规则
+type Schedule interface {
+	// Next 返回t之后的下一次触发时间
+	Next(t time.Time) time.Time
+}
+
+// everySchedule 固定间隔触发
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// Every 返回每隔d触发一次的规则, d 最小为1秒
+func Every(d time.Duration) Schedule {
+	if d < time.Second {
+		d = time.Second
+	}
+	return everySchedule{interval: d}
+}
+
+// cronSchedule 标准的5段cron表达式: 分 时 日 月 周, 使用本地时区
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// 日和周都不是 * 时, 满足其中一个即可
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = cronField{0, 59, nil}
+	hourField   = cronField{0, 23, nil}
+	domField    = cronField{1, 31, nil}
+	monthField  = cronField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = cronField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// ParseCron 解析cron表达式, 支持 * , - / 、月份和星期的英文缩写、@daily 等描述符以及 "@every 1h30m"
+func ParseCron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("delay_queue: invalid cron %q: %v", expr, err)
+		}
+		return Every(d), nil
+	}
+	if spec, ok := cronDescriptors[expr]; ok {
+		expr = spec
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("delay_queue: invalid cron %q: expected 5 fields", expr)
+	}
+
+	s := &cronSchedule{
+		domStar: isStarField(fields[2]),
+		dowStar: isStarField(fields[4]),
+	}
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&s.minute, minuteField},
+		{&s.hour, hourField},
+		{&s.dom, domField},
+		{&s.month, monthField},
+		{&s.dow, dowField},
+	} {
+		if *f.bits, err = f.field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("delay_queue: invalid cron %q: %v", expr, err)
+		}
+	}
+	// 周日可以写成0或7
+	if s.dow&(1<<7) > 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func (f cronField) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+			rangeExpr, step = part[:i], n
+		}
+
+		var lo, hi int
+		switch {
+		case rangeExpr == "*" || rangeExpr == "?":
+			lo, hi = f.min, f.max
+		case strings.Contains(rangeExpr, "-"):
+			bounds := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := f.value(rangeExpr)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			// 5/15 表示从5开始每隔15
+			if step > 1 {
+				hi = f.max
+			}
+		}
+		if lo > hi {
+			return 0, fmt.Errorf("bad range %q", part)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// maxCronSearchYears 找不到下一次触发时间(例如2月30日)时最多查找的年数
+const maxCronSearchYears = 5
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.In(time.Local).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxCronSearchYears, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) > 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) > 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// isStarField 与 robfig/cron 相同, 以 * 或 ? 开头的字段(例如 */2)视为通配, 日和周需要同时满足
+func isStarField(field string) bool {
+	return strings.HasPrefix(field, "*") || strings.HasPrefix(field, "?")
+}
@@ -0,0 +1,83 @@
+package delay_queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2023, 5, 10, 10, 30, 0, 0, time.Local) // 周三
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2023, 5, 10, 10, 31, 0, 0, time.Local)},
+		{"0 * * * *", time.Date(2023, 5, 10, 11, 0, 0, 0, time.Local)},
+		{"*/20 * * * *", time.Date(2023, 5, 10, 10, 40, 0, 0, time.Local)},
+		{"15 2 * * *", time.Date(2023, 5, 11, 2, 15, 0, 0, time.Local)},
+		{"0 9-17/4 * * *", time.Date(2023, 5, 10, 13, 0, 0, 0, time.Local)},
+		{"0 0 1 jan *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"0 0 * * sun", time.Date(2023, 5, 14, 0, 0, 0, 0, time.Local)},
+		{"0 0 * * 7", time.Date(2023, 5, 14, 0, 0, 0, 0, time.Local)},
+		{"0 0 20 * mon", time.Date(2023, 5, 15, 0, 0, 0, 0, time.Local)},
+		{"0 0 */2 * mon", time.Date(2023, 5, 15, 0, 0, 0, 0, time.Local)},
+		{"0 0 11 * */3", time.Date(2023, 6, 11, 0, 0, 0, 0, time.Local)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.Local)},
+		{"@daily", time.Date(2023, 5, 11, 0, 0, 0, 0, time.Local)},
+		{"@every 90m", base.Add(90 * time.Minute)},
+	}
+	for _, c := range cases {
+		s, err := ParseCron(c.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", c.expr, err)
+		}
+		if got := s.Next(base); !got.Equal(c.want) {
+			t.Errorf("ParseCron(%q).Next = %v, want %v", c.expr, got, c.want)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@every x"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) expected error", expr)
+		}
+	}
+
+	s, _ := ParseCron("0 0 30 2 *")
+	if got := s.Next(base); !got.IsZero() {
+		t.Errorf("impossible cron fired at %v", got)
+	}
+}
+
+func TestNextOccurrenceMisfire(t *testing.T) {
+	prev := time.Date(2023, 5, 10, 10, 0, 0, 0, time.Local)
+	now := prev.Add(3*time.Hour + 30*time.Minute)
+	for _, schedule := range []Schedule{Every(time.Hour), mustParseCron(t, "0 * * * *")} {
+		cases := []struct {
+			policy MisfirePolicy
+			want   time.Time
+		}{
+			{MisfireSkip, prev.Add(4 * time.Hour)},
+			{MisfireFireOnce, prev.Add(3 * time.Hour)},
+			{MisfireCatchUp, prev.Add(time.Hour)},
+		}
+		for _, c := range cases {
+			s := &RecurringSchedule{Misfire: c.policy}
+			if got := s.nextOccurrence(schedule, prev, now); !got.Equal(c.want) {
+				t.Errorf("policy %v: got %v, want %v", c.policy, got, c.want)
+			}
+		}
+		// 没有错过时三种策略一样
+		s := &RecurringSchedule{Misfire: MisfireSkip}
+		if got := s.nextOccurrence(schedule, prev, prev.Add(time.Minute)); !got.Equal(prev.Add(time.Hour)) {
+			t.Errorf("got %v", got)
+		}
+	}
+}
+
+func mustParseCron(t *testing.T, expr string) Schedule {
+	s, err := ParseCron(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
@@ -2,13 +2,13 @@ package delay_queue
 
 import (
 	"context"
-	"fmt"
+	"github.com/drip-in/eden_lib/el_tool"
 	"github.com/drip-in/eden_lib/el_utils"
 	"github.com/drip-in/eden_lib/godash/maps"
+	"github.com/drip-in/eden_lib/id_gen"
 	"github.com/drip-in/eden_lib/logs"
+	"github.com/drip-in/eden_lib/metrics"
 	"github.com/go-redis/redis"
-	jsoniter "github.com/json-iterator/go"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,57 +17,135 @@ import (
 type PersistFn func(event *EventEntity) error
 
 var (
+	// DelayQueueImpl 全局默认的延迟队列, 供 PublishEvent 等包级函数使用; 通过 InitDelayQueueImpl 和 GetDelayQueue 读写
 	DelayQueueImpl *DelayQueue
+	// implLock 保护 DelayQueueImpl, 多个实例并发创建时只有第一个成为全局默认实例
+	implLock sync.RWMutex
 )
 
+// defaultConsumerCount 每个topic默认的消费者数量
+const defaultConsumerCount = 1
+
 type DelayQueue struct {
-	namespace   string
-	redisClient *redis.Client
-	once        sync.Once
-	wg          sync.WaitGroup
-	isRunning   int32
-	stop        chan struct{}
-	persistFn   PersistFn
+	namespace string
+	backend   IBackend
+	// ShutDown 时取消, 用于中断阻塞的出队
+	ctx       context.Context
+	cancel    context.CancelFunc
+	once      sync.Once
+	wg        sync.WaitGroup
+	isRunning int32
+	stop      chan struct{}
+	persistFn PersistFn
+
+	// 可靠消费模式下的可见性超时, 0 表示不开启
+	visibilityTimeout time.Duration
+	// 发布时为没有 EventId 的事件分配id
+	idGenerator id_gen.IIdGenerator
+	// 每个topic的消费者数量
+	consumerCounts map[string]int
+	// 每个topic的订阅者
+	workers map[string][]*subscriberWorker
+
+	// 每次从Bucket搬到待消费队列的最大事件数量
+	carryBatchSize int64
+	// Bucket为空或者下一个事件还没到期时, 最长等待多久再检查一次
+	maxPollInterval time.Duration
+	// 发布事件时通过存储后端的 Notify 唤醒调度器
+	wakeups     map[string]chan struct{}
+	unsubscribe func()
+	// leader检查周期事件是否丢失触发的间隔
+	reconcileInterval time.Duration
+
+	// 选主模式下用于竞选leader的锁, 为空表示每个实例都搬运
+	locker      el_tool.ILocker
+	leaderLease time.Duration
+	// 当前任期号, 0 表示不是leader
+	leaderEpoch int64
+	leaderToken string
+	lastRenew   time.Time
+
+	// 指标上报, 为空时使用 metrics.MetricsImpl
+	metricsImpl metrics.IMetrics
+	// 每个订阅的topic在当前实例的统计
+	stats map[string]*topicCounters
+	// 所有订阅者共用的中间件
+	middlewares []Middleware
 }
 
-func init() {
-	DelayQueueImpl = &DelayQueue{}
+func InitDelayQueueImpl(q *DelayQueue) {
+	implLock.Lock()
+	defer implLock.Unlock()
+	DelayQueueImpl = q
 }
 
+// GetDelayQueue 返回全局默认实例, 还没有创建过实例时返回nil
 func GetDelayQueue() *DelayQueue {
+	implLock.RLock()
+	defer implLock.RUnlock()
 	return DelayQueueImpl
 }
 
+// NewDelayQueue 创建使用redis存储的独立的延迟队列实例, 不同实例可以使用不同的namespace和redis;
+// 第一个创建的实例同时会成为全局默认实例, 也可以通过 InitDelayQueueImpl 指定
 func NewDelayQueue(namespace string, redisClient *redis.Client) *DelayQueue {
-	DelayQueueImpl.namespace = namespace
-	DelayQueueImpl.redisClient = redisClient
-	DelayQueueImpl.stop = make(chan struct{})
-	return DelayQueueImpl
+	return NewDelayQueueWithBackend(namespace, NewRedisBackend(namespace, redisClient))
+}
+
+// NewDelayQueueWithBackend 创建使用指定存储后端的延迟队列实例, 例如单元测试中使用 NewMemoryBackend()
+func NewDelayQueueWithBackend(namespace string, backend IBackend) *DelayQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &DelayQueue{
+		namespace:         namespace,
+		backend:           backend,
+		ctx:               ctx,
+		cancel:            cancel,
+		stop:              make(chan struct{}),
+		consumerCounts:    make(map[string]int),
+		carryBatchSize:    defaultCarryBatchSize,
+		maxPollInterval:   defaultMaxPollInterval,
+		reconcileInterval: defaultReconcileInterval,
+	}
+	implLock.Lock()
+	if DelayQueueImpl == nil {
+		DelayQueueImpl = q
+	}
+	implLock.Unlock()
+	return q
 }
 
 func (q *DelayQueue) WithPersistForUnhandledEvent(fn PersistFn) {
 	q.persistFn = fn
 }
 
+// WithConsumerCount 设置topic同时出队的消费者数量, 需要在 InitOnce 之前调用
+func (q *DelayQueue) WithConsumerCount(topic string, count int) {
+	q.consumerCounts[topic] = count
+}
+
+func (q *DelayQueue) consumerCount(topic string) int {
+	if count := q.consumerCounts[topic]; count > 0 {
+		return count
+	}
+	return defaultConsumerCount
+}
+
 // gracefully shudown
 func (q *DelayQueue) ShutDown() {
 	if !atomic.CompareAndSwapInt32(&q.isRunning, 1, 0) {
 		return
 	}
 	close(q.stop)
+	q.cancel()
+	if q.unsubscribe != nil {
+		q.unsubscribe()
+	}
 	q.wg.Wait()
-}
-
-func (q *DelayQueue) genBucketKey(topic string) string {
-	return fmt.Sprintf("BUCKET_%v_%v", q.namespace, topic)
-}
-
-func (q *DelayQueue) genPoolKey(topic string) string {
-	return fmt.Sprintf("POOL_%v_%v", q.namespace, topic)
-}
-
-func (q *DelayQueue) genQueueKey(topic string) string {
-	return fmt.Sprintf("QUEUE_%v_%v", q.namespace, topic)
+	for _, workers := range q.workers {
+		for _, w := range workers {
+			w.pool.Close()
+		}
+	}
 }
 
 func (q *DelayQueue) InitOnce(subscriber IEventSubscriber, others ...IEventSubscriber) {
@@ -76,133 +154,99 @@ func (q *DelayQueue) InitOnce(subscriber IEventSubscriber, others ...IEventSubsc
 	}
 
 	list := append([]IEventSubscriber{subscriber}, others...)
-	topicConsumerMap := make(map[string][]IEventSubscriber)
+	q.workers = make(map[string][]*subscriberWorker)
+	q.stats = make(map[string]*topicCounters)
 	for _, s := range list {
-		topicConsumerMap[s.Topic()] = append(topicConsumerMap[s.Topic()], s)
+		q.workers[s.Topic()] = append(q.workers[s.Topic()], newSubscriberWorker(s, q.middlewares))
+		q.stats[s.Topic()] = &topicCounters{}
 	}
-	topicList := maps.Keys(topicConsumerMap).([]string)
+	topicList := maps.Keys(q.workers).([]string)
 	q.once.Do(func() {
+		q.subscribeWakeup(topicList)
+		if q.locker != nil {
+			q.wg.Add(1)
+			el_utils.GoSafe(func(ctx context.Context) {
+				q.runElection(topicList)
+			})
+		}
+		// 重新发布丢失的周期事件触发
+		el_utils.GoSafe(func(ctx context.Context) {
+			q.runReconciler(topicList)
+		})
 		for _, t := range topicList {
 			topic := t
-			// 定时topic扫描到期的事件
+			workers := q.workers[topic]
+			// 把topic到期的事件搬到待消费队列
 			el_utils.GoSafe(func(ctx context.Context) {
-				for {
-					if atomic.LoadInt32(&q.isRunning) == 0 {
-						break
-					}
-					count, err := q.carryEventToQueue(topic)
-					if err == nil && count == 0 {
-						time.Sleep(100 * time.Second)
-					}
-				}
+				q.runScheduler(topic)
 			})
 
-			// 消费topic队列的事件
 			el_utils.GoSafe(func(ctx context.Context) {
-				_ = q.runConsumer(topic, topicConsumerMap[topic])
+				q.runMetricsReporter(topic)
 			})
+
+			if q.reliable() {
+				// 重新投递可见性超时的事件
+				el_utils.GoSafe(func(ctx context.Context) {
+					q.runReaper(topic)
+				})
+			}
+
+			// 消费topic队列的事件
+			for i := 0; i < q.consumerCount(topic); i++ {
+				el_utils.GoSafe(func(ctx context.Context) {
+					if q.reliable() {
+						_ = q.runReliableConsumer(topic, workers)
+						return
+					}
+					_ = q.runConsumer(topic, workers)
+				})
+			}
 		}
 	})
 }
 
-// 扫描zset中到期的任务，添加到对应topic的待消费队列里，并从Bucket中删除已进入待消费队列的事件;
-// 每次都取指定数量,防止消息突增
-func (q *DelayQueue) carryEventToQueue(topic string) (int64, error) {
-	script := redis.NewScript(`
-	  local members = redis.call('ZRangeByScore', KEYS[1], '0', ARGV[1], 'limit', 0, 20)
-	  if(next(members) ~= nil) then
-		redis.call('ZRem', KEYS[1], 0, unpack(members, 1, #members))
-		redis.call('RPush', KEYS[2], unpack(members, 1, #members))
-	  end
-      return #members
-	  `)
-	ctx := context.Background()
-	delayKey := q.genBucketKey(topic)
-	readyKey := q.genQueueKey(topic)
-	res, err := script.Run(q.redisClient.WithContext(ctx), []string{delayKey, readyKey}, el_utils.ToString(time.Now().Unix())).Result()
-	if err != nil {
-		logs.CtxError(ctx, "[carryEventToQueue] script.Run", logs.String("err", err.Error()))
-		return 0, err
-	}
-	return res.(int64), nil
-}
-
-func (q *DelayQueue) runConsumer(topic string, subscriberList []IEventSubscriber) error {
+func (q *DelayQueue) runConsumer(topic string, workers []*subscriberWorker) error {
 	for {
 		if atomic.LoadInt32(&q.isRunning) == 0 {
 			break
 		}
 		q.wg.Add(1)
 		ctx := context.Background()
-		kvPair, err := q.redisClient.WithContext(ctx).BLPop(60*time.Second, q.genQueueKey(topic)).Result()
-		if err != nil {
-			logs.CtxWarn(ctx, "[runConsumer] BLPop", logs.String("err", err.Error()))
-			q.wg.Done()
-			continue
-		}
-		if len(kvPair) < 2 {
+		// 阻塞时间不宜过长, 否则 ShutDown 需要等待
+		id, event, err := q.backend.Pop(q.ctx, topic, consumerPopTimeout, time.Time{})
+		if id == 0 {
 			q.wg.Done()
+			if err != nil {
+				q.sleep(consumerPopTimeout)
+			}
 			continue
 		}
-
-		eventId := kvPair[1]
-		data, err := q.redisClient.WithContext(ctx).HGet(q.genPoolKey(topic), eventId).Result()
-		if err != nil && err != redis.Nil {
-			logs.CtxWarn(ctx, "[runConsumer] HGet", logs.String("err", err.Error()))
-			if q.persistFn != nil {
-				_ = q.persistFn(&EventEntity{
-					EventId: el_utils.String2Int64(eventId),
-					Topic:   topic,
-				})
+		if err != nil {
+			if err != ErrEventNotFound {
+				logs.CtxWarn(ctx, "[runConsumer] Pop", logs.String("err", err.Error()), logs.Int64("eventId", id))
+				if q.persistFn != nil {
+					if event == nil {
+						event = &EventEntity{EventId: id, Topic: topic}
+					}
+					_ = q.persistFn(event)
+				}
 			}
 			q.wg.Done()
 			continue
 		}
-		event := &EventEntity{}
-		_ = jsoniter.UnmarshalFromString(data, event)
-
-		for _, s := range subscriberList {
-			subscriber := s
-			el_utils.GoSafeWithCtx(ctx, func(ctx context.Context) {
-				el_utils.Retry(3, 0, func() (success bool) {
-					err = subscriber.Handle(ctx, event)
-					if err != nil {
-						logs.CtxWarn(ctx, "[runConsumer] subscriber.Handle", logs.String("err", err.Error()))
-						return false
-					}
-					return true
-				})
-			})
-		}
 
-		err = q.redisClient.WithContext(ctx).HDel(q.genPoolKey(topic), eventId).Err()
-		if err != nil {
-			logs.CtxWarn(ctx, "[runConsumer] HDel", logs.String("err", err.Error()))
-		}
+		// 订阅者并发已满时阻塞在这里, 不再继续出队
+		q.dispatch(ctx, event, workers, func(failures map[*subscriberWorker]error) {
+			if len(failures) > 0 || event.Recurring != "" {
+				q.settle(ctx, topic, id, event, failures)
+			}
+		})
 		q.wg.Done()
 	}
 	return nil
 }
 
-// todo 原子性保证
-func (q *DelayQueue) PublishEvent(ctx context.Context, event *EventEntity) error {
-	pipeline := q.redisClient.WithContext(ctx).Pipeline()
-	defer pipeline.Close()
-
-	pipeline.HSet(q.genPoolKey(event.Topic), strconv.FormatInt(event.EventId, 10), el_utils.ToJsonString(event))
-	pipeline.ZAdd(q.genBucketKey(event.Topic), redis.Z{
-		Member: strconv.FormatInt(event.EventId, 10),
-		Score:  float64(event.EffectTime.Unix()),
-	})
-	_, err := pipeline.Exec()
-	if err != nil {
-		logs.CtxWarn(ctx, "pipeline.Exec", logs.String("err", err.Error()))
-		return err
-	}
-	logs.CtxInfo(ctx, "publish event success", logs.String("event", el_utils.ToJsonString(event)))
-	return nil
-}
-
 //-- keys: pendingKey, readyKey
 //-- argv: currentTime
 //local msgs = redis.call('ZRangeByScore', KEYS[1], '0', ARGV[1])  -- 从 pending key 中找出已到投递时间的消息
@@ -0,0 +1,162 @@
+package delay_queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+	"github.com/go-redis/redis"
+)
+
+// 设置该环境变量后才会跑依赖 redis 的测试, 例如 EL_TOOL_TEST_REDIS_ADDR=127.0.0.1:6379
+const testRedisAddrEnv = "EL_TOOL_TEST_REDIS_ADDR"
+
+func TestMain(m *testing.M) {
+	// 测试中没有初始化日志, 使用空实现
+	nop := func(msg string, fields ...logs.Field) {}
+	ctxNop := func(ctx context.Context, msg string, fields ...logs.Field) {}
+	logs.Info, logs.Warn, logs.Error = nop, nop, nop
+	logs.CtxInfo, logs.CtxWarn, logs.CtxError = ctxNop, ctxNop, ctxNop
+	os.Exit(m.Run())
+}
+
+func testRedisClient(t *testing.T) (*redis.Client, string) {
+	addr := os.Getenv(testRedisAddrEnv)
+	if addr == "" {
+		t.Skipf("%v not set", testRedisAddrEnv)
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+	// 每次运行使用不同的namespace, 避免与之前的数据冲突
+	return client, fmt.Sprintf("delay_queue_test_%v", time.Now().UnixNano())
+}
+
+// runWithBackends 分别使用内存和redis存储后端运行测试, newBackend 每次返回互相独立的后端
+func runWithBackends(t *testing.T, fn func(t *testing.T, newBackend func() IBackend)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, func() IBackend { return NewMemoryBackend() })
+	})
+	t.Run("redis", func(t *testing.T) {
+		client, ns := testRedisClient(t)
+		n := 0
+		fn(t, func() IBackend {
+			n++
+			return NewRedisBackend(fmt.Sprintf("%v_%v", ns, n), client)
+		})
+	})
+}
+
+// newTestQueue 创建使用指定后端的延迟队列, 由调用方发布事件后 InitOnce
+func newTestQueue(backend IBackend) *DelayQueue {
+	return NewDelayQueueWithBackend("test", backend)
+}
+
+// popUnacked 模拟出队后还没有确认的消费者: 把到期的事件搬到待消费队列并出队, 事件留在处理中集合直到deadline
+func popUnacked(t *testing.T, backend IBackend, topic string, deadline time.Time) int64 {
+	t.Helper()
+	ctx := context.Background()
+	waitUntil(t, time.Second, func() bool {
+		n, _, err := backend.MoveDue(ctx, topic, time.Now(), 10, 0)
+		return err == nil && n > 0
+	}, "no due event")
+	id, _, err := backend.Pop(ctx, topic, 0, deadline)
+	if err != nil || id == 0 {
+		t.Fatalf("Pop = %v, %v", id, err)
+	}
+	return id
+}
+
+func TestDefaultDelayQueue(t *testing.T) {
+	old := GetDelayQueue()
+	defer InitDelayQueueImpl(old)
+
+	InitDelayQueueImpl(nil)
+	ctx := context.Background()
+	if err := PublishEvent(ctx, &EventEntity{Topic: "topic"}); err != ErrNotInitialized {
+		t.Fatalf("PublishEvent without default queue: %v", err)
+	}
+	if _, err := PublishEvents(ctx, []*EventEntity{{Topic: "topic"}}); err != ErrNotInitialized {
+		t.Fatalf("PublishEvents without default queue: %v", err)
+	}
+
+	// 并发创建时只有一个实例成为全局默认实例
+	queues := make([]*DelayQueue, 10)
+	var wg sync.WaitGroup
+	for i := range queues {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			queues[i] = NewDelayQueueWithBackend("test", NewMemoryBackend())
+		}(i)
+	}
+	wg.Wait()
+	found := false
+	for _, q := range queues {
+		found = found || q == GetDelayQueue()
+	}
+	if !found {
+		t.Fatal("default queue should be one of the created queues")
+	}
+}
+
+type counterIdGenerator struct {
+	id int64
+}
+
+func (g *counterIdGenerator) Get() (int64, error) {
+	return atomic.AddInt64(&g.id, 1), nil
+}
+
+type testSubscriber struct {
+	topic string
+	fail  int32 // 前几次处理返回错误
+	ch    chan *EventEntity
+}
+
+func newTestSubscriber(topic string) *testSubscriber {
+	return &testSubscriber{topic: topic, ch: make(chan *EventEntity, 100)}
+}
+
+func (s *testSubscriber) Topic() string {
+	return s.topic
+}
+
+func (s *testSubscriber) Handle(ctx context.Context, event *EventEntity) error {
+	s.ch <- event
+	if atomic.AddInt32(&s.fail, -1) >= 0 {
+		return errors.New("mock failure")
+	}
+	return nil
+}
+
+func (s *testSubscriber) RetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 2, BaseBackoff: 10 * time.Millisecond}
+}
+
+func (s *testSubscriber) wait(t *testing.T, timeout time.Duration) *EventEntity {
+	t.Helper()
+	select {
+	case event := <-s.ch:
+		return event
+	case <-time.After(timeout):
+		t.Fatal("wait event timeout")
+		return nil
+	}
+}
+
+func waitUntil(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -10,10 +10,19 @@ type EventEntity struct {
 	Topic      string
 	Body       string
 	EffectTime time.Time
+	// Attempt 已经失败的投递次数
+	Attempt int
+	// Subscribers 不为空时只投递给这些订阅者, 用于失败后的定向重试
+	Subscribers []string
+	// Recurring 周期事件的名字, 处理完成后自动发布下一次触发
+	Recurring string
+	// LogId 发布事件时ctx中的logid, 由 LogIdMiddleware 注入到处理事件的ctx中
+	LogId string
 }
 
 type IDelayQueue interface {
-	PublishEvent(ctx context.Context, event EventEntity) error
+	PublishEvent(ctx context.Context, event *EventEntity) error
+	PublishEvents(ctx context.Context, events []*EventEntity) ([]*EventEntity, error)
 }
 
 type IEventSubscriber interface {
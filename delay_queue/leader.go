@@ -0,0 +1,126 @@
+package delay_queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/drip-in/eden_lib/el_tool"
+	"github.com/drip-in/eden_lib/logs"
+)
+
+// defaultLeaderLease 选主时默认的租约时长
+const defaultLeaderLease = 10 * time.Second
+
+// ErrFenced 当前实例的任期已经被新的leader取代, 搬运被拒绝
+var ErrFenced = errors.New("delay_queue: scheduler fenced by a newer leader")
+
+// WithLeaderElection 开启选主模式: 同一namespace下只有当选leader的实例把Bucket中到期的事件搬到待消费队列,
+// 其他实例只消费. locker 实现了 el_tool.IRenewableLocker 时 leader 每隔 lease/3 续租一次, 否则在租约过期前主动卸任并重新竞选;
+// 宕机后租约过期由其他实例自动接管. 每次当选都会递增任期号, 搬运时校验任期号, 旧leader恢复后的搬运会被拒绝. 需要在 InitOnce 之前调用
+func (q *DelayQueue) WithLeaderElection(locker el_tool.ILocker, lease time.Duration) {
+	if lease <= 0 {
+		lease = defaultLeaderLease
+	}
+	q.locker = locker
+	q.leaderLease = lease
+}
+
+// IsLeader 当前实例是否负责搬运事件, 没有开启选主模式时总是返回true
+func (q *DelayQueue) IsLeader() bool {
+	_, ok := q.schedulerEpoch()
+	return ok
+}
+
+func (q *DelayQueue) genLeaderKey() string {
+	return fmt.Sprintf("LEADER_%v", q.namespace)
+}
+
+// schedulerEpoch 返回搬运时使用的任期号, 0 表示不校验; 第二个返回值表示当前实例是否可以搬运
+func (q *DelayQueue) schedulerEpoch() (int64, bool) {
+	if q.locker == nil {
+		return 0, true
+	}
+	epoch := atomic.LoadInt64(&q.leaderEpoch)
+	return epoch, epoch > 0
+}
+
+// runElection 竞选并维持leader身份, ShutDown 时主动释放以便其他实例尽快接管
+func (q *DelayQueue) runElection(topicList []string) {
+	defer q.wg.Done()
+	for {
+		if atomic.LoadInt32(&q.isRunning) == 0 {
+			break
+		}
+		q.campaign(topicList)
+		q.sleep(q.leaderLease / 3)
+	}
+	q.resign()
+}
+
+func (q *DelayQueue) campaign(topicList []string) {
+	ctx := context.Background()
+	if epoch := atomic.LoadInt64(&q.leaderEpoch); epoch > 0 {
+		renewer, ok := q.locker.(el_tool.IRenewableLocker)
+		if !ok {
+			// 不支持续租: 租约过期前卸任后立即重新竞选, 新的任期号会拒绝卸任前的搬运
+			if time.Since(q.lastRenew) < q.leaderLease*2/3 {
+				return
+			}
+			q.resign()
+			q.campaign(topicList)
+			return
+		}
+		ok, err := renewer.Renew(ctx, q.genLeaderKey(), q.leaderToken, q.leaderLease)
+		if err == nil && ok {
+			q.lastRenew = time.Now()
+			return
+		}
+		// redis 短暂不可用时在租约过期前继续担任leader, 即使期间被取代, 任期号也会拒绝旧leader的搬运
+		if err != nil && time.Since(q.lastRenew) < q.leaderLease {
+			return
+		}
+		q.stepDown(epoch)
+		return
+	}
+
+	token := fmt.Sprintf("%d_%d", time.Now().UnixNano(), rand.Int63())
+	if !q.locker.TryLockWithValAndDuration(ctx, q.genLeaderKey(), token, q.leaderLease) {
+		return
+	}
+	epoch, err := q.backend.NextEpoch(ctx)
+	if err != nil {
+		logs.CtxWarn(ctx, "[campaign] NextEpoch", logs.String("err", err.Error()))
+		_ = q.locker.UnLock(ctx, q.genLeaderKey(), token)
+		return
+	}
+	q.leaderToken = token
+	q.lastRenew = time.Now()
+	atomic.StoreInt64(&q.leaderEpoch, epoch)
+	logs.CtxInfo(ctx, "[campaign] elected leader", logs.String("namespace", q.namespace), logs.Int64("epoch", epoch))
+	// 立即开始搬运, 不必等待调度器的下一次轮询
+	for _, topic := range topicList {
+		q.wakeup(topic)
+	}
+}
+
+// stepDown 放弃指定任期的leader身份, 任期已经变化时不做处理
+func (q *DelayQueue) stepDown(epoch int64) {
+	if atomic.CompareAndSwapInt64(&q.leaderEpoch, epoch, 0) {
+		logs.Warn("[stepDown] lost leadership", logs.String("namespace", q.namespace), logs.Int64("epoch", epoch))
+	}
+}
+
+func (q *DelayQueue) resign() {
+	epoch := atomic.LoadInt64(&q.leaderEpoch)
+	if epoch == 0 {
+		return
+	}
+	q.stepDown(epoch)
+	if err := q.locker.UnLock(context.Background(), q.genLeaderKey(), q.leaderToken); err != nil {
+		logs.Warn("[resign] UnLock", logs.String("err", err.Error()))
+	}
+}
@@ -0,0 +1,107 @@
+package delay_queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drip-in/eden_lib/el_tool"
+)
+
+const testLeaderLease = 150 * time.Millisecond
+
+// flakyLocker down 为1时续租失败, 模拟leader与redis之间的网络故障
+type flakyLocker struct {
+	el_tool.IRenewableLocker
+	down int32
+}
+
+func (l *flakyLocker) Renew(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	if atomic.LoadInt32(&l.down) == 1 {
+		return false, errors.New("mock redis down")
+	}
+	return l.IRenewableLocker.Renew(ctx, key, value, duration)
+}
+
+// plainLocker 隐藏 Renew, 模拟不支持续租的 ILocker
+type plainLocker struct {
+	el_tool.ILocker
+}
+
+func newLeaderQueue(backend IBackend, locker el_tool.ILocker, topic string) (*DelayQueue, *testSubscriber) {
+	s := newTestSubscriber(topic)
+	q := newTestQueue(backend)
+	q.WithIdGenerator(&counterIdGenerator{})
+	q.WithLeaderElection(locker, testLeaderLease)
+	q.InitOnce(s)
+	return q, s
+}
+
+func TestLeaderFailover(t *testing.T) {
+	backend := NewMemoryBackend()
+	locker := el_tool.NewMemoryLocker()
+	flaky := &flakyLocker{IRenewableLocker: locker}
+	q1, _ := newLeaderQueue(backend, flaky, "topic")
+	defer q1.ShutDown()
+	waitUntil(t, time.Second, q1.IsLeader, "q1 should be elected")
+
+	q2, s2 := newLeaderQueue(backend, locker, "topic")
+	defer q2.ShutDown()
+	time.Sleep(testLeaderLease)
+	if q2.IsLeader() {
+		t.Fatal("only one instance can be leader")
+	}
+
+	// q1 续租失败, 租约过期后由q2接管
+	atomic.StoreInt32(&flaky.down, 1)
+	waitUntil(t, 3*testLeaderLease, q2.IsLeader, "q2 should take over after the lease expires")
+	waitUntil(t, testLeaderLease, func() bool { return !q1.IsLeader() }, "q1 should step down")
+
+	q1.ShutDown()
+	event := &EventEntity{Topic: "topic", Body: "failover", EffectTime: time.Now()}
+	if err := q2.PublishEvent(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if got := s2.wait(t, 2*time.Second); got.EventId != event.EventId {
+		t.Fatalf("unexpected event %+v", got)
+	}
+}
+
+func TestLeaderStaleEpochFenced(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	q, _ := newLeaderQueue(backend, el_tool.NewMemoryLocker(), "topic")
+	defer q.ShutDown()
+	waitUntil(t, time.Second, q.IsLeader, "q should be elected")
+	stale, _ := q.schedulerEpoch()
+
+	// 其他实例当选后, 旧任期的搬运被拒绝, q 放弃leader身份
+	if _, err := backend.NextEpoch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := backend.MoveDue(ctx, "topic", time.Now(), 10, stale); err != ErrFenced {
+		t.Fatalf("MoveDue with stale epoch: %v", err)
+	}
+	if err := q.PublishEvent(ctx, &EventEntity{Topic: "topic", EffectTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, time.Second, func() bool {
+		epoch, _ := q.schedulerEpoch()
+		return epoch != stale
+	}, "fenced leader should step down")
+}
+
+func TestLeaderWithoutRenew(t *testing.T) {
+	q, _ := newLeaderQueue(NewMemoryBackend(), plainLocker{el_tool.NewMemoryLocker()}, "topic")
+	defer q.ShutDown()
+	waitUntil(t, time.Second, q.IsLeader, "q should be elected")
+	first, _ := q.schedulerEpoch()
+
+	// 不支持续租时租约过期前重新当选, 任期号递增
+	waitUntil(t, 3*testLeaderLease, func() bool {
+		epoch, _ := q.schedulerEpoch()
+		return epoch > first
+	}, "leader should be re-elected before the lease expires")
+}
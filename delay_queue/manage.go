@@ -0,0 +1,40 @@
+package delay_queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrEventNotFound 事件不存在, 或者已经被消费完
+	ErrEventNotFound = errors.New("delay_queue: event not found")
+	// ErrEventProcessing 事件正在被订阅者处理, 不能取消或重新调度
+	ErrEventProcessing = errors.New("delay_queue: event is processing")
+	// ErrEventChanged 重新调度期间事件被并发修改了
+	ErrEventChanged = errors.New("delay_queue: event changed concurrently")
+)
+
+// CancelEvent 取消还没有被消费的事件
+func (q *DelayQueue) CancelEvent(ctx context.Context, topic string, eventId int64) error {
+	return q.backend.Cancel(ctx, topic, eventId)
+}
+
+// RescheduleEvent 修改还没有被消费的事件的生效时间, 已经到期进入待消费队列的事件也会被移回Bucket
+func (q *DelayQueue) RescheduleEvent(ctx context.Context, topic string, eventId int64, effectTime time.Time) error {
+	if err := q.backend.Reschedule(ctx, topic, eventId, effectTime); err != nil {
+		return err
+	}
+	q.notifyScheduler(ctx, topic, effectTime)
+	return nil
+}
+
+// GetEvent 查询还没有被消费完的事件
+func (q *DelayQueue) GetEvent(ctx context.Context, topic string, eventId int64) (*EventEntity, error) {
+	return q.backend.Lookup(ctx, topic, eventId)
+}
+
+// ListPending 按生效时间顺序列出Bucket中生效时间在[from, to]之间的事件, limit<=0 表示不限制数量
+func (q *DelayQueue) ListPending(ctx context.Context, topic string, from, to time.Time, limit int64) ([]*EventEntity, error) {
+	return q.backend.ListPending(ctx, topic, from, to, limit)
+}
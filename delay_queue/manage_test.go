@@ -0,0 +1,75 @@
+package delay_queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManageEvents(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		backend := newBackend()
+		q := newTestQueue(backend)
+		ctx := context.Background()
+
+		// 模拟正在处理的事件
+		now := time.Now()
+		if err := q.PublishEvent(ctx, &EventEntity{EventId: 4, Topic: "topic", EffectTime: now}); err != nil {
+			t.Fatal(err)
+		}
+		popUnacked(t, backend, "topic", now.Add(time.Hour))
+
+		for i := 1; i <= 3; i++ {
+			event := &EventEntity{EventId: int64(i), Topic: "topic", EffectTime: now.Add(time.Duration(i) * time.Hour)}
+			if err := q.PublishEvent(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pendingIds := func(to time.Time) []int64 {
+			events, err := q.ListPending(ctx, "topic", now, to, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]int64, 0, len(events))
+			for _, event := range events {
+				ids = append(ids, event.EventId)
+			}
+			return ids
+		}
+		if ids := pendingIds(now.Add(150 * time.Minute)); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+			t.Fatalf("ListPending = %v, want [1 2]", ids)
+		}
+
+		// 重新调度后按新的生效时间排序
+		if err := q.RescheduleEvent(ctx, "topic", 3, now.Add(30*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if ids := pendingIds(now.Add(150 * time.Minute)); len(ids) != 3 || ids[0] != 3 {
+			t.Fatalf("ListPending after reschedule = %v, want 3 first", ids)
+		}
+		if event, err := q.GetEvent(ctx, "topic", 3); err != nil || event.EffectTime.Unix() != now.Add(30*time.Minute).Unix() {
+			t.Fatalf("GetEvent = %+v, %v", event, err)
+		}
+
+		if err := q.CancelEvent(ctx, "topic", 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.GetEvent(ctx, "topic", 1); err != ErrEventNotFound {
+			t.Fatalf("GetEvent cancelled = %v", err)
+		}
+		if err := q.CancelEvent(ctx, "topic", 1); err != ErrEventNotFound {
+			t.Fatalf("CancelEvent twice = %v", err)
+		}
+		if err := q.RescheduleEvent(ctx, "topic", 1, now); err != ErrEventNotFound {
+			t.Fatalf("RescheduleEvent cancelled = %v", err)
+		}
+
+		// 处理中的事件不能取消或重新调度
+		if err := q.CancelEvent(ctx, "topic", 4); err != ErrEventProcessing {
+			t.Fatalf("CancelEvent processing = %v", err)
+		}
+		if err := q.RescheduleEvent(ctx, "topic", 4, now); err != ErrEventProcessing {
+			t.Fatalf("RescheduleEvent processing = %v", err)
+		}
+	})
+}
@@ -0,0 +1,587 @@
+package delay_queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drip-in/eden_lib/el_utils"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// memoryWheelTick 内存时间轮每个槽位的时间跨度
+	memoryWheelTick = 10 * time.Millisecond
+	// memoryWheelSlots 内存时间轮的槽位数量, 超过一圈的事件在槽位中等待之后的轮次
+	memoryWheelSlots = 1024
+	// defaultDeadLetterPageSize ListDeadLetters 没有指定数量时每页的数量
+	defaultDeadLetterPageSize = 10
+)
+
+// timerWheel 单层的时间轮, 事件放在到期tick对应的槽位中; cursor 之前的tick都已经处理完
+type timerWheel struct {
+	tick   time.Duration
+	slots  []map[int64]int64 // eventId -> 到期的tick
+	index  map[int64]int64
+	cursor int64
+}
+
+func newTimerWheel(tick time.Duration, slots int, now time.Time) *timerWheel {
+	w := &timerWheel{
+		tick:   tick,
+		slots:  make([]map[int64]int64, slots),
+		index:  make(map[int64]int64),
+		cursor: now.UnixNano() / int64(tick),
+	}
+	for i := range w.slots {
+		w.slots[i] = make(map[int64]int64)
+	}
+	return w
+}
+
+func (w *timerWheel) slot(tick int64) map[int64]int64 {
+	return w.slots[tick%int64(len(w.slots))]
+}
+
+// add 添加或者修改事件的到期时间, 到期时间向上取整到tick, 已经过去的时间放在当前的tick
+func (w *timerWheel) add(eventId int64, at time.Time) {
+	w.remove(eventId)
+	due := (at.UnixNano() + int64(w.tick) - 1) / int64(w.tick)
+	if due < w.cursor {
+		due = w.cursor
+	}
+	w.slot(due)[eventId] = due
+	w.index[eventId] = due
+}
+
+func (w *timerWheel) remove(eventId int64) bool {
+	due, ok := w.index[eventId]
+	if !ok {
+		return false
+	}
+	delete(w.slot(due), eventId)
+	delete(w.index, eventId)
+	return true
+}
+
+// popDue 按到期顺序取出最多limit个不晚于now到期的事件
+func (w *timerWheel) popDue(now time.Time, limit int64) []int64 {
+	nowTick := now.UnixNano() / int64(w.tick)
+	if nowTick < w.cursor {
+		return nil
+	}
+	end := nowTick
+	if end-w.cursor >= int64(len(w.slots)) {
+		// 间隔超过一圈时每个槽位只需要检查一次
+		end = w.cursor + int64(len(w.slots)) - 1
+	}
+	type dueEvent struct {
+		id, due int64
+	}
+	var due []dueEvent
+	for tick := w.cursor; tick <= end; tick++ {
+		for id, d := range w.slot(tick) {
+			if d <= nowTick {
+				due = append(due, dueEvent{id, d})
+			}
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].due != due[j].due {
+			return due[i].due < due[j].due
+		}
+		return due[i].id < due[j].id
+	})
+
+	w.cursor = nowTick
+	if int64(len(due)) > limit {
+		// 剩下的到期事件留给下一次
+		w.cursor = due[limit].due
+		due = due[:limit]
+	}
+	ids := make([]int64, 0, len(due))
+	for _, e := range due {
+		w.remove(e.id)
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+// earliest 返回最早的到期时间, 没有事件时返回零值
+func (w *timerWheel) earliest() time.Time {
+	if len(w.index) == 0 {
+		return time.Time{}
+	}
+	// 一圈之内的事件按槽位顺序查找, 第一个找到的就是最早的
+	for tick := w.cursor; tick < w.cursor+int64(len(w.slots)); tick++ {
+		for _, d := range w.slot(tick) {
+			if d == tick {
+				return time.Unix(0, tick*int64(w.tick))
+			}
+		}
+	}
+	min := int64(-1)
+	for _, d := range w.index {
+		if min < 0 || d < min {
+			min = d
+		}
+	}
+	return time.Unix(0, min*int64(w.tick))
+}
+
+type memoryTopic struct {
+	// 事件池, 保存编码后的事件, 与redis后端一样每次读取都是新的副本
+	pool       map[int64]string
+	bucket     *timerWheel
+	queue      []int64
+	processing map[int64]time.Time
+	dead       map[string]string
+	recurring  map[string]string
+	// 待消费队列有新事件时关闭并替换, 唤醒等待中的 Pop
+	changed chan struct{}
+}
+
+func (t *memoryTopic) push(ids ...int64) {
+	if len(ids) == 0 {
+		return
+	}
+	t.queue = append(t.queue, ids...)
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (t *memoryTopic) removeFromQueue(eventId int64) {
+	for i, id := range t.queue {
+		if id == eventId {
+			t.queue = append(t.queue[:i], t.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *memoryTopic) schedule(event *EventEntity) {
+	t.pool[event.EventId] = el_utils.ToJsonString(event)
+	t.bucket.add(event.EventId, event.EffectTime)
+}
+
+// MemoryBackend 基于内存时间轮的存储后端, 语义与 RedisBackend 一致, 只能在单个进程内使用, 适用于单元测试和单机工具
+type MemoryBackend struct {
+	mu          sync.Mutex
+	topics      map[string]*memoryTopic
+	epoch       int64
+	subscribers map[int]func(topic string)
+	nextSubId   int
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		topics:      make(map[string]*memoryTopic),
+		subscribers: make(map[int]func(topic string)),
+	}
+}
+
+// topic 需要持有锁
+func (b *MemoryBackend) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{
+			pool:       make(map[int64]string),
+			bucket:     newTimerWheel(memoryWheelTick, memoryWheelSlots, time.Now()),
+			processing: make(map[int64]time.Time),
+			dead:       make(map[string]string),
+			recurring:  make(map[string]string),
+			changed:    make(chan struct{}),
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func decodeEvent(data string) (*EventEntity, error) {
+	event := &EventEntity{}
+	if err := jsoniter.UnmarshalFromString(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (b *MemoryBackend) Schedule(ctx context.Context, events []*EventEntity) ([]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	added := make([]bool, 0, len(events))
+	for _, event := range events {
+		t := b.topic(event.Topic)
+		if _, ok := t.pool[event.EventId]; ok {
+			added = append(added, false)
+			continue
+		}
+		t.schedule(event)
+		added = append(added, true)
+	}
+	return added, nil
+}
+
+func (b *MemoryBackend) MoveDue(ctx context.Context, topic string, now time.Time, limit int64, epoch int64) (int64, time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if epoch > 0 && epoch != b.epoch {
+		return 0, time.Time{}, ErrFenced
+	}
+	t := b.topic(topic)
+	ids := t.bucket.popDue(now, limit)
+	t.push(ids...)
+	return int64(len(ids)), t.bucket.earliest(), nil
+}
+
+func (b *MemoryBackend) Pop(ctx context.Context, topic string, wait time.Duration, deadline time.Time) (int64, *EventEntity, error) {
+	start := time.Now()
+	var timer *time.Timer
+	for {
+		b.mu.Lock()
+		t := b.topic(topic)
+		if len(t.queue) > 0 {
+			id := t.queue[0]
+			t.queue = t.queue[1:]
+			if !deadline.IsZero() {
+				t.processing[id] = deadline.Add(time.Since(start))
+			}
+			data, ok := t.pool[id]
+			if ok && deadline.IsZero() {
+				delete(t.pool, id)
+			}
+			b.mu.Unlock()
+
+			if !ok {
+				return id, nil, ErrEventNotFound
+			}
+			event, err := decodeEvent(data)
+			if err != nil {
+				return id, &EventEntity{EventId: id, Topic: topic, Body: data}, err
+			}
+			return id, event, nil
+		}
+		changed := t.changed
+		b.mu.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(wait)
+			defer timer.Stop()
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return 0, nil, nil
+		case <-ctx.Done():
+			return 0, nil, nil
+		}
+	}
+}
+
+func (b *MemoryBackend) Touch(ctx context.Context, topic string, eventId int64, deadline time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	if _, ok := t.processing[eventId]; ok {
+		t.processing[eventId] = deadline
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Reap(ctx context.Context, topic string, now time.Time, limit int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	var expired []int64
+	for id, deadline := range t.processing {
+		if !deadline.After(now) {
+			expired = append(expired, id)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return t.processing[expired[i]].Before(t.processing[expired[j]])
+	})
+	if int64(len(expired)) > limit {
+		expired = expired[:limit]
+	}
+	for _, id := range expired {
+		delete(t.processing, id)
+	}
+	t.push(expired...)
+	return int64(len(expired)), nil
+}
+
+func (b *MemoryBackend) Ack(ctx context.Context, topic string, eventId int64, retry *EventEntity, dead []*DeadLetter) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	delete(t.processing, eventId)
+	if retry != nil {
+		t.schedule(retry)
+	} else {
+		delete(t.pool, eventId)
+	}
+	for _, letter := range dead {
+		t.dead[letter.Id] = el_utils.ToJsonString(letter)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Lookup(ctx context.Context, topic string, eventId int64) (*EventEntity, error) {
+	b.mu.Lock()
+	data, ok := b.topic(topic).pool[eventId]
+	b.mu.Unlock()
+	if !ok {
+		return nil, ErrEventNotFound
+	}
+	return decodeEvent(data)
+}
+
+func (b *MemoryBackend) Cancel(ctx context.Context, topic string, eventId int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	if _, ok := t.processing[eventId]; ok {
+		return ErrEventProcessing
+	}
+	if _, ok := t.pool[eventId]; !ok {
+		return ErrEventNotFound
+	}
+	delete(t.pool, eventId)
+	t.bucket.remove(eventId)
+	t.removeFromQueue(eventId)
+	return nil
+}
+
+func (b *MemoryBackend) Reschedule(ctx context.Context, topic string, eventId int64, effectTime time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	if _, ok := t.processing[eventId]; ok {
+		return ErrEventProcessing
+	}
+	data, ok := t.pool[eventId]
+	if !ok {
+		return ErrEventNotFound
+	}
+	event, err := decodeEvent(data)
+	if err != nil {
+		return err
+	}
+	event.EffectTime = effectTime
+	t.removeFromQueue(eventId)
+	t.schedule(event)
+	return nil
+}
+
+func (b *MemoryBackend) Count(ctx context.Context, topic string) (pending, ready, processing int64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	return int64(len(t.bucket.index)), int64(len(t.queue)), int64(len(t.processing)), nil
+}
+
+func (b *MemoryBackend) ListPending(ctx context.Context, topic string, from, to time.Time, limit int64) ([]*EventEntity, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	var events []*EventEntity
+	for id := range t.bucket.index {
+		event, err := decodeEvent(t.pool[id])
+		if err != nil {
+			continue
+		}
+		// 与redis后端一样按秒比较
+		if sec := event.EffectTime.Unix(); sec >= from.Unix() && sec <= to.Unix() {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].EffectTime.Equal(events[j].EffectTime) {
+			return events[i].EffectTime.Before(events[j].EffectTime)
+		}
+		return events[i].EventId < events[j].EventId
+	})
+	if limit > 0 && int64(len(events)) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (b *MemoryBackend) ListDeadLetters(ctx context.Context, topic string, cursor uint64, count int64) ([]*DeadLetter, uint64, error) {
+	if count <= 0 {
+		count = defaultDeadLetterPageSize
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	ids := make([]string, 0, len(t.dead))
+	for id := range t.dead {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var letters []*DeadLetter
+	end := cursor + uint64(count)
+	for i := cursor; i < end && i < uint64(len(ids)); i++ {
+		letter := &DeadLetter{}
+		if err := jsoniter.UnmarshalFromString(t.dead[ids[i]], letter); err != nil {
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	if end >= uint64(len(ids)) {
+		end = 0
+	}
+	return letters, end, nil
+}
+
+func (b *MemoryBackend) GetDeadLetter(ctx context.Context, topic string, id string) (*DeadLetter, error) {
+	b.mu.Lock()
+	data, ok := b.topic(topic).dead[id]
+	b.mu.Unlock()
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+	letter := &DeadLetter{}
+	if err := jsoniter.UnmarshalFromString(data, letter); err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+func (b *MemoryBackend) ReplayDeadLetter(ctx context.Context, topic string, id string, event *EventEntity) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	if _, ok := t.dead[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	delete(t.dead, id)
+	t.schedule(event)
+	return nil
+}
+
+func (b *MemoryBackend) PurgeDeadLetters(ctx context.Context, topic string, ids ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	if len(ids) == 0 {
+		t.dead = make(map[string]string)
+		return nil
+	}
+	for _, id := range ids {
+		delete(t.dead, id)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) AddRecurring(ctx context.Context, s *RecurringSchedule, event *EventEntity) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(s.Topic)
+	if _, ok := t.recurring[s.Name]; ok {
+		return ErrScheduleExists
+	}
+	t.recurring[s.Name] = el_utils.ToJsonString(s)
+	t.schedule(event)
+	return nil
+}
+
+func (b *MemoryBackend) GetRecurring(ctx context.Context, topic string, name string) (*RecurringSchedule, error) {
+	b.mu.Lock()
+	data, ok := b.topic(topic).recurring[name]
+	b.mu.Unlock()
+	if !ok {
+		return nil, ErrScheduleNotFound
+	}
+	s := &RecurringSchedule{}
+	if err := jsoniter.UnmarshalFromString(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (b *MemoryBackend) AdvanceRecurring(ctx context.Context, prevEventId int64, next *RecurringSchedule, event *EventEntity) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(next.Topic)
+	data, ok := t.recurring[next.Name]
+	if !ok {
+		return false, nil
+	}
+	prev := &RecurringSchedule{}
+	if err := jsoniter.UnmarshalFromString(data, prev); err != nil {
+		return false, err
+	}
+	if prev.EventId != prevEventId {
+		return false, nil
+	}
+	t.recurring[next.Name] = el_utils.ToJsonString(next)
+	t.schedule(event)
+	return true, nil
+}
+
+func (b *MemoryBackend) ListRecurring(ctx context.Context, topic string) ([]*RecurringSchedule, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	schedules := make([]*RecurringSchedule, 0, len(t.recurring))
+	for _, data := range t.recurring {
+		s := &RecurringSchedule{}
+		if err := jsoniter.UnmarshalFromString(data, s); err != nil {
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+func (b *MemoryBackend) RemoveRecurring(ctx context.Context, topic string, name string) (*RecurringSchedule, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	data, ok := t.recurring[name]
+	if !ok {
+		return nil, ErrScheduleNotFound
+	}
+	delete(t.recurring, name)
+	s := &RecurringSchedule{}
+	if err := jsoniter.UnmarshalFromString(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (b *MemoryBackend) NextEpoch(ctx context.Context) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.epoch++
+	return b.epoch, nil
+}
+
+func (b *MemoryBackend) Notify(ctx context.Context, topic string) error {
+	b.mu.Lock()
+	fns := make([]func(topic string), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		fns = append(fns, fn)
+	}
+	b.mu.Unlock()
+	for _, fn := range fns {
+		fn(topic)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Subscribe(fn func(topic string)) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextSubId
+	b.nextSubId++
+	b.subscribers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
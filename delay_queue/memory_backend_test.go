@@ -0,0 +1,40 @@
+package delay_queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerWheel(t *testing.T) {
+	now := time.Unix(1000, 0)
+	w := newTimerWheel(10*time.Millisecond, 8, now)
+	w.add(1, now.Add(30*time.Millisecond))
+	w.add(2, now.Add(10*time.Millisecond))
+	w.add(3, now.Add(time.Second)) // 超过一圈
+	w.add(4, now.Add(-time.Second))
+	if got := w.earliest(); !got.Equal(now) {
+		t.Fatalf("earliest = %v", got)
+	}
+	if ids := w.popDue(now.Add(15*time.Millisecond), 10); len(ids) != 2 || ids[0] != 4 || ids[1] != 2 {
+		t.Fatalf("popDue = %v", ids)
+	}
+	if got := w.earliest(); !got.Equal(now.Add(30 * time.Millisecond)) {
+		t.Fatalf("earliest = %v", got)
+	}
+	if ids := w.popDue(now.Add(500*time.Millisecond), 10); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("popDue = %v", ids)
+	}
+	if got := w.earliest(); !got.Equal(now.Add(time.Second)) {
+		t.Fatalf("earliest = %v", got)
+	}
+	w.add(5, now.Add(900*time.Millisecond))
+	if ids := w.popDue(now.Add(2*time.Second), 1); len(ids) != 1 || ids[0] != 5 {
+		t.Fatalf("popDue with limit = %v", ids)
+	}
+	if ids := w.popDue(now.Add(2*time.Second), 1); len(ids) != 1 || ids[0] != 3 {
+		t.Fatalf("popDue rest = %v", ids)
+	}
+	if !w.earliest().IsZero() {
+		t.Fatal("wheel should be empty")
+	}
+}
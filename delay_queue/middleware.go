@@ -0,0 +1,122 @@
+package delay_queue
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+	"github.com/drip-in/eden_lib/metrics"
+)
+
+// metricHandleDuration 订阅者处理一个事件的耗时
+const metricHandleDuration = "delay_queue.handle_duration"
+
+// HandlerFunc 处理一个事件, 与 IEventSubscriber.Handle 相同
+type HandlerFunc func(ctx context.Context, event *EventEntity) error
+
+// Middleware 包装 HandlerFunc, 用于日志、耗时、panic恢复等通用逻辑
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain 把多个中间件组合为一个, 第一个中间件在最外层
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Use 为所有订阅者添加中间件, 在订阅者自己的中间件外层执行, 需要在 InitOnce 之前调用
+func (q *DelayQueue) Use(middlewares ...Middleware) {
+	q.middlewares = append(q.middlewares, middlewares...)
+}
+
+// middlewareSubscriber 带中间件的订阅者, 保留原订阅者的名字、重试策略和并发数
+type middlewareSubscriber struct {
+	IEventSubscriber
+	handler HandlerFunc
+}
+
+// WithMiddleware 用中间件包装订阅者
+func WithMiddleware(s IEventSubscriber, middlewares ...Middleware) IEventSubscriber {
+	return &middlewareSubscriber{
+		IEventSubscriber: s,
+		handler:          Chain(middlewares...)(s.Handle),
+	}
+}
+
+func (s *middlewareSubscriber) Handle(ctx context.Context, event *EventEntity) error {
+	return s.handler(ctx, event)
+}
+
+func (s *middlewareSubscriber) Name() string {
+	return subscriberName(s.IEventSubscriber)
+}
+
+func (s *middlewareSubscriber) RetryPolicy() RetryPolicy {
+	return subscriberRetryPolicy(s.IEventSubscriber)
+}
+
+func (s *middlewareSubscriber) Concurrency() int {
+	if c, ok := s.IEventSubscriber.(IConcurrentSubscriber); ok {
+		return c.Concurrency()
+	}
+	return 0
+}
+
+// LogIdMiddleware 把发布事件时的logid注入到ctx中, 没有时生成新的logid
+func LogIdMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event *EventEntity) error {
+			if _, ok := ctx.Value(logs.CtxLogIDKey).(string); !ok {
+				logId := event.LogId
+				if logId == "" {
+					logId = logs.GenLogId()
+				}
+				ctx = context.WithValue(ctx, logs.CtxLogIDKey, logId)
+			}
+			return next(ctx, event)
+		}
+	}
+}
+
+// DurationMiddleware 记录处理耗时, 上报到m并在处理较慢或失败时打印日志; m 为空时使用 metrics.MetricsImpl
+func DurationMiddleware(m metrics.IMetrics, slowThreshold time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event *EventEntity) error {
+			start := time.Now()
+			err := next(ctx, event)
+			cost := time.Since(start)
+
+			reporter := m
+			if reporter == nil {
+				reporter = metrics.MetricsImpl
+			}
+			reporter.Timer(metricHandleDuration, cost, metrics.T("topic", event.Topic), metrics.T("success", fmt.Sprint(err == nil)))
+			if err != nil || slowThreshold > 0 && cost >= slowThreshold {
+				logs.CtxInfo(ctx, "[DurationMiddleware] handle event", logs.String("topic", event.Topic),
+					logs.Int64("eventId", event.EventId), logs.Duration("cost", cost), logs.Bool("success", err == nil))
+			}
+			return err
+		}
+	}
+}
+
+// RecoverMiddleware 把订阅者的panic转为错误, 事件按重试策略重新投递
+func RecoverMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event *EventEntity) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logs.CtxError(ctx, "[RecoverMiddleware] subscriber panic", logs.String("topic", event.Topic),
+						logs.Int64("eventId", event.EventId), logs.String("panic", fmt.Sprint(r)), logs.String("stack", string(debug.Stack())))
+					err = fmt.Errorf("subscriber panic: %v", r)
+				}
+			}()
+			return next(ctx, event)
+		}
+	}
+}
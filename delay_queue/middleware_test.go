@@ -0,0 +1,107 @@
+package delay_queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+)
+
+type order struct {
+	Id     int64
+	Amount int
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	mw := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, event *EventEntity) error {
+				trace = append(trace, name+">")
+				err := next(ctx, event)
+				trace = append(trace, "<"+name)
+				return err
+			}
+		}
+	}
+	h := Chain(mw("a"), mw("b"))(func(ctx context.Context, event *EventEntity) error {
+		trace = append(trace, "handle")
+		return nil
+	})
+	_ = h(context.Background(), &EventEntity{})
+	if got := strings.Join(trace, " "); got != "a> b> handle <b <a" {
+		t.Fatalf("unexpected order %q", got)
+	}
+}
+
+func TestBuiltinMiddlewares(t *testing.T) {
+	var gotLogId string
+	h := Chain(RecoverMiddleware(), LogIdMiddleware(), DurationMiddleware(nil, time.Nanosecond))(
+		func(ctx context.Context, event *EventEntity) error {
+			gotLogId = logs.LogIDFromContext(ctx)
+			if event.Body == "panic" {
+				panic("boom")
+			}
+			return nil
+		})
+
+	if err := h(context.Background(), &EventEntity{LogId: "publisher"}); err != nil || gotLogId != "publisher" {
+		t.Fatalf("err = %v, logId = %q", err, gotLogId)
+	}
+	if err := h(context.Background(), &EventEntity{}); err != nil || gotLogId == "" || gotLogId == "-" {
+		t.Fatalf("err = %v, generated logId = %q", err, gotLogId)
+	}
+	ctx := context.WithValue(context.Background(), logs.CtxLogIDKey, "caller")
+	if _ = h(ctx, &EventEntity{LogId: "publisher"}); gotLogId != "caller" {
+		t.Fatalf("logId in ctx should be kept, got %q", gotLogId)
+	}
+	if err := h(context.Background(), &EventEntity{Body: "panic"}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected panic error, got %v", err)
+	}
+}
+
+func TestTypedSubscriberWithMiddleware(t *testing.T) {
+	got := make(chan order, 1)
+	s := NewSubscriber("order", func(ctx context.Context, event *EventEntity, payload order) error {
+		if logs.LogIDFromContext(ctx) != "publish-log-id" {
+			return errors.New("log id not propagated")
+		}
+		got <- payload
+		return nil
+	})
+	s.SubscriberName = "order-settle"
+	wrapped := WithMiddleware(s, RecoverMiddleware())
+	if subscriberName(wrapped) != "order-settle" || subscriberRetryPolicy(wrapped) != DefaultRetryPolicy {
+		t.Fatal("wrapped subscriber should keep name and retry policy")
+	}
+
+	q := NewDelayQueueWithBackend("test", NewMemoryBackend())
+	q.WithIdGenerator(&counterIdGenerator{})
+	q.Use(LogIdMiddleware())
+	q.InitOnce(wrapped)
+	defer q.ShutDown()
+
+	event, err := NewEvent("order", order{Id: 1, Amount: 100}, time.Now(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), logs.CtxLogIDKey, "publish-log-id")
+	if err = q.PublishEvent(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case o := <-got:
+		if o.Id != 1 || o.Amount != 100 {
+			t.Fatalf("unexpected payload %+v", o)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait typed event timeout")
+	}
+
+	if err = s.Handle(context.Background(), &EventEntity{Body: "not json"}); err == nil {
+		t.Fatal("expected decode error")
+	}
+}
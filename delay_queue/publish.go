@@ -0,0 +1,87 @@
+package delay_queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/drip-in/eden_lib/id_gen"
+	"github.com/drip-in/eden_lib/logs"
+)
+
+// ErrDuplicateEvent 相同 EventId 的事件还没有被消费完
+var ErrDuplicateEvent = errors.New("delay_queue: duplicate event id")
+
+// WithIdGenerator 发布时为 EventId 为0的事件分配id, 例如 id_gen.NewIDGenerator().SetWorkerId(n).Init();
+// 没有设置时使用 id_gen.IdgeneratorImpl
+func (q *DelayQueue) WithIdGenerator(gen id_gen.IIdGenerator) {
+	q.idGenerator = gen
+}
+
+func (q *DelayQueue) assignEventId(event *EventEntity) error {
+	if event.EventId != 0 {
+		return nil
+	}
+	gen := q.idGenerator
+	if gen == nil {
+		gen = id_gen.IdgeneratorImpl
+	}
+	if gen == nil {
+		return errors.New("delay_queue: empty event id and no id generator")
+	}
+	id, err := gen.Get()
+	if err != nil {
+		return err
+	}
+	event.EventId = id
+	return nil
+}
+
+// PublishEvent 发布一个事件, EventId 为0时自动分配; 相同 EventId 的事件未被消费完时返回 ErrDuplicateEvent
+func (q *DelayQueue) PublishEvent(ctx context.Context, event *EventEntity) error {
+	duplicates, err := q.PublishEvents(ctx, []*EventEntity{event})
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return ErrDuplicateEvent
+	}
+	return nil
+}
+
+// PublishEvents 在一次网络往返中批量发布事件, 返回因 EventId 重复而没有发布的事件
+func (q *DelayQueue) PublishEvents(ctx context.Context, events []*EventEntity) (duplicates []*EventEntity, err error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	logId, _ := ctx.Value(logs.CtxLogIDKey).(string)
+	for _, event := range events {
+		if err = q.assignEventId(event); err != nil {
+			return nil, err
+		}
+		if event.LogId == "" {
+			event.LogId = logId
+		}
+	}
+
+	added, err := q.backend.Schedule(ctx, events)
+	if err != nil {
+		return nil, err
+	}
+	for i, ok := range added {
+		if !ok {
+			duplicates = append(duplicates, events[i])
+		}
+	}
+
+	// 唤醒调度器, 生效时间较晚的事件由调度器按时搬运, 不需要通知
+	woken := make(map[string]bool)
+	for i, event := range events {
+		if added[i] && !woken[event.Topic] && event.EffectTime.Before(time.Now().Add(q.maxPollInterval)) {
+			woken[event.Topic] = true
+			q.notifyScheduler(ctx, event.Topic, event.EffectTime)
+		}
+	}
+	logs.CtxInfo(ctx, "publish event success", logs.Int("count", len(events)-len(duplicates)), logs.Int("duplicates", len(duplicates)))
+	return duplicates, nil
+}
@@ -0,0 +1,45 @@
+package delay_queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPublishEvents(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		q := newTestQueue(newBackend())
+		q.WithIdGenerator(&counterIdGenerator{})
+		ctx := context.Background()
+
+		// EventId 为0时自动分配
+		event := &EventEntity{Topic: "topic", EffectTime: time.Now().Add(time.Hour)}
+		if err := q.PublishEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+		if event.EventId != 1 {
+			t.Fatalf("EventId = %v, want 1", event.EventId)
+		}
+		if err := q.PublishEvent(ctx, &EventEntity{EventId: 1, Topic: "topic", EffectTime: time.Now()}); err != ErrDuplicateEvent {
+			t.Fatalf("publish duplicate = %v", err)
+		}
+
+		// 跨多个批次时返回的重复事件与输入对应
+		events := make([]*EventEntity, 0, publishBatchSize+2)
+		for i := 0; i < publishBatchSize+1; i++ {
+			events = append(events, &EventEntity{Topic: "topic", EffectTime: time.Now().Add(time.Hour)})
+		}
+		dup := &EventEntity{EventId: 1, Topic: "topic", EffectTime: time.Now()}
+		events = append(events, dup)
+		duplicates, err := q.PublishEvents(ctx, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(duplicates) != 1 || duplicates[0] != dup {
+			t.Fatalf("duplicates = %v, want the last event", duplicates)
+		}
+		if pending, _ := q.ListPending(ctx, "topic", time.Now(), time.Now().Add(2*time.Hour), 0); len(pending) != publishBatchSize+2 {
+			t.Fatalf("pending = %v, want %v", len(pending), publishBatchSize+2)
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package delay_queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+)
+
+// MisfirePolicy 错过触发时间(例如服务停机或处理耗时超过间隔)时的处理方式
+type MisfirePolicy int
+
+const (
+	// MisfireSkip 跳过所有错过的触发, 从当前时间之后的下一次开始
+	MisfireSkip MisfirePolicy = iota
+	// MisfireFireOnce 错过的多次触发合并为一次, 立即执行
+	MisfireFireOnce
+	// MisfireCatchUp 错过的每一次触发都依次立即执行
+	MisfireCatchUp
+)
+
+const (
+	// maxCatchUpIterations 计算错过的触发时最多的迭代次数, 防止间隔很短时长时间循环
+	maxCatchUpIterations = 100000
+	// advanceRetryTimes 发布下一次触发失败时的重试次数, 间隔从 advanceRetryBackoff 开始翻倍
+	advanceRetryTimes   = 3
+	advanceRetryBackoff = 100 * time.Millisecond
+	// defaultReconcileInterval leader检查周期事件是否丢失触发的默认间隔
+	defaultReconcileInterval = time.Minute
+)
+
+var (
+	// ErrScheduleExists 同一topic下已经存在同名的周期事件
+	ErrScheduleExists = errors.New("delay_queue: recurring schedule already exists")
+	// ErrScheduleNotFound 周期事件不存在
+	ErrScheduleNotFound = errors.New("delay_queue: recurring schedule not found")
+)
+
+// RecurringSchedule 周期事件: 每次触发发布一个事件, 所有订阅者处理完成(或重试耗尽进入死信)后自动发布下一次
+type RecurringSchedule struct {
+	// Name topic内唯一的名字
+	Name  string
+	Topic string
+	Body  string
+	// Cron cron表达式, 与 Interval 二选一
+	Cron string
+	// Interval 固定的触发间隔
+	Interval time.Duration
+	Misfire  MisfirePolicy
+
+	// 以下字段由延迟队列维护
+	// EventId 等待触发的事件id
+	EventId int64
+	// NextTime 等待触发的事件的计划时间
+	NextTime   time.Time
+	CreateTime time.Time
+}
+
+func (s *RecurringSchedule) schedule() (Schedule, error) {
+	switch {
+	case s.Cron != "" && s.Interval > 0:
+		return nil, errors.New("delay_queue: both cron and interval are set")
+	case s.Cron != "":
+		return ParseCron(s.Cron)
+	case s.Interval > 0:
+		return Every(s.Interval), nil
+	default:
+		return nil, errors.New("delay_queue: neither cron nor interval is set")
+	}
+}
+
+// nextOccurrence 计算prev之后的下一次触发时间, 按 Misfire 处理已经错过的触发
+func (s *RecurringSchedule) nextOccurrence(schedule Schedule, prev, now time.Time) time.Time {
+	next := schedule.Next(prev)
+	if next.IsZero() || next.After(now) || s.Misfire == MisfireCatchUp {
+		return next
+	}
+	// 找到最后一次错过的触发
+	if every, ok := schedule.(everySchedule); ok {
+		next = next.Add(now.Sub(next) / every.interval * every.interval)
+	} else {
+		for i := 0; i < maxCatchUpIterations; i++ {
+			n := schedule.Next(next)
+			if n.IsZero() || n.After(now) {
+				break
+			}
+			next = n
+		}
+	}
+	if s.Misfire == MisfireFireOnce {
+		return next
+	}
+	return schedule.Next(next)
+}
+
+func (s *RecurringSchedule) newEvent() *EventEntity {
+	return &EventEntity{
+		Topic:      s.Topic,
+		Body:       s.Body,
+		EffectTime: s.NextTime,
+		Recurring:  s.Name,
+	}
+}
+
+// AddRecurring 添加周期事件, 第一次触发时间为当前时间之后的下一次
+func (q *DelayQueue) AddRecurring(ctx context.Context, s *RecurringSchedule) error {
+	if s.Name == "" || s.Topic == "" {
+		return errors.New("delay_queue: empty recurring schedule name or topic")
+	}
+	schedule, err := s.schedule()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s.CreateTime = now
+	s.NextTime = schedule.Next(now)
+	if s.NextTime.IsZero() {
+		return errors.New("delay_queue: recurring schedule never fires")
+	}
+	event := s.newEvent()
+	if err = q.assignEventId(event); err != nil {
+		return err
+	}
+	s.EventId = event.EventId
+
+	if err = q.backend.AddRecurring(ctx, s, event); err != nil {
+		return err
+	}
+	q.notifyScheduler(ctx, s.Topic, event.EffectTime)
+	return nil
+}
+
+// ListRecurring 列出topic下的全部周期事件
+func (q *DelayQueue) ListRecurring(ctx context.Context, topic string) ([]*RecurringSchedule, error) {
+	return q.backend.ListRecurring(ctx, topic)
+}
+
+// RemoveRecurring 删除周期事件并取消等待中的触发, 正在处理的触发不受影响, 但不会再发布下一次
+func (q *DelayQueue) RemoveRecurring(ctx context.Context, topic string, name string) error {
+	s, err := q.backend.RemoveRecurring(ctx, topic, name)
+	if err != nil {
+		return err
+	}
+	err = q.CancelEvent(ctx, topic, s.EventId)
+	if err == ErrEventNotFound || err == ErrEventProcessing {
+		return nil
+	}
+	return err
+}
+
+// WithReconcileInterval 设置leader检查周期事件的间隔, 需要在 InitOnce 之前调用
+func (q *DelayQueue) WithReconcileInterval(d time.Duration) {
+	if d > 0 {
+		q.reconcileInterval = d
+	}
+}
+
+// scheduleNext 周期事件的一次触发结束后发布下一次, 重复投递的触发不会重复发布;
+// 失败时退避重试, 仍然失败的由 leader 的定期检查重新发布
+func (q *DelayQueue) scheduleNext(ctx context.Context, topic string, event *EventEntity) {
+	backoff := advanceRetryBackoff
+	for i := 0; ; i++ {
+		err := q.advanceRecurring(ctx, topic, event.Recurring, event.EventId)
+		if err == nil {
+			return
+		}
+		if i >= advanceRetryTimes || atomic.LoadInt32(&q.isRunning) == 0 {
+			logs.CtxError(ctx, "[scheduleNext] give up", logs.String("err", err.Error()), logs.String("name", event.Recurring))
+			return
+		}
+		q.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// advanceRecurring 等待的触发仍为prevEventId时发布下一次; 只有可以重试的错误才返回error
+func (q *DelayQueue) advanceRecurring(ctx context.Context, topic string, name string, prevEventId int64) error {
+	s, err := q.backend.GetRecurring(ctx, topic, name)
+	if err == ErrScheduleNotFound {
+		// 已经被删除
+		return nil
+	}
+	if err != nil {
+		logs.CtxWarn(ctx, "[advanceRecurring] GetRecurring", logs.String("err", err.Error()), logs.String("name", name))
+		return err
+	}
+	if s.EventId != prevEventId {
+		return nil
+	}
+	schedule, err := s.schedule()
+	if err != nil {
+		logs.CtxError(ctx, "[advanceRecurring] invalid schedule", logs.String("err", err.Error()), logs.String("name", s.Name))
+		return nil
+	}
+	next := *s
+	next.NextTime = s.nextOccurrence(schedule, s.NextTime, time.Now())
+	if next.NextTime.IsZero() {
+		logs.CtxWarn(ctx, "[advanceRecurring] schedule never fires again", logs.String("name", s.Name))
+		return nil
+	}
+	nextEvent := next.newEvent()
+	if err = q.assignEventId(nextEvent); err != nil {
+		logs.CtxError(ctx, "[advanceRecurring] assignEventId", logs.String("err", err.Error()))
+		return err
+	}
+	next.EventId = nextEvent.EventId
+
+	ok, err := q.backend.AdvanceRecurring(ctx, prevEventId, &next, nextEvent)
+	if err != nil {
+		logs.CtxWarn(ctx, "[advanceRecurring] AdvanceRecurring", logs.String("err", err.Error()), logs.String("name", s.Name))
+		return err
+	}
+	if ok {
+		q.notifyScheduler(ctx, topic, nextEvent.EffectTime)
+	}
+	return nil
+}
+
+// runReconciler leader 定期检查周期事件, 重新发布已经丢失的触发
+func (q *DelayQueue) runReconciler(topicList []string) {
+	for {
+		q.sleep(q.reconcileInterval)
+		if atomic.LoadInt32(&q.isRunning) == 0 {
+			break
+		}
+		if !q.IsLeader() {
+			continue
+		}
+		for _, topic := range topicList {
+			q.reconcileRecurring(topic)
+		}
+	}
+}
+
+// reconcileRecurring 等待的触发已经不在事件池中(例如发布下一次或者确认时redis故障)时重新发布下一次;
+// 非可靠消费模式下正在处理的触发已经从事件池删除, 计划时间超过 reconcileInterval 后才认为丢失
+func (q *DelayQueue) reconcileRecurring(topic string) {
+	ctx := context.Background()
+	schedules, err := q.backend.ListRecurring(ctx, topic)
+	if err != nil {
+		logs.CtxWarn(ctx, "[reconcileRecurring] ListRecurring", logs.String("err", err.Error()), logs.String("topic", topic))
+		return
+	}
+	for _, s := range schedules {
+		if time.Since(s.NextTime) < q.reconcileInterval {
+			continue
+		}
+		_, err = q.backend.Lookup(ctx, topic, s.EventId)
+		if err != ErrEventNotFound {
+			if err != nil {
+				logs.CtxWarn(ctx, "[reconcileRecurring] Lookup", logs.String("err", err.Error()), logs.String("name", s.Name))
+			}
+			continue
+		}
+		logs.CtxWarn(ctx, "[reconcileRecurring] occurrence lost", logs.String("name", s.Name), logs.Int64("eventId", s.EventId))
+		_ = q.advanceRecurring(ctx, topic, s.Name, s.EventId)
+	}
+}
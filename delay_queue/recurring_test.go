@@ -0,0 +1,78 @@
+package delay_queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecurring(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		q := newTestQueue(newBackend())
+		q.WithIdGenerator(&counterIdGenerator{})
+		ctx := context.Background()
+
+		schedule := &RecurringSchedule{Name: "tick", Topic: "topic", Body: "tick", Interval: time.Second}
+		if err := q.AddRecurring(ctx, schedule); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.AddRecurring(ctx, &RecurringSchedule{Name: "tick", Topic: "topic", Interval: time.Second}); err != ErrScheduleExists {
+			t.Fatalf("add duplicate schedule = %v", err)
+		}
+		s := newTestSubscriber("topic")
+		q.InitOnce(s)
+		defer q.ShutDown()
+
+		// 每次触发处理完成后自动发布下一次
+		first := s.wait(t, 3*time.Second)
+		second := s.wait(t, 3*time.Second)
+		if first.Recurring != "tick" || second.Recurring != "tick" || first.EventId == second.EventId {
+			t.Fatalf("unexpected recurring events %+v, %+v", first, second)
+		}
+		var pending int64
+		waitUntil(t, time.Second, func() bool {
+			schedules, _ := q.ListRecurring(ctx, "topic")
+			if len(schedules) != 1 {
+				return false
+			}
+			pending = schedules[0].EventId
+			return pending != second.EventId
+		}, "next occurrence should be scheduled")
+
+		// 删除后取消等待中的触发
+		if err := q.RemoveRecurring(ctx, "topic", "tick"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.GetEvent(ctx, "topic", pending); err != ErrEventNotFound {
+			t.Fatalf("pending occurrence after remove = %v", err)
+		}
+		if err := q.RemoveRecurring(ctx, "topic", "tick"); err != ErrScheduleNotFound {
+			t.Fatalf("remove twice = %v", err)
+		}
+	})
+}
+
+func TestRecurringReconcile(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		q := newTestQueue(newBackend())
+		q.WithIdGenerator(&counterIdGenerator{})
+		q.WithReconcileInterval(100 * time.Millisecond)
+		ctx := context.Background()
+
+		schedule := &RecurringSchedule{Name: "tick", Topic: "topic", Interval: time.Second}
+		if err := q.AddRecurring(ctx, schedule); err != nil {
+			t.Fatal(err)
+		}
+		// 模拟丢失的触发: 周期事件还在, 等待的事件已经不在事件池中
+		if err := q.CancelEvent(ctx, "topic", schedule.EventId); err != nil {
+			t.Fatal(err)
+		}
+		s := newTestSubscriber("topic")
+		q.InitOnce(s)
+		defer q.ShutDown()
+
+		if got := s.wait(t, 4*time.Second); got.Recurring != "tick" || got.EventId == schedule.EventId {
+			t.Fatalf("unexpected recurring event %+v", got)
+		}
+	})
+}
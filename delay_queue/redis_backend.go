@@ -0,0 +1,664 @@
+package delay_queue
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/drip-in/eden_lib/el_utils"
+	"github.com/drip-in/eden_lib/logs"
+	"github.com/go-redis/redis"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// publishBatchSize Schedule 每次执行脚本写入的事件数量, 避免单个脚本阻塞redis太久
+const publishBatchSize = 500
+
+const (
+	// minMilliScore 小于该值的score是旧版本写入的秒级时间戳(毫秒时间戳在1973年之后都不小于该值)
+	minMilliScore = "100000000000"
+	// rescaleLegacyScores 把KEYS[1]中最多ARGV[2]个秒级score换算为毫秒
+	rescaleLegacyScores = `
+	  local legacy = redis.call('ZRangeByScore', KEYS[1], '-inf', '(` + minMilliScore + `', 'WITHSCORES', 'limit', 0, ARGV[2])
+	  for i = 1, #legacy, 2 do
+		redis.call('ZAdd', KEYS[1], tonumber(legacy[i + 1]) * 1000, legacy[i])
+	  end`
+)
+
+var (
+	// 原子的写入事件池和Bucket, EventId 已经存在时不做任何修改
+	// keys: [poolKey, bucketKey]...
+	// argv: [eventId, event, score]...
+	// 返回每个事件是否写入成功(1成功, 0重复)
+	publishScript = redis.NewScript(`
+	  local res = {}
+	  for i = 1, #KEYS, 2 do
+		local j = (i - 1) / 2 * 3
+		if redis.call('HSetNX', KEYS[i], ARGV[j + 1], ARGV[j + 2]) == 1 then
+		  redis.call('ZAdd', KEYS[i + 1], ARGV[j + 3], ARGV[j + 1])
+		  res[#res + 1] = 1
+		else
+		  res[#res + 1] = 0
+		end
+	  end
+	  return res
+	  `)
+
+	// 扫描zset中到期的任务，添加到对应topic的待消费队列里，并从Bucket中删除已进入待消费队列的事件;
+	// 每次都取指定数量,防止消息突增; 同时返回Bucket中最早的事件的生效时间(没有时为-1)
+	// 选主模式下任期号与当前任期不一致时拒绝搬运, 返回 {-1, -1}
+	// 旧版本写入的秒级score先换算为毫秒, 还没换算完的不会被搬运
+	// keys: bucketKey, queueKey, epochKey
+	// argv: now(毫秒), batchSize, epoch(0表示不校验)
+	carryScript = redis.NewScript(`
+	  if ARGV[3] ~= '0' and redis.call('Get', KEYS[3]) ~= ARGV[3] then
+		return {-1, -1}
+	  end
+	  ` + rescaleLegacyScores + `
+	  local members = redis.call('ZRangeByScore', KEYS[1], '` + minMilliScore + `', ARGV[1], 'limit', 0, ARGV[2])
+	  if(next(members) ~= nil) then
+		redis.call('ZRem', KEYS[1], unpack(members, 1, #members))
+		redis.call('RPush', KEYS[2], unpack(members, 1, #members))
+	  end
+	  local earliest = redis.call('ZRange', KEYS[1], 0, 0, 'WITHSCORES')
+	  if(next(earliest) == nil) then
+		return {#members, -1}
+	  end
+	  return {#members, tonumber(earliest[2])}
+	  `)
+
+	// 从待消费队列取出一个事件, 同时放入处理中集合并设置可见性截止时间(毫秒)
+	reliablePopScript = redis.NewScript(`
+	  local id = redis.call('LPop', KEYS[1])
+	  if id then
+		redis.call('ZAdd', KEYS[2], ARGV[1], id)
+	  end
+	  return id
+	  `)
+
+	// 把超过可见性截止时间仍未确认的事件放回待消费队列, 旧版本写入的秒级截止时间先换算为毫秒
+	reapScript = redis.NewScript(`
+	  ` + rescaleLegacyScores + `
+	  local members = redis.call('ZRangeByScore', KEYS[1], '` + minMilliScore + `', ARGV[1], 'limit', 0, ARGV[2])
+	  if(next(members) ~= nil) then
+		redis.call('ZRem', KEYS[1], unpack(members, 1, #members))
+		redis.call('RPush', KEYS[2], unpack(members, 1, #members))
+	  end
+	  return #members
+	  `)
+
+	// 结束一次投递: 从处理中集合删除; 需要重试时更新事件池并放回Bucket, 否则删除事件池中的事件; 写入死信
+	// keys: processingKey, poolKey, bucketKey, deadKey
+	// argv: eventId, retryEvent(为空表示不重试), retryScore, [deadId, deadLetter]...
+	settleScript = redis.NewScript(`
+	  redis.call('ZRem', KEYS[1], ARGV[1])
+	  if ARGV[2] ~= '' then
+		redis.call('HSet', KEYS[2], ARGV[1], ARGV[2])
+		redis.call('ZAdd', KEYS[3], ARGV[3], ARGV[1])
+	  else
+		redis.call('HDel', KEYS[2], ARGV[1])
+	  end
+	  for i = 4, #ARGV, 2 do
+		redis.call('HSet', KEYS[4], ARGV[i], ARGV[i + 1])
+	  end
+	  return 1
+	  `)
+
+	// 把死信重新发布为事件, 死信不存在时返回0
+	// keys: deadKey, poolKey, bucketKey
+	// argv: deadId, eventId, event, score
+	replayScript = redis.NewScript(`
+	  if redis.call('HDel', KEYS[1], ARGV[1]) == 0 then
+		return 0
+	  end
+	  redis.call('HSet', KEYS[2], ARGV[2], ARGV[3])
+	  redis.call('ZAdd', KEYS[3], ARGV[4], ARGV[2])
+	  return 1
+	  `)
+
+	// 取消事件: 从Bucket、待消费队列和事件池中删除
+	// keys: bucketKey, queueKey, poolKey, processingKey
+	// argv: eventId
+	// 返回 1成功, 0不存在, -1处理中
+	cancelScript = redis.NewScript(`
+	  if redis.call('ZScore', KEYS[4], ARGV[1]) then
+		return -1
+	  end
+	  if redis.call('HDel', KEYS[3], ARGV[1]) == 0 then
+		return 0
+	  end
+	  redis.call('ZRem', KEYS[1], ARGV[1])
+	  redis.call('LRem', KEYS[2], 0, ARGV[1])
+	  return 1
+	  `)
+
+	// 重新调度事件: 事件池中的内容没有变化时才更新, 并从待消费队列移回Bucket
+	// keys: bucketKey, queueKey, poolKey, processingKey
+	// argv: eventId, oldEvent, newEvent, score
+	// 返回 1成功, 0不存在, -1处理中, -2被并发修改
+	rescheduleScript = redis.NewScript(`
+	  if redis.call('ZScore', KEYS[4], ARGV[1]) then
+		return -1
+	  end
+	  local data = redis.call('HGet', KEYS[3], ARGV[1])
+	  if not data then
+		return 0
+	  end
+	  if data ~= ARGV[2] then
+		return -2
+	  end
+	  redis.call('HSet', KEYS[3], ARGV[1], ARGV[3])
+	  redis.call('LRem', KEYS[2], 0, ARGV[1])
+	  redis.call('ZAdd', KEYS[1], ARGV[4], ARGV[1])
+	  return 1
+	  `)
+
+	// 添加周期事件并发布第一次触发的事件
+	// keys: recurringKey, poolKey, bucketKey
+	// argv: name, schedule, eventId, event, score
+	addRecurringScript = redis.NewScript(`
+	  if redis.call('HSetNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+		return 0
+	  end
+	  redis.call('HSet', KEYS[2], ARGV[3], ARGV[4])
+	  redis.call('ZAdd', KEYS[3], ARGV[5], ARGV[3])
+	  return 1
+	  `)
+
+	// 周期事件没有被修改时, 更新为下一次触发并发布对应的事件
+	// keys: recurringKey, poolKey, bucketKey
+	// argv: name, oldSchedule, newSchedule, eventId, event, score
+	advanceRecurringScript = redis.NewScript(`
+	  if redis.call('HGet', KEYS[1], ARGV[1]) ~= ARGV[2] then
+		return 0
+	  end
+	  redis.call('HSet', KEYS[1], ARGV[1], ARGV[3])
+	  redis.call('HSet', KEYS[2], ARGV[4], ARGV[5])
+	  redis.call('ZAdd', KEYS[3], ARGV[6], ARGV[4])
+	  return 1
+	  `)
+
+	// 删除周期事件, 返回删除前的内容
+	// keys: recurringKey
+	// argv: name
+	removeRecurringScript = redis.NewScript(`
+	  local data = redis.call('HGet', KEYS[1], ARGV[1])
+	  if data then
+		redis.call('HDel', KEYS[1], ARGV[1])
+	  end
+	  return data
+	  `)
+)
+
+// RedisBackend 基于redis的存储后端, 支持多个实例共同消费
+type RedisBackend struct {
+	namespace   string
+	redisClient *redis.Client
+}
+
+func NewRedisBackend(namespace string, redisClient *redis.Client) *RedisBackend {
+	return &RedisBackend{
+		namespace:   namespace,
+		redisClient: redisClient,
+	}
+}
+
+func (b *RedisBackend) genBucketKey(topic string) string {
+	return fmt.Sprintf("BUCKET_%v_%v", b.namespace, topic)
+}
+
+func (b *RedisBackend) genPoolKey(topic string) string {
+	return fmt.Sprintf("POOL_%v_%v", b.namespace, topic)
+}
+
+func (b *RedisBackend) genQueueKey(topic string) string {
+	return fmt.Sprintf("QUEUE_%v_%v", b.namespace, topic)
+}
+
+func (b *RedisBackend) genProcessingKey(topic string) string {
+	return fmt.Sprintf("PROCESSING_%v_%v", b.namespace, topic)
+}
+
+func (b *RedisBackend) genDeadKey(topic string) string {
+	return fmt.Sprintf("DEAD_%v_%v", b.namespace, topic)
+}
+
+func (b *RedisBackend) genRecurringKey(topic string) string {
+	return fmt.Sprintf("RECURRING_%v_%v", b.namespace, topic)
+}
+
+func (b *RedisBackend) genEpochKey() string {
+	return fmt.Sprintf("LEADER_EPOCH_%v", b.namespace)
+}
+
+func (b *RedisBackend) genWakeupChannel() string {
+	return fmt.Sprintf("WAKEUP_%v", b.namespace)
+}
+
+func (b *RedisBackend) eventKeys(topic string) []string {
+	return []string{b.genBucketKey(topic), b.genQueueKey(topic), b.genPoolKey(topic), b.genProcessingKey(topic)}
+}
+
+func formatEventId(eventId int64) string {
+	return strconv.FormatInt(eventId, 10)
+}
+
+// unixMilli Bucket、处理中集合的score都是毫秒时间戳; 旧版本写入的秒级score在 MoveDue 和 Reap 时换算为毫秒
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func scriptResultErr(res int64) error {
+	switch res {
+	case 0:
+		return ErrEventNotFound
+	case -1:
+		return ErrEventProcessing
+	case -2:
+		return ErrEventChanged
+	default:
+		return nil
+	}
+}
+
+func (b *RedisBackend) Schedule(ctx context.Context, events []*EventEntity) ([]bool, error) {
+	pipeline := b.redisClient.WithContext(ctx).Pipeline()
+	defer pipeline.Close()
+
+	cmds := make([]*redis.Cmd, 0, (len(events)+publishBatchSize-1)/publishBatchSize)
+	for start := 0; start < len(events); start += publishBatchSize {
+		end := start + publishBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		keys := make([]string, 0, 2*(end-start))
+		args := make([]interface{}, 0, 3*(end-start))
+		for _, event := range events[start:end] {
+			keys = append(keys, b.genPoolKey(event.Topic), b.genBucketKey(event.Topic))
+			args = append(args, formatEventId(event.EventId), el_utils.ToJsonString(event), unixMilli(event.EffectTime))
+		}
+		// pipeline 中无法处理 NOSCRIPT, 直接使用 Eval
+		cmds = append(cmds, publishScript.Eval(pipeline, keys, args...))
+	}
+	_, err := pipeline.Exec()
+	if err != nil {
+		logs.CtxWarn(ctx, "pipeline.Exec", logs.String("err", err.Error()))
+		return nil, err
+	}
+
+	added := make([]bool, 0, len(events))
+	for _, cmd := range cmds {
+		res, _ := cmd.Val().([]interface{})
+		for _, r := range res {
+			added = append(added, r.(int64) == 1)
+		}
+	}
+	return added, nil
+}
+
+func (b *RedisBackend) MoveDue(ctx context.Context, topic string, now time.Time, limit int64, epoch int64) (int64, time.Time, error) {
+	res, err := carryScript.Run(b.redisClient.WithContext(ctx),
+		[]string{b.genBucketKey(topic), b.genQueueKey(topic), b.genEpochKey()},
+		unixMilli(now), limit, epoch).Result()
+	if err != nil {
+		logs.CtxError(ctx, "[carryEventToQueue] script.Run", logs.String("err", err.Error()))
+		return 0, time.Time{}, err
+	}
+	vals := res.([]interface{})
+	moved, earliest := vals[0].(int64), vals[1].(int64)
+	if moved < 0 {
+		return 0, time.Time{}, ErrFenced
+	}
+	if earliest < 0 {
+		return moved, time.Time{}, nil
+	}
+	return moved, time.Unix(0, earliest*int64(time.Millisecond)), nil
+}
+
+func (b *RedisBackend) Pop(ctx context.Context, topic string, wait time.Duration, deadline time.Time) (int64, *EventEntity, error) {
+	var eventId string
+	if deadline.IsZero() {
+		// 阻塞时间不宜过长, 否则 ShutDown 需要等待
+		kvPair, err := b.redisClient.WithContext(ctx).BLPop(wait, b.genQueueKey(topic)).Result()
+		if err == redis.Nil || err == nil && len(kvPair) < 2 {
+			return 0, nil, nil
+		}
+		if err != nil {
+			logs.CtxWarn(ctx, "[runConsumer] BLPop", logs.String("err", err.Error()))
+			return 0, nil, err
+		}
+		eventId = kvPair[1]
+	} else {
+		// 脚本中不能阻塞, 队列为空时等待后再尝试一次
+		start := time.Now()
+		for i := 0; i < 2 && eventId == ""; i++ {
+			if i > 0 && !sleepCtx(ctx, wait) {
+				break
+			}
+			res, err := reliablePopScript.Run(b.redisClient.WithContext(ctx),
+				[]string{b.genQueueKey(topic), b.genProcessingKey(topic)}, unixMilli(deadline.Add(time.Since(start)))).Result()
+			if err != nil && err != redis.Nil {
+				logs.CtxWarn(ctx, "[runReliableConsumer] reliablePopScript.Run", logs.String("err", err.Error()))
+				return 0, nil, err
+			}
+			eventId, _ = res.(string)
+		}
+		if eventId == "" {
+			return 0, nil, nil
+		}
+	}
+
+	id := el_utils.String2Int64(eventId)
+	data, err := b.redisClient.WithContext(ctx).HGet(b.genPoolKey(topic), eventId).Result()
+	if err == redis.Nil {
+		return id, nil, ErrEventNotFound
+	}
+	if err != nil {
+		logs.CtxWarn(ctx, "[Pop] HGet", logs.String("err", err.Error()))
+		if !deadline.IsZero() {
+			// 等待可见性超时后重新投递
+			return 0, nil, err
+		}
+		return id, nil, err
+	}
+	if deadline.IsZero() {
+		// 非可靠模式下事件出队即从事件池删除, 失败重试时由 Ack 重新写入
+		if err = b.redisClient.WithContext(ctx).HDel(b.genPoolKey(topic), eventId).Err(); err != nil {
+			logs.CtxWarn(ctx, "[Pop] HDel", logs.String("err", err.Error()))
+		}
+	}
+	event := &EventEntity{}
+	if err = jsoniter.UnmarshalFromString(data, event); err != nil {
+		return id, &EventEntity{EventId: id, Topic: topic, Body: data}, err
+	}
+	return id, event, nil
+}
+
+func (b *RedisBackend) Touch(ctx context.Context, topic string, eventId int64, deadline time.Time) error {
+	// 只更新仍在处理中集合里的事件, 已经被重新投递的不再延长
+	return b.redisClient.WithContext(ctx).ZAddXX(b.genProcessingKey(topic), redis.Z{
+		Member: formatEventId(eventId),
+		Score:  float64(unixMilli(deadline)),
+	}).Err()
+}
+
+func (b *RedisBackend) Reap(ctx context.Context, topic string, now time.Time, limit int64) (int64, error) {
+	res, err := reapScript.Run(b.redisClient.WithContext(ctx),
+		[]string{b.genProcessingKey(topic), b.genQueueKey(topic)}, unixMilli(now), limit).Result()
+	if err != nil {
+		logs.CtxError(ctx, "[reapExpired] reapScript.Run", logs.String("err", err.Error()))
+		return 0, err
+	}
+	return res.(int64), nil
+}
+
+func (b *RedisBackend) Ack(ctx context.Context, topic string, eventId int64, retry *EventEntity, dead []*DeadLetter) error {
+	args := []interface{}{formatEventId(eventId), "", 0}
+	if retry != nil {
+		args[1] = el_utils.ToJsonString(retry)
+		args[2] = unixMilli(retry.EffectTime)
+	}
+	for _, letter := range dead {
+		args = append(args, letter.Id, el_utils.ToJsonString(letter))
+	}
+	err := settleScript.Run(b.redisClient.WithContext(ctx),
+		[]string{b.genProcessingKey(topic), b.genPoolKey(topic), b.genBucketKey(topic), b.genDeadKey(topic)}, args...).Err()
+	if err != nil {
+		logs.CtxWarn(ctx, "[settle] settleScript.Run", logs.String("err", err.Error()), logs.Int64("eventId", eventId))
+	}
+	return err
+}
+
+func (b *RedisBackend) Lookup(ctx context.Context, topic string, eventId int64) (*EventEntity, error) {
+	data, err := b.redisClient.WithContext(ctx).HGet(b.genPoolKey(topic), formatEventId(eventId)).Result()
+	if err == redis.Nil {
+		return nil, ErrEventNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	event := &EventEntity{}
+	if err = jsoniter.UnmarshalFromString(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (b *RedisBackend) Cancel(ctx context.Context, topic string, eventId int64) error {
+	res, err := cancelScript.Run(b.redisClient.WithContext(ctx), b.eventKeys(topic), formatEventId(eventId)).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, "[CancelEvent] cancelScript.Run", logs.String("err", err.Error()))
+		return err
+	}
+	return scriptResultErr(res)
+}
+
+func (b *RedisBackend) Reschedule(ctx context.Context, topic string, eventId int64, effectTime time.Time) error {
+	id := formatEventId(eventId)
+	data, err := b.redisClient.WithContext(ctx).HGet(b.genPoolKey(topic), id).Result()
+	if err == redis.Nil {
+		return ErrEventNotFound
+	}
+	if err != nil {
+		return err
+	}
+	event := &EventEntity{}
+	if err = jsoniter.UnmarshalFromString(data, event); err != nil {
+		return err
+	}
+	event.EffectTime = effectTime
+
+	res, err := rescheduleScript.Run(b.redisClient.WithContext(ctx), b.eventKeys(topic),
+		id, data, el_utils.ToJsonString(event), unixMilli(effectTime)).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, "[RescheduleEvent] rescheduleScript.Run", logs.String("err", err.Error()))
+		return err
+	}
+	return scriptResultErr(res)
+}
+
+func (b *RedisBackend) Count(ctx context.Context, topic string) (pending, ready, processing int64, err error) {
+	pipeline := b.redisClient.WithContext(ctx).Pipeline()
+	defer pipeline.Close()
+	pendingCmd := pipeline.ZCard(b.genBucketKey(topic))
+	readyCmd := pipeline.LLen(b.genQueueKey(topic))
+	processingCmd := pipeline.ZCard(b.genProcessingKey(topic))
+	if _, err = pipeline.Exec(); err != nil {
+		return 0, 0, 0, err
+	}
+	return pendingCmd.Val(), readyCmd.Val(), processingCmd.Val(), nil
+}
+
+func (b *RedisBackend) ListPending(ctx context.Context, topic string, from, to time.Time, limit int64) ([]*EventEntity, error) {
+	ids, err := b.redisClient.WithContext(ctx).ZRangeByScore(b.genBucketKey(topic), redis.ZRangeBy{
+		Min:   strconv.FormatInt(unixMilli(from), 10),
+		Max:   strconv.FormatInt(unixMilli(to), 10),
+		Count: limit,
+	}).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	vals, err := b.redisClient.WithContext(ctx).HMGet(b.genPoolKey(topic), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*EventEntity, 0, len(vals))
+	for i, val := range vals {
+		data, ok := val.(string)
+		if !ok {
+			// 在两次查询之间被消费或者取消了
+			continue
+		}
+		event := &EventEntity{}
+		if err = jsoniter.UnmarshalFromString(data, event); err != nil {
+			logs.CtxWarn(ctx, "[ListPending] unmarshal event", logs.String("err", err.Error()), logs.String("eventId", ids[i]))
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (b *RedisBackend) ListDeadLetters(ctx context.Context, topic string, cursor uint64, count int64) ([]*DeadLetter, uint64, error) {
+	kvs, nextCursor, err := b.redisClient.WithContext(ctx).HScan(b.genDeadKey(topic), cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	var letters []*DeadLetter
+	for i := 0; i+1 < len(kvs); i += 2 {
+		letter := &DeadLetter{}
+		if err := jsoniter.UnmarshalFromString(kvs[i+1], letter); err != nil {
+			logs.CtxWarn(ctx, "[ListDeadLetters] unmarshal", logs.String("err", err.Error()), logs.String("id", kvs[i]))
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nextCursor, nil
+}
+
+func (b *RedisBackend) GetDeadLetter(ctx context.Context, topic string, id string) (*DeadLetter, error) {
+	data, err := b.redisClient.WithContext(ctx).HGet(b.genDeadKey(topic), id).Result()
+	if err == redis.Nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	letter := &DeadLetter{}
+	if err = jsoniter.UnmarshalFromString(data, letter); err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+func (b *RedisBackend) ReplayDeadLetter(ctx context.Context, topic string, id string, event *EventEntity) error {
+	res, err := replayScript.Run(b.redisClient.WithContext(ctx),
+		[]string{b.genDeadKey(topic), b.genPoolKey(topic), b.genBucketKey(topic)},
+		id, formatEventId(event.EventId), el_utils.ToJsonString(event), unixMilli(event.EffectTime)).Int64()
+	if err != nil {
+		return err
+	}
+	if res == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func (b *RedisBackend) PurgeDeadLetters(ctx context.Context, topic string, ids ...string) error {
+	if len(ids) == 0 {
+		return b.redisClient.WithContext(ctx).Del(b.genDeadKey(topic)).Err()
+	}
+	return b.redisClient.WithContext(ctx).HDel(b.genDeadKey(topic), ids...).Err()
+}
+
+func (b *RedisBackend) AddRecurring(ctx context.Context, s *RecurringSchedule, event *EventEntity) error {
+	res, err := addRecurringScript.Run(b.redisClient.WithContext(ctx),
+		[]string{b.genRecurringKey(s.Topic), b.genPoolKey(s.Topic), b.genBucketKey(s.Topic)},
+		s.Name, el_utils.ToJsonString(s), formatEventId(event.EventId), el_utils.ToJsonString(event), unixMilli(event.EffectTime)).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, "[AddRecurring] addRecurringScript.Run", logs.String("err", err.Error()))
+		return err
+	}
+	if res == 0 {
+		return ErrScheduleExists
+	}
+	return nil
+}
+
+func (b *RedisBackend) getRecurring(ctx context.Context, topic string, name string) (string, *RecurringSchedule, error) {
+	data, err := b.redisClient.WithContext(ctx).HGet(b.genRecurringKey(topic), name).Result()
+	if err == redis.Nil {
+		return "", nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	s := &RecurringSchedule{}
+	if err = jsoniter.UnmarshalFromString(data, s); err != nil {
+		return "", nil, err
+	}
+	return data, s, nil
+}
+
+func (b *RedisBackend) GetRecurring(ctx context.Context, topic string, name string) (*RecurringSchedule, error) {
+	_, s, err := b.getRecurring(ctx, topic, name)
+	return s, err
+}
+
+func (b *RedisBackend) AdvanceRecurring(ctx context.Context, prevEventId int64, next *RecurringSchedule, event *EventEntity) (bool, error) {
+	data, prev, err := b.getRecurring(ctx, next.Topic, next.Name)
+	if err == ErrScheduleNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if prev.EventId != prevEventId {
+		return false, nil
+	}
+	// 以读取到的内容做比较, 期间被修改或删除时不更新
+	res, err := advanceRecurringScript.Run(b.redisClient.WithContext(ctx),
+		[]string{b.genRecurringKey(next.Topic), b.genPoolKey(next.Topic), b.genBucketKey(next.Topic)},
+		next.Name, data, el_utils.ToJsonString(next), formatEventId(event.EventId),
+		el_utils.ToJsonString(event), unixMilli(event.EffectTime)).Int64()
+	if err != nil {
+		logs.CtxWarn(ctx, "[scheduleNext] advanceRecurringScript.Run", logs.String("err", err.Error()), logs.String("name", next.Name))
+		return false, err
+	}
+	return res == 1, nil
+}
+
+func (b *RedisBackend) ListRecurring(ctx context.Context, topic string) ([]*RecurringSchedule, error) {
+	kvs, err := b.redisClient.WithContext(ctx).HGetAll(b.genRecurringKey(topic)).Result()
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]*RecurringSchedule, 0, len(kvs))
+	for name, data := range kvs {
+		s := &RecurringSchedule{}
+		if err = jsoniter.UnmarshalFromString(data, s); err != nil {
+			logs.CtxWarn(ctx, "[ListRecurring] unmarshal", logs.String("err", err.Error()), logs.String("name", name))
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+func (b *RedisBackend) RemoveRecurring(ctx context.Context, topic string, name string) (*RecurringSchedule, error) {
+	data, err := removeRecurringScript.Run(b.redisClient.WithContext(ctx), []string{b.genRecurringKey(topic)}, name).String()
+	if err == redis.Nil {
+		return nil, ErrScheduleNotFound
+	}
+	if err != nil {
+		logs.CtxWarn(ctx, "[RemoveRecurring] removeRecurringScript.Run", logs.String("err", err.Error()))
+		return nil, err
+	}
+	s := &RecurringSchedule{}
+	if err = jsoniter.UnmarshalFromString(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (b *RedisBackend) NextEpoch(ctx context.Context) (int64, error) {
+	return b.redisClient.WithContext(ctx).Incr(b.genEpochKey()).Result()
+}
+
+func (b *RedisBackend) Notify(ctx context.Context, topic string) error {
+	return b.redisClient.WithContext(ctx).Publish(b.genWakeupChannel(), topic).Err()
+}
+
+func (b *RedisBackend) Subscribe(fn func(topic string)) (cancel func()) {
+	pubsub := b.redisClient.Subscribe(b.genWakeupChannel())
+	ch := pubsub.Channel()
+	el_utils.GoSafe(func(ctx context.Context) {
+		for msg := range ch {
+			fn(msg.Payload)
+		}
+	})
+	return func() {
+		_ = pubsub.Close()
+	}
+}
@@ -0,0 +1,144 @@
+package delay_queue
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+)
+
+const (
+	// reliablePollInterval 可靠消费模式下待消费队列为空时的轮询间隔
+	reliablePollInterval = time.Second
+	// reapBatchSize 每次最多重新投递的超时事件数量
+	reapBatchSize = 100
+)
+
+// WithReliableConsume 开启可靠消费模式(至少一次):
+// 事件出队时放入处理中集合并设置可见性截止时间, 所有订阅者都处理完成(失败的已按重试策略重新调度)后才确认;
+// 处理期间会定期延长截止时间, 进程崩溃导致没有确认的事件在截止时间过后被重新投递.
+// 同一事件可能被重复投递给已经处理成功的订阅者, 订阅者需要保证幂等
+func (q *DelayQueue) WithReliableConsume(visibilityTimeout time.Duration) {
+	q.visibilityTimeout = visibilityTimeout
+}
+
+func (q *DelayQueue) reliable() bool {
+	return q.visibilityTimeout > 0
+}
+
+func (q *DelayQueue) visibilityDeadline() time.Time {
+	return time.Now().Add(q.visibilityTimeout)
+}
+
+func (q *DelayQueue) runReliableConsumer(topic string, workers []*subscriberWorker) error {
+	for {
+		if atomic.LoadInt32(&q.isRunning) == 0 {
+			break
+		}
+		q.wg.Add(1)
+		ctx := context.Background()
+		id, event, err := q.backend.Pop(q.ctx, topic, reliablePollInterval, q.visibilityDeadline())
+		if id == 0 {
+			q.wg.Done()
+			if err != nil {
+				q.sleep(reliablePollInterval)
+			}
+			continue
+		}
+
+		q.handleReliably(ctx, topic, id, event, err, workers)
+		q.wg.Done()
+	}
+	return nil
+}
+
+// handleReliably 把事件交给订阅者处理, 所有订阅者处理完成后确认
+func (q *DelayQueue) handleReliably(ctx context.Context, topic string, eventId int64, event *EventEntity, popErr error, workers []*subscriberWorker) {
+	if popErr == ErrEventNotFound {
+		// 事件内容已经不存在, 没有必要再投递
+		logs.CtxWarn(ctx, "[handleReliably] event not found", logs.String("topic", topic), logs.Int64("eventId", eventId))
+		q.ack(ctx, topic, eventId)
+		return
+	}
+	if popErr != nil {
+		logs.CtxError(ctx, "[handleReliably] decode event", logs.String("err", popErr.Error()), logs.Int64("eventId", eventId))
+		if q.persistFn != nil {
+			if event == nil {
+				event = &EventEntity{EventId: eventId, Topic: topic}
+			}
+			_ = q.persistFn(event)
+		}
+		q.ack(ctx, topic, eventId)
+		return
+	}
+
+	stopExtend := q.keepInvisible(topic, eventId)
+	q.dispatch(ctx, event, workers, func(failures map[*subscriberWorker]error) {
+		stopExtend()
+		q.settle(ctx, topic, eventId, event, failures)
+	})
+}
+
+// ack 确认事件已处理完成: 从处理中集合和事件池中删除
+func (q *DelayQueue) ack(ctx context.Context, topic string, eventId int64) {
+	q.settle(ctx, topic, eventId, nil, nil)
+}
+
+// keepInvisible 处理期间每隔半个可见性超时延长一次截止时间, 返回停止延长的函数
+func (q *DelayQueue) keepInvisible(topic string, eventId int64) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(q.visibilityTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := q.backend.Touch(context.Background(), topic, eventId, q.visibilityDeadline())
+				if err != nil {
+					logs.Warn("[keepInvisible] Touch", logs.String("err", err.Error()), logs.Int64("eventId", eventId))
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// reapExpired 把超过可见性截止时间的事件放回待消费队列
+func (q *DelayQueue) reapExpired(topic string) (int64, error) {
+	ctx := context.Background()
+	count, err := q.backend.Reap(ctx, topic, time.Now(), reapBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		q.onRedelivered(topic, count)
+		logs.CtxInfo(ctx, "[reapExpired] redeliver events", logs.String("topic", topic), logs.Int64("count", count))
+	}
+	return count, nil
+}
+func (q *DelayQueue) runReaper(topic string) {
+	for {
+		if atomic.LoadInt32(&q.isRunning) == 0 {
+			break
+		}
+		count, err := q.reapExpired(topic)
+		if err != nil || count < reapBatchSize {
+			q.sleep(reliablePollInterval)
+		}
+	}
+}
+
+// sleep 等待d, ShutDown 时提前返回
+func (q *DelayQueue) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-q.stop:
+	}
+}
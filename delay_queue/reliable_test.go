@@ -0,0 +1,76 @@
+package delay_queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReliableConsume(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		backend := newBackend()
+		q := newTestQueue(backend)
+		q.WithReliableConsume(200 * time.Millisecond)
+		ctx := context.Background()
+
+		event := &EventEntity{EventId: 1, Topic: "topic", Body: "hello", EffectTime: time.Now()}
+		if err := q.PublishEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+		// 模拟出队后崩溃的消费者: 事件留在处理中集合, 可见性超时后重新投递
+		crashed := time.Now()
+		popUnacked(t, backend, "topic", q.visibilityDeadline())
+		s := newTestSubscriber("topic")
+		q.InitOnce(s)
+		defer q.ShutDown()
+
+		// 重新投递的检查每秒一次
+		got := s.wait(t, 3*time.Second)
+		if got.EventId != event.EventId || got.Body != "hello" {
+			t.Fatalf("unexpected event %+v", got)
+		}
+		if time.Since(crashed) < 200*time.Millisecond {
+			t.Fatal("event redelivered before the visibility timeout")
+		}
+
+		// 处理成功后从处理中集合和事件池删除
+		waitUntil(t, time.Second, func() bool {
+			_, err := backend.Lookup(ctx, "topic", 1)
+			return err == ErrEventNotFound
+		}, "event should be acked")
+	})
+}
+
+func TestReliableConsumeKeepInvisible(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		q := newTestQueue(newBackend())
+		q.WithReliableConsume(100 * time.Millisecond)
+		ctx := context.Background()
+
+		// 处理时间超过可见性超时, 处理期间持续延长截止时间, 不会被重新投递
+		s := &slowSubscriber{testSubscriber: newTestSubscriber("topic"), delay: 500 * time.Millisecond}
+		if err := q.PublishEvent(ctx, &EventEntity{EventId: 1, Topic: "topic", EffectTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		q.InitOnce(s)
+		defer q.ShutDown()
+
+		s.wait(t, 2*time.Second)
+		select {
+		case event := <-s.ch:
+			t.Fatalf("event redelivered while processing %+v", event)
+		case <-time.After(1500 * time.Millisecond):
+		}
+	})
+}
+
+type slowSubscriber struct {
+	*testSubscriber
+	delay time.Duration
+}
+
+func (s *slowSubscriber) Handle(ctx context.Context, event *EventEntity) error {
+	err := s.testSubscriber.Handle(ctx, event)
+	time.Sleep(s.delay)
+	return err
+}
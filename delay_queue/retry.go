@@ -0,0 +1,175 @@
+package delay_queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+)
+
+// ErrDeadLetterNotFound 死信不存在, 或者已经被重新投递或删除
+var ErrDeadLetterNotFound = errors.New("delay_queue: dead letter not found")
+
+// RetryPolicy 订阅者处理失败后的重试策略, 重试通过把事件重新放入Bucket实现, 不会阻塞消费
+type RetryPolicy struct {
+	// MaxAttempts 最多投递次数(包含第一次), 超过后进入死信; <=1 表示不重试
+	MaxAttempts int
+	// BaseBackoff 第一次重试的等待时长, 之后每次翻倍
+	BaseBackoff time.Duration
+	// MaxBackoff 等待时长的上限, <=0 表示不限制
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy 订阅者没有实现 IRetryableSubscriber 时使用
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseBackoff: time.Second,
+	MaxBackoff:  time.Minute,
+}
+
+// IRetryableSubscriber 订阅者实现该接口以使用自己的重试策略
+type IRetryableSubscriber interface {
+	RetryPolicy() RetryPolicy
+}
+
+// INamedSubscriber 订阅者实现该接口以指定名字, 名字用于定向重试和死信; 默认使用订阅者的类型名
+type INamedSubscriber interface {
+	Name() string
+}
+
+// DeadLetter 重试次数耗尽的事件
+type DeadLetter struct {
+	// Id 死信的唯一标识, 格式为 <EventId>:<订阅者名字>
+	Id         string
+	Event      *EventEntity
+	Subscriber string
+	LastError  string
+	DeadTime   time.Time
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseBackoff
+	for i := 1; i < attempt && d > 0; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if d < 0 || p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+func subscriberName(s IEventSubscriber) string {
+	if named, ok := s.(INamedSubscriber); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+func subscriberRetryPolicy(s IEventSubscriber) RetryPolicy {
+	if retryable, ok := s.(IRetryableSubscriber); ok {
+		return retryable.RetryPolicy()
+	}
+	return DefaultRetryPolicy
+}
+
+func genDeadLetterId(eventId int64, subscriber string) string {
+	return fmt.Sprintf("%v:%v", eventId, subscriber)
+}
+
+func (q *DelayQueue) handle(ctx context.Context, w *subscriberWorker, event *EventEntity) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panic: %v", r)
+		}
+	}()
+	err = w.handler(ctx, event)
+	if err != nil {
+		logs.CtxWarn(ctx, "[runConsumer] subscriber.Handle", logs.String("err", err.Error()),
+			logs.String("subscriber", w.name), logs.Int64("eventId", event.EventId))
+	}
+	return err
+}
+
+// settle 根据处理结果结束一次投递: 失败的订阅者按各自的重试策略重新调度, 重试次数耗尽的进入死信
+func (q *DelayQueue) settle(ctx context.Context, topic string, eventId int64, event *EventEntity, failures map[*subscriberWorker]error) {
+	var retry *EventEntity
+	var dead []*DeadLetter
+	if event != nil && len(failures) > 0 {
+		now := time.Now()
+		attempt := event.Attempt + 1
+		var retryNames []string
+		var retryDelay time.Duration
+		for w, handleErr := range failures {
+			name, policy := w.name, w.policy
+			if attempt >= policy.MaxAttempts {
+				letter := &DeadLetter{
+					Id:         genDeadLetterId(event.EventId, name),
+					Event:      event,
+					Subscriber: name,
+					LastError:  handleErr.Error(),
+					DeadTime:   now,
+				}
+				dead = append(dead, letter)
+				logs.CtxError(ctx, "[settle] event dead", logs.String("topic", topic), logs.String("deadLetter", letter.Id), logs.String("err", letter.LastError))
+				continue
+			}
+			// 多个订阅者一起重试时, 使用最短的等待时长
+			delay := policy.backoff(attempt)
+			if len(retryNames) == 0 || delay < retryDelay {
+				retryDelay = delay
+			}
+			retryNames = append(retryNames, name)
+		}
+		if len(retryNames) > 0 {
+			r := *event
+			r.Attempt = attempt
+			r.Subscribers = retryNames
+			r.EffectTime = now.Add(retryDelay)
+			retry = &r
+		}
+	}
+
+	if err := q.backend.Ack(ctx, topic, eventId, retry, dead); err != nil {
+		return
+	}
+	q.onSettled(topic, retry, dead)
+	if retry != nil {
+		q.notifyScheduler(ctx, topic, retry.EffectTime)
+	}
+	// 周期事件的这次触发已经结束(全部成功或者进入死信), 发布下一次
+	if event != nil && event.Recurring != "" && retry == nil {
+		q.scheduleNext(ctx, topic, event)
+	}
+}
+
+// ListDeadLetters 分页列出topic的死信, cursor 从0开始, 返回的 nextCursor 为0表示已经遍历完
+func (q *DelayQueue) ListDeadLetters(ctx context.Context, topic string, cursor uint64, count int64) (letters []*DeadLetter, nextCursor uint64, err error) {
+	return q.backend.ListDeadLetters(ctx, topic, cursor, count)
+}
+
+// ReplayDeadLetter 把死信立即重新投递给原来失败的订阅者, 投递次数从头计算; 死信不存在时返回 ErrDeadLetterNotFound
+func (q *DelayQueue) ReplayDeadLetter(ctx context.Context, topic string, id string) error {
+	letter, err := q.backend.GetDeadLetter(ctx, topic, id)
+	if err != nil {
+		return err
+	}
+	event := *letter.Event
+	event.Attempt = 0
+	event.Subscribers = []string{letter.Subscriber}
+	event.EffectTime = time.Now()
+	if err = q.backend.ReplayDeadLetter(ctx, topic, id, &event); err != nil {
+		return err
+	}
+	q.notifyScheduler(ctx, topic, event.EffectTime)
+	return nil
+}
+
+// PurgeDeadLetters 删除指定的死信, 不指定id时删除topic的全部死信
+func (q *DelayQueue) PurgeDeadLetters(ctx context.Context, topic string, ids ...string) error {
+	return q.backend.PurgeDeadLetters(ctx, topic, ids...)
+}
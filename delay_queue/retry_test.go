@@ -0,0 +1,92 @@
+package delay_queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type namedSubscriber struct {
+	*testSubscriber
+	name string
+}
+
+func (s *namedSubscriber) Name() string {
+	return s.name
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		q := newTestQueue(newBackend())
+		ctx := context.Background()
+
+		s := &namedSubscriber{testSubscriber: newTestSubscriber("topic"), name: "s"}
+		s.fail = 2
+		if err := q.PublishEvent(ctx, &EventEntity{EventId: 1, Topic: "topic", Body: "hello", EffectTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		q.InitOnce(s)
+		defer q.ShutDown()
+
+		// 投递次数耗尽后进入死信
+		var letters []*DeadLetter
+		waitUntil(t, 3*time.Second, func() bool {
+			letters, _, _ = q.ListDeadLetters(ctx, "topic", 0, 10)
+			return len(letters) == 1
+		}, "event should be dead after 2 attempts")
+		if len(s.ch) != 2 {
+			t.Fatalf("delivered %v times, want 2", len(s.ch))
+		}
+		if letters[0].Id != "1:s" || letters[0].Subscriber != "s" || letters[0].Event.Body != "hello" || letters[0].LastError != "mock failure" {
+			t.Fatalf("unexpected dead letter %+v", letters[0])
+		}
+		if _, err := q.GetEvent(ctx, "topic", 1); err != ErrEventNotFound {
+			t.Fatalf("dead event left in pool: %v", err)
+		}
+
+		// 重放后重新投递, 投递次数从头计算
+		if err := q.ReplayDeadLetter(ctx, "topic", "1:s"); err != nil {
+			t.Fatal(err)
+		}
+		waitUntil(t, 3*time.Second, func() bool {
+			return len(s.ch) == 3
+		}, "replayed event should be delivered")
+		s.wait(t, time.Second)
+		s.wait(t, time.Second)
+		if got := s.wait(t, time.Second); got.Attempt != 0 {
+			t.Fatalf("replayed attempt = %v, want 0", got.Attempt)
+		}
+		if err := q.ReplayDeadLetter(ctx, "topic", "1:s"); err != ErrDeadLetterNotFound {
+			t.Fatalf("replay missing dead letter = %v", err)
+		}
+	})
+}
+
+func TestRetryOnlyFailedSubscribers(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		q := newTestQueue(newBackend())
+		ctx := context.Background()
+
+		ok := &namedSubscriber{testSubscriber: newTestSubscriber("topic"), name: "ok"}
+		bad := &namedSubscriber{testSubscriber: newTestSubscriber("topic"), name: "bad"}
+		bad.fail = 1
+		if err := q.PublishEvent(ctx, &EventEntity{EventId: 1, Topic: "topic", EffectTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		q.InitOnce(ok, bad)
+		defer q.ShutDown()
+
+		// 重试只投递给失败的订阅者
+		waitUntil(t, 3*time.Second, func() bool {
+			return len(bad.ch) == 2
+		}, "failed subscriber should be retried")
+		bad.wait(t, time.Second)
+		if got := bad.wait(t, time.Second); got.Attempt != 1 || len(got.Subscribers) != 1 || got.Subscribers[0] != "bad" {
+			t.Fatalf("unexpected retry %+v", got)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if len(ok.ch) != 1 {
+			t.Fatalf("succeeded subscriber delivered %v times, want 1", len(ok.ch))
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package delay_queue
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+)
+
+const (
+	defaultCarryBatchSize  = 20
+	defaultMaxPollInterval = 5 * time.Second
+	// consumerPopTimeout 消费者每次阻塞出队的最长时间
+	consumerPopTimeout = time.Second
+	// schedulerErrBackoff 调度出错后等待多久重试
+	schedulerErrBackoff = time.Second
+)
+
+// WithCarryBatchSize 设置每次从Bucket搬到待消费队列的最大事件数量, 需要在 InitOnce 之前调用
+func (q *DelayQueue) WithCarryBatchSize(size int64) {
+	if size > 0 {
+		q.carryBatchSize = size
+	}
+}
+
+// WithMaxPollInterval 设置调度器最长的等待时间, 用于兜底没有收到发布通知的情况, 需要在 InitOnce 之前调用
+func (q *DelayQueue) WithMaxPollInterval(d time.Duration) {
+	if d > 0 {
+		q.maxPollInterval = d
+	}
+}
+
+// carryEventToQueue 返回搬运的事件数量, 以及Bucket中最早的事件的生效时间(没有事件时为零值)
+func (q *DelayQueue) carryEventToQueue(topic string, epoch int64) (int64, time.Time, error) {
+	return q.backend.MoveDue(context.Background(), topic, time.Now(), q.carryBatchSize, epoch)
+}
+
+// runScheduler 循环搬运到期的事件: 还有到期事件时立即继续,
+// 否则等到最早的事件生效, 最长等待 maxPollInterval, 期间收到发布通知或者 ShutDown 会立即醒来;
+// 选主模式下不是leader时只等待
+func (q *DelayQueue) runScheduler(topic string) {
+	for {
+		if atomic.LoadInt32(&q.isRunning) == 0 {
+			break
+		}
+		epoch, ok := q.schedulerEpoch()
+		if !ok {
+			q.waitWakeup(topic, q.maxPollInterval)
+			continue
+		}
+		count, earliest, err := q.carryEventToQueue(topic, epoch)
+		if err == ErrFenced {
+			q.stepDown(epoch)
+			continue
+		}
+		if err != nil {
+			q.sleep(schedulerErrBackoff)
+			continue
+		}
+		if count >= q.carryBatchSize {
+			continue
+		}
+
+		wait := q.maxPollInterval
+		if !earliest.IsZero() {
+			if d := time.Until(earliest); d < wait {
+				wait = d
+			}
+		}
+		if wait <= 0 {
+			continue
+		}
+		q.waitWakeup(topic, wait)
+	}
+}
+
+func (q *DelayQueue) waitWakeup(topic string, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-q.wakeups[topic]:
+	case <-q.stop:
+	}
+}
+
+// subscribeWakeup 订阅发布通知, 收到消息时唤醒对应topic的调度器
+func (q *DelayQueue) subscribeWakeup(topicList []string) {
+	q.wakeups = make(map[string]chan struct{}, len(topicList))
+	for _, topic := range topicList {
+		q.wakeups[topic] = make(chan struct{}, 1)
+	}
+	q.unsubscribe = q.backend.Subscribe(q.wakeup)
+}
+
+// notifyScheduler 事件在下一次轮询之前就会生效时, 通知各实例的调度器立即检查
+func (q *DelayQueue) notifyScheduler(ctx context.Context, topic string, effectTime time.Time) {
+	if !effectTime.Before(time.Now().Add(q.maxPollInterval)) {
+		return
+	}
+	if err := q.backend.Notify(ctx, topic); err != nil {
+		logs.CtxWarn(ctx, "[notifyScheduler] Publish", logs.String("err", err.Error()))
+	}
+}
+
+func (q *DelayQueue) wakeup(topic string) {
+	wakeup, ok := q.wakeups[topic]
+	if !ok {
+		return
+	}
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,29 @@
+package delay_queue
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerWakeup(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		q := newTestQueue(newBackend())
+		q.WithMaxPollInterval(time.Minute)
+		ctx := context.Background()
+
+		s := newTestSubscriber("topic")
+		q.InitOnce(s)
+		defer q.ShutDown()
+		// 等待调度器进入休眠
+		time.Sleep(200 * time.Millisecond)
+
+		// 启动后发布的事件通过发布通知唤醒调度器, 不需要等待 maxPollInterval
+		if err := q.PublishEvent(ctx, &EventEntity{EventId: 1, Topic: "topic", EffectTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.wait(t, 2*time.Second); got.EventId != 1 {
+			t.Fatalf("unexpected event %+v", got)
+		}
+	})
+}
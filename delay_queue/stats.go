@@ -0,0 +1,197 @@
+package delay_queue
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/drip-in/eden_lib/metrics"
+)
+
+// metricsReportInterval 上报队列长度等gauge指标的间隔
+const metricsReportInterval = 10 * time.Second
+
+// 上报的指标名
+const (
+	metricPending     = "delay_queue.pending"
+	metricReady       = "delay_queue.ready"
+	metricProcessing  = "delay_queue.processing"
+	metricInFlight    = "delay_queue.in_flight"
+	metricHandled     = "delay_queue.handled"
+	metricFailed      = "delay_queue.failed"
+	metricRetried     = "delay_queue.retried"
+	metricDead        = "delay_queue.dead"
+	metricRedelivered = "delay_queue.redelivered"
+	metricDelay       = "delay_queue.delay"
+)
+
+// TopicStats topic的统计信息快照
+type TopicStats struct {
+	Topic string
+	// 以下来自存储后端, 所有实例共享
+	// Pending Bucket中还没有到期的事件数量(包括等待重试的事件)
+	Pending int64
+	// Ready 已经到期、等待出队的事件数量
+	Ready int64
+	// Processing 可靠消费模式下已经出队、还没有确认的事件数量
+	Processing int64
+
+	// 以下为当前实例启动以来的统计
+	// InFlight 正在被订阅者处理的事件数量
+	InFlight int64
+	// Handled、Failed 订阅者处理成功、失败的次数
+	Handled uint64
+	Failed  uint64
+	// Retried 按重试策略重新调度的次数, Dead 进入死信的次数
+	Retried uint64
+	Dead    uint64
+	// Redelivered 可见性超时后被重新投递的事件数量
+	Redelivered uint64
+	// LastDelay、AvgDelay 从事件生效到开始处理的延迟
+	LastDelay time.Duration
+	AvgDelay  time.Duration
+}
+
+// topicCounters 当前实例的累计统计, 字段都通过atomic访问
+type topicCounters struct {
+	inFlight    int64
+	handled     uint64
+	failed      uint64
+	retried     uint64
+	dead        uint64
+	redelivered uint64
+	delayCount  int64
+	delaySum    int64
+	lastDelay   int64
+}
+
+// WithMetrics 设置指标上报的实现, 没有设置时使用 metrics.MetricsImpl
+func (q *DelayQueue) WithMetrics(m metrics.IMetrics) {
+	q.metricsImpl = m
+}
+
+func (q *DelayQueue) metrics() metrics.IMetrics {
+	if q.metricsImpl != nil {
+		return q.metricsImpl
+	}
+	return metrics.MetricsImpl
+}
+
+func (q *DelayQueue) topicTags(topic string) []metrics.Tag {
+	return []metrics.Tag{metrics.T("namespace", q.namespace), metrics.T("topic", topic)}
+}
+
+// counters 没有订阅的topic返回nil
+func (q *DelayQueue) counters(topic string) *topicCounters {
+	return q.stats[topic]
+}
+
+// Stats 返回topic的统计信息, 队列长度需要查询存储后端
+func (q *DelayQueue) Stats(ctx context.Context, topic string) (*TopicStats, error) {
+	pending, ready, processing, err := q.backend.Count(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	stats := &TopicStats{
+		Topic:      topic,
+		Pending:    pending,
+		Ready:      ready,
+		Processing: processing,
+	}
+	if c := q.counters(topic); c != nil {
+		stats.InFlight = atomic.LoadInt64(&c.inFlight)
+		stats.Handled = atomic.LoadUint64(&c.handled)
+		stats.Failed = atomic.LoadUint64(&c.failed)
+		stats.Retried = atomic.LoadUint64(&c.retried)
+		stats.Dead = atomic.LoadUint64(&c.dead)
+		stats.Redelivered = atomic.LoadUint64(&c.redelivered)
+		stats.LastDelay = time.Duration(atomic.LoadInt64(&c.lastDelay))
+		if count := atomic.LoadInt64(&c.delayCount); count > 0 {
+			stats.AvgDelay = time.Duration(atomic.LoadInt64(&c.delaySum) / count)
+		}
+	}
+	return stats, nil
+}
+
+// onDispatch 事件开始处理时记录延迟和处理中的数量
+func (q *DelayQueue) onDispatch(event *EventEntity) {
+	delay := time.Since(event.EffectTime)
+	if delay < 0 {
+		delay = 0
+	}
+	if c := q.counters(event.Topic); c != nil {
+		atomic.AddInt64(&c.inFlight, 1)
+		atomic.StoreInt64(&c.lastDelay, int64(delay))
+		atomic.AddInt64(&c.delaySum, int64(delay))
+		atomic.AddInt64(&c.delayCount, 1)
+	}
+	q.metrics().Timer(metricDelay, delay, q.topicTags(event.Topic)...)
+}
+
+func (q *DelayQueue) onDispatchDone(event *EventEntity) {
+	if c := q.counters(event.Topic); c != nil {
+		atomic.AddInt64(&c.inFlight, -1)
+	}
+}
+
+func (q *DelayQueue) onHandled(event *EventEntity, subscriber string, err error) {
+	tags := append(q.topicTags(event.Topic), metrics.T("subscriber", subscriber))
+	c := q.counters(event.Topic)
+	if err != nil {
+		if c != nil {
+			atomic.AddUint64(&c.failed, 1)
+		}
+		q.metrics().Counter(metricFailed, 1, tags...)
+		return
+	}
+	if c != nil {
+		atomic.AddUint64(&c.handled, 1)
+	}
+	q.metrics().Counter(metricHandled, 1, tags...)
+}
+
+func (q *DelayQueue) onSettled(topic string, retry *EventEntity, dead []*DeadLetter) {
+	c := q.counters(topic)
+	if retry != nil {
+		if c != nil {
+			atomic.AddUint64(&c.retried, 1)
+		}
+		q.metrics().Counter(metricRetried, 1, q.topicTags(topic)...)
+	}
+	if len(dead) > 0 {
+		if c != nil {
+			atomic.AddUint64(&c.dead, uint64(len(dead)))
+		}
+		q.metrics().Counter(metricDead, int64(len(dead)), q.topicTags(topic)...)
+	}
+}
+
+func (q *DelayQueue) onRedelivered(topic string, count int64) {
+	if c := q.counters(topic); c != nil {
+		atomic.AddUint64(&c.redelivered, uint64(count))
+	}
+	q.metrics().Counter(metricRedelivered, count, q.topicTags(topic)...)
+}
+
+// runMetricsReporter 定期上报队列长度, 没有设置指标上报时不查询存储后端
+func (q *DelayQueue) runMetricsReporter(topic string) {
+	for {
+		q.sleep(metricsReportInterval)
+		if atomic.LoadInt32(&q.isRunning) == 0 {
+			break
+		}
+		m := q.metrics()
+		if metrics.IsNop(m) {
+			continue
+		}
+		stats, err := q.Stats(context.Background(), topic)
+		if err != nil {
+			continue
+		}
+		tags := q.topicTags(topic)
+		m.Gauge(metricPending, float64(stats.Pending), tags...)
+		m.Gauge(metricReady, float64(stats.Ready), tags...)
+		m.Gauge(metricProcessing, float64(stats.Processing), tags...)
+		m.Gauge(metricInFlight, float64(stats.InFlight), tags...)
+	}
+}
@@ -0,0 +1,62 @@
+package delay_queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drip-in/eden_lib/metrics"
+)
+
+type countingMetrics struct {
+	metrics.NopMetrics
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+func (m *countingMetrics) Counter(name string, value int64, tags ...metrics.Tag) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name] += value
+}
+
+func TestStats(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		s := newTestSubscriber("topic")
+		s.fail = 1
+		m := &countingMetrics{counters: make(map[string]int64)}
+		q := newTestQueue(newBackend())
+		q.WithIdGenerator(&counterIdGenerator{})
+		q.WithMetrics(m)
+		q.InitOnce(s)
+		defer q.ShutDown()
+		ctx := context.Background()
+
+		if err := q.PublishEvent(ctx, &EventEntity{Topic: "topic", EffectTime: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.PublishEvent(ctx, &EventEntity{Topic: "topic", EffectTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		s.wait(t, 2*time.Second)
+		s.wait(t, 2*time.Second)
+
+		var stats *TopicStats
+		waitUntil(t, time.Second, func() bool {
+			var err error
+			if stats, err = q.Stats(ctx, "topic"); err != nil {
+				t.Fatal(err)
+			}
+			return stats.Handled == 1 && stats.InFlight == 0
+		}, "retried event should be handled")
+		if stats.Pending != 1 || stats.Ready != 0 || stats.Handled != 1 || stats.Failed != 1 || stats.Retried != 1 || stats.InFlight != 0 {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.counters[metricHandled] != 1 || m.counters[metricFailed] != 1 || m.counters[metricRetried] != 1 {
+			t.Fatalf("unexpected counters %v", m.counters)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package delay_queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drip-in/eden_lib/el_tool"
+)
+
+// Subscriber 泛型订阅者, 使用Codec把Body解码为T之后交给Handler, 订阅者不需要再自己解析Body.
+// Body 是字符串, Codec 的输出需要是文本, 例如 el_tool.JsonCodec
+type Subscriber[T any] struct {
+	TopicName string
+	// SubscriberName 为空时使用类型名, 同一topic有多个相同类型的订阅者时需要设置
+	SubscriberName string
+	// Codec 为空时使用 el_tool.JsonCodec
+	Codec   el_tool.Codec
+	Handler func(ctx context.Context, event *EventEntity, payload T) error
+	// Policy 为空时使用 DefaultRetryPolicy
+	Policy *RetryPolicy
+}
+
+func NewSubscriber[T any](topic string, handler func(ctx context.Context, event *EventEntity, payload T) error) *Subscriber[T] {
+	return &Subscriber[T]{
+		TopicName: topic,
+		Handler:   handler,
+	}
+}
+
+func (s *Subscriber[T]) Topic() string {
+	return s.TopicName
+}
+
+func (s *Subscriber[T]) Name() string {
+	if s.SubscriberName != "" {
+		return s.SubscriberName
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+func (s *Subscriber[T]) RetryPolicy() RetryPolicy {
+	if s.Policy != nil {
+		return *s.Policy
+	}
+	return DefaultRetryPolicy
+}
+
+func (s *Subscriber[T]) Handle(ctx context.Context, event *EventEntity) error {
+	var payload T
+	if err := codecOrDefault(s.Codec).Unmarshal([]byte(event.Body), &payload); err != nil {
+		return fmt.Errorf("delay_queue: decode payload of event %v: %w", event.EventId, err)
+	}
+	return s.Handler(ctx, event, payload)
+}
+
+// NewEvent 使用codec把payload编码为Body, codec 为空时使用 el_tool.JsonCodec
+func NewEvent[T any](topic string, payload T, effectTime time.Time, codec el_tool.Codec) (*EventEntity, error) {
+	data, err := codecOrDefault(codec).Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &EventEntity{
+		Topic:      topic,
+		Body:       string(data),
+		EffectTime: effectTime,
+	}, nil
+}
+
+func codecOrDefault(codec el_tool.Codec) el_tool.Codec {
+	if codec == nil {
+		return el_tool.JsonCodec
+	}
+	return codec
+}
@@ -0,0 +1,94 @@
+package delay_queue
+
+import (
+	"context"
+	"sync"
+
+	"github.com/drip-in/eden_lib/gopool"
+)
+
+// defaultSubscriberConcurrency 订阅者没有实现 IConcurrentSubscriber 时同时处理的事件数量
+const defaultSubscriberConcurrency = 10
+
+// IConcurrentSubscriber 订阅者实现该接口以指定同时处理的事件数量
+type IConcurrentSubscriber interface {
+	Concurrency() int
+}
+
+// subscriberWorker 每个订阅者独立的有界协程池, 并发已满时阻塞出队, 避免事件堆积在内存中
+type subscriberWorker struct {
+	subscriber IEventSubscriber
+	// handler 经过中间件包装的 subscriber.Handle
+	handler HandlerFunc
+	name    string
+	policy  RetryPolicy
+	pool    gopool.Pool
+	sem     chan struct{}
+}
+
+func newSubscriberWorker(s IEventSubscriber, middlewares []Middleware) *subscriberWorker {
+	concurrency := defaultSubscriberConcurrency
+	if c, ok := s.(IConcurrentSubscriber); ok && c.Concurrency() > 0 {
+		concurrency = c.Concurrency()
+	}
+	return &subscriberWorker{
+		subscriber: s,
+		handler:    Chain(middlewares...)(s.Handle),
+		name:       subscriberName(s),
+		policy:     subscriberRetryPolicy(s),
+		pool:       gopool.NewPool(int32(concurrency)),
+		sem:        make(chan struct{}, concurrency),
+	}
+}
+
+// targetWorkers 重试的事件只投递给之前失败的订阅者
+func targetWorkers(event *EventEntity, workers []*subscriberWorker) []*subscriberWorker {
+	if len(event.Subscribers) == 0 {
+		return workers
+	}
+	targets := make([]*subscriberWorker, 0, len(event.Subscribers))
+	for _, w := range workers {
+		for _, name := range event.Subscribers {
+			if w.name == name {
+				targets = append(targets, w)
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// dispatch 把事件交给每个目标订阅者的协程池处理, 订阅者的并发已满时阻塞调用方;
+// 所有订阅者处理完成后在新的goroutine中调用done, 传入失败的订阅者及其错误
+func (q *DelayQueue) dispatch(ctx context.Context, event *EventEntity, workers []*subscriberWorker, done func(failures map[*subscriberWorker]error)) {
+	q.onDispatch(event)
+	failures := make(map[*subscriberWorker]error)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, worker := range targetWorkers(event, workers) {
+		w := worker
+		w.sem <- struct{}{}
+		wg.Add(1)
+		w.pool.CtxGo(ctx, func() {
+			defer func() {
+				<-w.sem
+				wg.Done()
+			}()
+			err := q.handle(ctx, w, event)
+			q.onHandled(event, w.name, err)
+			if err != nil {
+				mu.Lock()
+				failures[w] = err
+				mu.Unlock()
+			}
+		})
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		wg.Wait()
+		q.onDispatchDone(event)
+		done(failures)
+	}()
+}
@@ -0,0 +1,80 @@
+package delay_queue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type concurrentSubscriber struct {
+	*testSubscriber
+	concurrency  int
+	active, peak int32
+}
+
+func (s *concurrentSubscriber) Concurrency() int {
+	return s.concurrency
+}
+
+func (s *concurrentSubscriber) Handle(ctx context.Context, event *EventEntity) error {
+	active := atomic.AddInt32(&s.active, 1)
+	defer atomic.AddInt32(&s.active, -1)
+	for {
+		peak := atomic.LoadInt32(&s.peak)
+		if active <= peak || atomic.CompareAndSwapInt32(&s.peak, peak, active) {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	return s.testSubscriber.Handle(ctx, event)
+}
+
+func TestSubscriberConcurrency(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		q := newTestQueue(newBackend())
+		q.WithConsumerCount("topic", 3)
+		ctx := context.Background()
+
+		for i := 1; i <= 6; i++ {
+			if err := q.PublishEvent(ctx, &EventEntity{EventId: int64(i), Topic: "topic", EffectTime: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s := &concurrentSubscriber{testSubscriber: newTestSubscriber("topic"), concurrency: 2}
+		q.InitOnce(s)
+		defer q.ShutDown()
+
+		// 多个消费者同时出队, 订阅者的并发不超过 Concurrency
+		for i := 0; i < 6; i++ {
+			s.wait(t, 2*time.Second)
+		}
+		if peak := atomic.LoadInt32(&s.peak); peak != 2 {
+			t.Fatalf("max concurrency = %v, want 2", peak)
+		}
+	})
+}
+
+func TestIndependentInstances(t *testing.T) {
+	runWithBackends(t, func(t *testing.T, newBackend func() IBackend) {
+		ctx := context.Background()
+
+		// 不同后端的实例互不影响
+		q1, q2 := newTestQueue(newBackend()), newTestQueue(newBackend())
+		s1, s2 := newTestSubscriber("topic"), newTestSubscriber("topic")
+		if err := q1.PublishEvent(ctx, &EventEntity{EventId: 1, Topic: "topic", EffectTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		q1.InitOnce(s1)
+		q2.InitOnce(s2)
+		defer q1.ShutDown()
+		defer q2.ShutDown()
+
+		s1.wait(t, 2*time.Second)
+		select {
+		case event := <-s2.ch:
+			t.Fatalf("event %+v delivered to another namespace", event)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package el_tool
+
+import (
+	"github.com/drip-in/eden_lib/el_utils"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Codec 负责把值序列化后写入 IStorage, 以及把读出的数据反序列化
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JsonCodec 使用 jsoniter 序列化
+	JsonCodec Codec = jsonCodec{}
+	// GzipJsonCodec 序列化为json之后再gzip压缩, 适用于比较大的值
+	GzipJsonCodec = NewGzipCodec(JsonCodec)
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return jsoniter.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return jsoniter.Unmarshal(data, v)
+}
+
+type gzipCodec struct {
+	inner Codec
+}
+
+// NewGzipCodec 在inner序列化的结果上做gzip压缩
+func NewGzipCodec(inner Codec) Codec {
+	return &gzipCodec{inner: inner}
+}
+
+func (c *gzipCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return el_utils.GzipEncode(data)
+}
+
+func (c *gzipCodec) Unmarshal(data []byte, v interface{}) error {
+	raw, err := el_utils.GzipDecode(data)
+	if err != nil {
+		return err
+	}
+	return c.inner.Unmarshal(raw, v)
+}
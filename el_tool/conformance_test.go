@@ -0,0 +1,325 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestMemoryStorageConformance(t *testing.T) {
+	testStorageConformance(t, NewMemoryStorage())
+}
+
+func TestMemoryStorageSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryStorage()
+	s.now = func() time.Time { return now }
+	if err := s.MSet(ctx, map[string]interface{}{"a": 1, "b": 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// 只通过 Set 写入也会清理过期的key
+	now = now.Add(2 * time.Second)
+	for i := 0; i < memoryStorageSweepEvery; i++ {
+		if err := s.Set(ctx, "c", i, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(s.entries); n != 1 {
+		t.Fatalf("expired keys should be swept, %v entries left", n)
+	}
+}
+
+func TestRedisStorageConformance(t *testing.T) {
+	client, ns := testRedisClient(t)
+	testStorageConformance(t, NewPrefixedStorage(ns, client))
+}
+
+func TestRedisStoragePrefix(t *testing.T) {
+	client, ns := testRedisClient(t)
+	ctx := context.Background()
+	// NewStorageV2 不加前缀, 与 NewPrefixedStorage 的key对应
+	raw, prefixed := NewStorageV2(ns, client), NewPrefixedStorage(ns, client)
+	if err := raw.Set(ctx, ns+"_k", "v", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := prefixed.Get(ctx, "k"); err != nil || got != "v" {
+		t.Fatalf("Get prefixed key = %v, %v", got, err)
+	}
+	if _, err := raw.Get(ctx, "k"); err != ErrNotFound {
+		t.Fatalf("Get unprefixed key = %v", err)
+	}
+	_ = raw.Del(ctx, ns+"_k")
+}
+
+func TestRedisLegacyNotFound(t *testing.T) {
+	client, ns := testRedisClient(t)
+	ctx := context.Background()
+	// NewStorage 和 NewLocker 保持之前的行为, 调用方可以继续使用 err == redis.Nil
+	if _, err := NewStorage(ns, client).Get(ctx, ns+"_missing"); err != redis.Nil {
+		t.Fatalf("legacy Get missing key = %v", err)
+	}
+	if err := NewLocker(ns, client).UnLock(ctx, "missing", "v"); err != redis.Nil {
+		t.Fatalf("legacy UnLock missing lock = %v", err)
+	}
+}
+
+func TestErrNotFound(t *testing.T) {
+	if !errors.Is(ErrNotFound, redis.Nil) || !errors.Is(fmt.Errorf("wrap: %w", ErrNotFound), redis.Nil) {
+		t.Fatal("ErrNotFound should match redis.Nil")
+	}
+	if !IsNotFound(redis.Nil) || !IsNotFound(ErrNotFound) || IsNotFound(errors.New("el_tool: key not found")) {
+		t.Fatal("unexpected IsNotFound")
+	}
+}
+
+func TestMemoryLockerConformance(t *testing.T) {
+	testLockerConformance(t, NewMemoryLocker())
+}
+
+func TestRedisLockerConformance(t *testing.T) {
+	client, ns := testRedisClient(t)
+	testLockerConformance(t, NewLocker(ns, client))
+}
+
+type testValue struct {
+	Name  string
+	Count int
+}
+
+func testStorageConformance(t *testing.T, s IStorageV2) {
+	ctx := context.Background()
+	mustNil := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("GetSet", func(t *testing.T) {
+		if _, err := s.Get(ctx, "missing"); err != ErrNotFound {
+			t.Fatalf("Get missing key: %v", err)
+		}
+		mustNil(s.Set(ctx, "int", 42, 0))
+		mustNil(s.Set(ctx, "bool", true, 0))
+		mustNil(s.Set(ctx, "bytes", []byte("raw"), 0))
+		for key, want := range map[string]string{"int": "42", "bool": "1", "bytes": "raw"} {
+			if got, err := s.Get(ctx, key); err != nil || got != want {
+				t.Fatalf("Get %v = %v, %v; want %v", key, got, err, want)
+			}
+		}
+		mustNil(s.Del(ctx, "int", "bool", "bytes"))
+		if _, err := s.Get(ctx, "int"); err != ErrNotFound {
+			t.Fatalf("Get deleted key: %v", err)
+		}
+	})
+
+	t.Run("SetNX", func(t *testing.T) {
+		mustNil(s.SetNX(ctx, "nx", "first", 0))
+		mustNil(s.SetNX(ctx, "nx", "second", 0))
+		if got, _ := s.Get(ctx, "nx"); got != "first" {
+			t.Fatalf("SetNX overwrote value: %v", got)
+		}
+		mustNil(s.Del(ctx, "nx"))
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		mustNil(s.Set(ctx, "exp", "v", 50*time.Millisecond))
+		if ttl, err := s.TTL(ctx, "exp"); err != nil || ttl <= 0 || ttl > 50*time.Millisecond {
+			t.Fatalf("TTL = %v, %v", ttl, err)
+		}
+		time.Sleep(80 * time.Millisecond)
+		if _, err := s.Get(ctx, "exp"); err != ErrNotFound {
+			t.Fatalf("Get expired key: %v", err)
+		}
+		mustNil(s.SetNX(ctx, "exp", "again", 0))
+		if got, _ := s.Get(ctx, "exp"); got != "again" {
+			t.Fatalf("SetNX after expiration: %v", got)
+		}
+		if ttl, err := s.TTL(ctx, "exp"); err != nil || ttl != NoExpiration {
+			t.Fatalf("TTL without expiration = %v, %v", ttl, err)
+		}
+		mustNil(s.Expire(ctx, "exp", time.Minute))
+		if ttl, _ := s.TTL(ctx, "exp"); ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("TTL after Expire = %v", ttl)
+		}
+		mustNil(s.Del(ctx, "exp"))
+		if err := s.Expire(ctx, "exp", time.Minute); err != ErrNotFound {
+			t.Fatalf("Expire missing key: %v", err)
+		}
+		if _, err := s.TTL(ctx, "exp"); err != ErrNotFound {
+			t.Fatalf("TTL missing key: %v", err)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		mustNil(MSetAs(ctx, s, map[string]testValue{"a": {"a", 1}, "b": {"b", 2}}, time.Minute, nil))
+		vals, err := s.MGet(ctx, "a", "missing", "b")
+		mustNil(err)
+		if len(vals) != 3 || vals[0] == nil || vals[1] != nil || vals[2] == nil {
+			t.Fatalf("MGet = %v", vals)
+		}
+		typed, err := MGetAs[testValue](ctx, s, []string{"a", "missing", "b"}, nil)
+		mustNil(err)
+		if !reflect.DeepEqual(typed, map[string]testValue{"a": {"a", 1}, "b": {"b", 2}}) {
+			t.Fatalf("MGetAs = %v", typed)
+		}
+		got, err := GetAs[testValue](ctx, s, "a", GzipJsonCodec)
+		if err == nil {
+			t.Fatalf("GetAs with wrong codec should fail, got %v", got)
+		}
+		mustNil(SetAs(ctx, s, "a", testValue{"gz", 3}, time.Minute, GzipJsonCodec))
+		if got, err = GetAs[testValue](ctx, s, "a", GzipJsonCodec); err != nil || got != (testValue{"gz", 3}) {
+			t.Fatalf("GetAs = %v, %v", got, err)
+		}
+		if _, err = GetAs[testValue](ctx, s, "missing", nil); err != ErrNotFound {
+			t.Fatalf("GetAs missing key: %v", err)
+		}
+		mustNil(s.Del(ctx, "a", "b"))
+	})
+
+	t.Run("IncrBy", func(t *testing.T) {
+		if n, err := s.IncrBy(ctx, "counter", 2); err != nil || n != 2 {
+			t.Fatalf("IncrBy = %v, %v", n, err)
+		}
+		if n, err := s.IncrBy(ctx, "counter", -5); err != nil || n != -3 {
+			t.Fatalf("IncrBy = %v, %v", n, err)
+		}
+		mustNil(s.Set(ctx, "text", "abc", 0))
+		if _, err := s.IncrBy(ctx, "text", 1); err == nil {
+			t.Fatal("IncrBy on non integer should fail")
+		}
+		mustNil(s.Del(ctx, "counter", "text"))
+	})
+
+	t.Run("Hash", func(t *testing.T) {
+		mustNil(s.HSet(ctx, "hash", "f1", "v1"))
+		mustNil(s.HSet(ctx, "hash", "f2", 2))
+		if v, err := s.HGet(ctx, "hash", "f2"); err != nil || v != "2" {
+			t.Fatalf("HGet = %v, %v", v, err)
+		}
+		if _, err := s.HGet(ctx, "hash", "missing"); err != ErrNotFound {
+			t.Fatalf("HGet missing field: %v", err)
+		}
+		all, err := s.HGetAll(ctx, "hash")
+		mustNil(err)
+		if !reflect.DeepEqual(all, map[string]string{"f1": "v1", "f2": "2"}) {
+			t.Fatalf("HGetAll = %v", all)
+		}
+		if _, err = s.Get(ctx, "hash"); err == nil {
+			t.Fatal("Get on hash should fail")
+		}
+		mustNil(s.HDel(ctx, "hash", "f1", "f2"))
+		if _, err = s.TTL(ctx, "hash"); err != ErrNotFound {
+			t.Fatalf("empty hash should be deleted: %v", err)
+		}
+		if all, _ = s.HGetAll(ctx, "hash"); len(all) != 0 {
+			t.Fatalf("HGetAll missing key = %v", all)
+		}
+	})
+
+	t.Run("ZSet", func(t *testing.T) {
+		mustNil(s.ZAdd(ctx, "zset", ZMember{"c", 3}, ZMember{"a", 1}, ZMember{"b", 2}, ZMember{"bb", 2}))
+		members, err := s.ZRangeByScore(ctx, "zset", 2, 3, 0, 0)
+		mustNil(err)
+		if !reflect.DeepEqual(members, []ZMember{{"b", 2}, {"bb", 2}, {"c", 3}}) {
+			t.Fatalf("ZRangeByScore = %v", members)
+		}
+		members, err = s.ZRangeByScore(ctx, "zset", 0, 10, 1, 2)
+		mustNil(err)
+		if !reflect.DeepEqual(members, []ZMember{{"b", 2}, {"bb", 2}}) {
+			t.Fatalf("ZRangeByScore with limit = %v", members)
+		}
+		mustNil(s.ZRem(ctx, "zset", "a", "b", "bb", "c"))
+		if members, _ = s.ZRangeByScore(ctx, "zset", 0, 10, 0, 0); len(members) != 0 {
+			t.Fatalf("ZRangeByScore after ZRem = %v", members)
+		}
+	})
+}
+
+func testLockerConformance(t *testing.T, l ILocker) {
+	ctx := context.Background()
+
+	t.Run("TryLock", func(t *testing.T) {
+		unlock := l.TryLock(ctx, "try")
+		if unlock == nil {
+			t.Fatal("TryLock free key failed")
+		}
+		if l.TryLock(ctx, "try") != nil {
+			t.Fatal("TryLock held key succeeded")
+		}
+		unlock()
+		if unlock = l.TryLock(ctx, "try"); unlock == nil {
+			t.Fatal("TryLock after unlock failed")
+		}
+		unlock()
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		if l.TryLockWithDuration(ctx, "exp", 50*time.Millisecond) == nil {
+			t.Fatal("TryLockWithDuration failed")
+		}
+		time.Sleep(80 * time.Millisecond)
+		unlock := l.TryLockWithDuration(ctx, "exp", time.Minute)
+		if unlock == nil {
+			t.Fatal("lock should be released after expiration")
+		}
+		unlock()
+	})
+
+	t.Run("Value", func(t *testing.T) {
+		if !l.TryLockWithValAndDuration(ctx, "val", "owner", time.Minute) {
+			t.Fatal("TryLockWithValAndDuration failed")
+		}
+		if l.TryLockWithValAndDuration(ctx, "val", "owner", time.Minute) {
+			t.Fatal("TryLockWithValAndDuration is not reentrant")
+		}
+		// 值不同的解锁不生效
+		if err := l.UnLock(ctx, "val", "other"); err != nil {
+			t.Fatal(err)
+		}
+		if l.TryLockWithValAndDuration(ctx, "val", "other", time.Minute) {
+			t.Fatal("UnLock with other value released the lock")
+		}
+		if err := l.UnLock(ctx, "val", "owner"); err != nil {
+			t.Fatal(err)
+		}
+		if !l.TryLockWithValAndDuration(ctx, "val", "other", time.Minute) {
+			t.Fatal("lock should be free after UnLock")
+		}
+		_ = l.UnLock(ctx, "val", "other")
+		// 锁不存在时返回 not found
+		if err := l.UnLock(ctx, "val", "other"); !IsNotFound(err) {
+			t.Fatalf("UnLock missing lock = %v", err)
+		}
+	})
+
+	renewer, ok := l.(IRenewableLocker)
+	if !ok {
+		return
+	}
+	t.Run("Renew", func(t *testing.T) {
+		if !renewer.TryLockWithValAndDuration(ctx, "renew", "owner", time.Minute) {
+			t.Fatal("TryLockWithValAndDuration failed")
+		}
+		if ok, err := renewer.Renew(ctx, "renew", "other", time.Minute); err != nil || ok {
+			t.Fatalf("Renew with other value = %v, %v", ok, err)
+		}
+		if ok, err := renewer.Renew(ctx, "renew", "owner", 50*time.Millisecond); err != nil || !ok {
+			t.Fatalf("Renew = %v, %v", ok, err)
+		}
+		time.Sleep(80 * time.Millisecond)
+		if ok, _ := renewer.Renew(ctx, "renew", "owner", time.Minute); ok {
+			t.Fatal("Renew expired lock succeeded")
+		}
+		if !renewer.TryLockWithValAndDuration(ctx, "renew", "other", time.Minute) {
+			t.Fatal("renewed duration should be applied")
+		}
+		_ = renewer.UnLock(ctx, "renew", "other")
+	})
+}
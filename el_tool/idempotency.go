@@ -0,0 +1,213 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drip-in/eden_lib/errcode"
+	"github.com/drip-in/eden_lib/logs"
+)
+
+// ErrIdempotencyInProgress 相同key的请求正在执行, 调用方应当稍后重试
+var ErrIdempotencyInProgress = errors.New("el_tool: idempotent request in progress")
+
+const (
+	defaultIdempotencyResultTTL = 24 * time.Hour
+	defaultIdempotencyLockTTL   = EXPIRED_TIME
+)
+
+// Idempotency 请求去重: 相同key的请求最多执行一次, 结果(或错误)保存在 IStorage 中,
+// 之后的重复请求直接返回保存的结果; 执行期间的重复请求返回 ErrIdempotencyInProgress
+type Idempotency struct {
+	locker    ILocker
+	storage   IStorage
+	codec     Codec
+	resultTTL time.Duration
+	lockTTL   time.Duration
+	// cacheErr 返回false的错误不保存, 相同key可以重新执行
+	cacheErr func(err error) bool
+}
+
+// idempotencyRecord 保存在storage中的执行结果
+type idempotencyRecord struct {
+	Result  []byte `json:"result,omitempty"`
+	Err     string `json:"err,omitempty"`
+	ErrCode *int32 `json:"err_code,omitempty"`
+}
+
+// NewIdempotency 结果默认保存24小时, 使用 JsonCodec 序列化
+func NewIdempotency(locker ILocker, storage IStorage) *Idempotency {
+	if locker == nil || storage == nil {
+		panic("invalid idempotency config: locker and storage are required")
+	}
+	return &Idempotency{
+		locker:    locker,
+		storage:   storage,
+		codec:     JsonCodec,
+		resultTTL: defaultIdempotencyResultTTL,
+		lockTTL:   defaultIdempotencyLockTTL,
+		cacheErr:  isErrCode,
+	}
+}
+
+// WithResultTTL 设置结果的保存时长
+func (p *Idempotency) WithResultTTL(ttl time.Duration) *Idempotency {
+	if ttl > 0 {
+		p.resultTTL = ttl
+	}
+	return p
+}
+
+// WithLockTTL 设置执行期间锁的过期时间, locker 实现了 IRenewableLocker 时执行期间每 ttl/3 续期一次
+func (p *Idempotency) WithLockTTL(ttl time.Duration) *Idempotency {
+	if ttl > 0 {
+		p.lockTTL = ttl
+	}
+	return p
+}
+
+// WithCodec 设置结果的序列化方式
+func (p *Idempotency) WithCodec(codec Codec) *Idempotency {
+	if codec != nil {
+		p.codec = codec
+	}
+	return p
+}
+
+// WithErrorFilter 只保存fn返回true的错误, 例如超时等临时错误不保存, 允许重试;
+// 默认只保存实现了 errcode.ErrCode 的业务错误
+func (p *Idempotency) WithErrorFilter(fn func(err error) bool) *Idempotency {
+	if fn != nil {
+		p.cacheErr = fn
+	}
+	return p
+}
+
+// isErrCode 业务错误重复执行的结果也相同, 其它错误可能是临时的
+func isErrCode(err error) bool {
+	var coded errcode.ErrCode
+	return errors.As(err, &coded)
+}
+
+func (p *Idempotency) lockKey(key string) string {
+	return fmt.Sprintf("IDEMPOTENCY_%v", key)
+}
+
+func (p *Idempotency) resultKey(key string) string {
+	return fmt.Sprintf("IDEMPOTENCY_RESULT_%v", key)
+}
+
+// Forget 删除key保存的结果, 之后相同key的请求会重新执行
+func (p *Idempotency) Forget(ctx context.Context, key string) error {
+	return p.storage.Del(ctx, p.resultKey(key))
+}
+
+// Execute 使用 p 对key去重执行fn. 已经执行过时返回保存的值和错误(fn返回错误时的值也会保存),
+// 保存的错误实现了 errcode.ErrCode 时以相同code的 errcode.InnerErrCode 返回; 相同key正在执行时返回 ErrIdempotencyInProgress
+func Execute[T any](ctx context.Context, p *Idempotency, key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	if key == "" {
+		panic("empty key")
+	}
+	if record, err := p.load(ctx, key); err != nil || record != nil {
+		if err != nil {
+			return zero, err
+		}
+		return replayRecord[T](p, record)
+	}
+
+	token := genLockToken()
+	lockKey := p.lockKey(key)
+	if !p.locker.TryLockWithValAndDuration(ctx, lockKey, token, p.lockTTL) {
+		// 持有者可能刚刚执行完
+		if record, err := p.load(ctx, key); err == nil && record != nil {
+			return replayRecord[T](p, record)
+		}
+		return zero, ErrIdempotencyInProgress
+	}
+	defer p.locker.UnLock(context.Background(), lockKey, token)
+
+	// 加锁前上一个持有者可能已经保存了结果
+	if record, err := p.load(ctx, key); err != nil || record != nil {
+		if err != nil {
+			return zero, err
+		}
+		return replayRecord[T](p, record)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go p.keepLock(lockKey, token, stop)
+
+	val, fnErr := fn(ctx)
+	if fnErr != nil && !p.cacheErr(fnErr) {
+		return val, fnErr
+	}
+	if err := p.save(ctx, key, val, fnErr); err != nil {
+		// 结果没有保存, 重复的请求会再次执行
+		logs.CtxError(ctx, "[Idempotency] save result", logs.String("key", key), logs.String("err", err.Error()))
+	}
+	return val, fnErr
+}
+
+func (p *Idempotency) load(ctx context.Context, key string) (*idempotencyRecord, error) {
+	record, err := GetAs[idempotencyRecord](ctx, p.storage, p.resultKey(key), JsonCodec)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (p *Idempotency) save(ctx context.Context, key string, val interface{}, fnErr error) error {
+	data, err := p.codec.Marshal(val)
+	if err != nil {
+		return err
+	}
+	record := idempotencyRecord{Result: data}
+	if fnErr != nil {
+		record.Err = fnErr.Error()
+		var coded errcode.ErrCode
+		if errors.As(fnErr, &coded) {
+			code := coded.Code()
+			record.Err, record.ErrCode = coded.Msg(), &code
+		}
+	}
+	return SetAs(ctx, p.storage, p.resultKey(key), record, p.resultTTL, JsonCodec)
+}
+
+func replayRecord[T any](p *Idempotency, record *idempotencyRecord) (T, error) {
+	var val T
+	// 旧版本保存错误时没有保存值
+	if len(record.Result) > 0 {
+		if err := p.codec.Unmarshal(record.Result, &val); err != nil {
+			return val, err
+		}
+	}
+	if record.ErrCode != nil {
+		return val, errcode.NewInnerErrCode(*record.ErrCode, record.Err)
+	}
+	if record.Err != "" {
+		return val, errors.New(record.Err)
+	}
+	return val, nil
+}
+
+// keepLock 执行期间续期, 防止fn执行时间超过锁的过期时间; 锁丢失时 watchLease 会打印日志
+func (p *Idempotency) keepLock(lockKey, token string, stop <-chan struct{}) {
+	renewer, ok := p.locker.(IRenewableLocker)
+	if !ok {
+		return
+	}
+	watchLease(lockKey, p.lockTTL, func(ctx context.Context) error {
+		ok, err := renewer.Renew(ctx, lockKey, token, p.lockTTL)
+		if err == nil && !ok {
+			err = ErrNotLocked
+		}
+		return err
+	}, stop, make(chan struct{}))
+}
@@ -0,0 +1,167 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/drip-in/eden_lib/errcode"
+)
+
+type payResult struct {
+	OrderId string
+	Amount  int
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	ctx := context.Background()
+	idem := NewIdempotency(NewMemoryLocker(), NewMemoryStorage())
+	var calls int32
+	pay := func(ctx context.Context) (payResult, error) {
+		atomic.AddInt32(&calls, 1)
+		return payResult{OrderId: "o1", Amount: 100}, nil
+	}
+	for i := 0; i < 3; i++ {
+		res, err := Execute(ctx, idem, "req-1", pay)
+		if err != nil || res != (payResult{"o1", 100}) {
+			t.Fatalf("Execute = %v, %v", res, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %v times, want 1", calls)
+	}
+
+	if err := idem.Forget(ctx, "req-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Execute(ctx, idem, "req-1", pay); err != nil || calls != 2 {
+		t.Fatalf("Execute after Forget = %v, calls %v", err, calls)
+	}
+}
+
+func TestIdempotencyError(t *testing.T) {
+	ctx := context.Background()
+	errTemporary := errors.New("db timeout")
+	idem := NewIdempotency(NewMemoryLocker(), NewMemoryStorage()).
+		WithErrorFilter(func(err error) bool { return err != errTemporary })
+
+	var calls int32
+	_, err := Execute(ctx, idem, "req-2", func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, errcode.ErrInvalidParam
+	})
+	if err != errcode.ErrInvalidParam {
+		t.Fatalf("Execute = %v", err)
+	}
+	_, err = Execute(ctx, idem, "req-2", func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 1, nil
+	})
+	var coded errcode.ErrCode
+	if !errors.As(err, &coded) || coded.Code() != errcode.ErrInvalidParam.Code() || calls != 1 {
+		t.Fatalf("replayed error = %v, calls %v", err, calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = Execute(ctx, idem, "req-3", func(ctx context.Context) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return 0, errTemporary
+		}); err != errTemporary {
+			t.Fatalf("Execute = %v", err)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("filtered error should not be cached, calls %v", calls)
+	}
+}
+
+func TestIdempotencyDefaultErrorFilter(t *testing.T) {
+	ctx := context.Background()
+	idem := NewIdempotency(NewMemoryLocker(), NewMemoryStorage())
+
+	// 默认不保存非业务错误
+	var calls int32
+	for i := 0; i < 2; i++ {
+		if _, err := Execute(ctx, idem, "req-5", func(ctx context.Context) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return 0, errors.New("db timeout")
+		}); err == nil {
+			t.Fatal("Execute should return the error")
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("temporary error should not be cached, calls %v", calls)
+	}
+
+	// 重复请求返回的值与第一次相同
+	for i := 0; i < 2; i++ {
+		res, err := Execute(ctx, idem, "req-6", func(ctx context.Context) (payResult, error) {
+			return payResult{OrderId: "o2"}, errcode.ErrLocked
+		})
+		var coded errcode.ErrCode
+		if res.OrderId != "o2" || !errors.As(err, &coded) || coded.Code() != errcode.ErrLocked.Code() {
+			t.Fatalf("Execute #%v = %v, %v", i, res, err)
+		}
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	ctx := context.Background()
+	idem := NewIdempotency(NewMemoryLocker(), NewMemoryStorage()).WithLockTTL(30 * time.Millisecond)
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := Execute(ctx, idem, "req-4", func(ctx context.Context) (string, error) {
+			close(started)
+			<-release
+			return "ok", nil
+		})
+		done <- err
+	}()
+	<-started
+	// 执行时间超过锁的过期时间, 续期保证重复请求仍然被拒绝
+	time.Sleep(60 * time.Millisecond)
+	if _, err := Execute(ctx, idem, "req-4", func(ctx context.Context) (string, error) {
+		return "duplicate", nil
+	}); err != ErrIdempotencyInProgress {
+		t.Fatalf("duplicate during execution = %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if res, err := Execute(ctx, idem, "req-4", func(ctx context.Context) (string, error) {
+		return "duplicate", nil
+	}); err != nil || res != "ok" {
+		t.Fatalf("Execute after completion = %v, %v", res, err)
+	}
+}
+
+func TestIdempotencyKeepLock(t *testing.T) {
+	ctx := context.Background()
+	idem := NewIdempotency(NewMemoryLocker(), NewMemoryStorage()).WithLockTTL(60 * time.Millisecond)
+	started, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = Execute(ctx, idem, "slow", func(ctx context.Context) (int, error) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			return 1, nil
+		})
+	}()
+	<-started
+	// 执行时间超过 lockTTL, 锁被续期, 重复的请求仍然在等待
+	time.Sleep(150 * time.Millisecond)
+	if _, err := Execute(ctx, idem, "slow", func(ctx context.Context) (int, error) {
+		t.Fatal("duplicate request executed while the first one is running")
+		return 0, nil
+	}); err != ErrIdempotencyInProgress {
+		t.Fatalf("Execute while running = %v", err)
+	}
+	<-done
+	if res, err := Execute(ctx, idem, "slow", func(ctx context.Context) (int, error) { return 2, nil }); err != nil || res != 1 {
+		t.Fatalf("Execute after done = %v, %v", res, err)
+	}
+}
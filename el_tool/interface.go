@@ -6,8 +6,9 @@ import (
 )
 
 var (
-	LockerImpl  ILocker
-	StorageImpl IStorage
+	LockerImpl    ILocker
+	StorageImpl   IStorage
+	StorageV2Impl IStorageV2
 )
 
 func InitLockerImpl(lock ILocker) {
@@ -18,6 +19,10 @@ func InitStorageImpl(s IStorage) {
 	StorageImpl = s
 }
 
+func InitStorageV2Impl(s IStorageV2) {
+	StorageV2Impl = s
+}
+
 type ILocker interface {
 	TryLock(ctx context.Context, key string) (unLockFunc func())
 	TryLockWithDuration(ctx context.Context, key string, duration time.Duration) (unLockFunc func())
@@ -25,9 +30,48 @@ type ILocker interface {
 	UnLock(ctx context.Context, key string, value string) error
 }
 
+// IRenewableLocker 支持续租的 ILocker, 使用方通过类型断言判断是否支持
+type IRenewableLocker interface {
+	ILocker
+	// Renew 锁的值仍为value时延长过期时间, 用于续租
+	Renew(ctx context.Context, key string, value string, duration time.Duration) (bool, error)
+}
+
 type IStorage interface {
 	Set(ctx context.Context, key string, val interface{}, expiration time.Duration) error
 	SetNX(ctx context.Context, key string, val interface{}, expiration time.Duration) error
+	// Get key不存在时返回 ErrNotFound, NewStorage 创建的实例返回 redis.Nil; 使用 IsNotFound 判断
 	Get(ctx context.Context, key string) (interface{}, error)
 	Del(ctx context.Context, keys ...string) error
 }
+
+// IStorageV2 在 IStorage 的基础上增加批量、计数、过期时间和hash/zset操作; 读取结构化的值使用 GetAs/SetAs
+type IStorageV2 interface {
+	IStorage
+	// MGet 按keys的顺序返回值, 不存在的key对应nil
+	MGet(ctx context.Context, keys ...string) ([]interface{}, error)
+	// MSet 在一个pipeline中写入所有kv, 使用相同的过期时间
+	MSet(ctx context.Context, kvs map[string]interface{}, expiration time.Duration) error
+	IncrBy(ctx context.Context, key string, delta int64) (int64, error)
+	// Expire key不存在时返回 ErrNotFound
+	Expire(ctx context.Context, key string, expiration time.Duration) error
+	// TTL 返回剩余的过期时间, 没有过期时间时返回 NoExpiration, key不存在时返回 ErrNotFound
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	HSet(ctx context.Context, key string, field string, val interface{}) error
+	// HGet key或field不存在时返回 ErrNotFound
+	HGet(ctx context.Context, key string, field string) (string, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	HDel(ctx context.Context, key string, fields ...string) error
+
+	ZAdd(ctx context.Context, key string, members ...ZMember) error
+	ZRem(ctx context.Context, key string, members ...string) error
+	// ZRangeByScore 按score升序返回 [min, max] 之间的成员, count<=0 表示不限制数量
+	ZRangeByScore(ctx context.Context, key string, min, max float64, offset, count int64) ([]ZMember, error)
+}
+
+// ZMember 有序集合的成员
+type ZMember struct {
+	Member string
+	Score  float64
+}
@@ -0,0 +1,112 @@
+package el_tool
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+)
+
+// MemoryLocker 进程内的 ILocker, 锁的值、过期和解锁语义与 Locker 相同, 用于测试和本地运行
+type MemoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]memoryLock
+	now   func() time.Time
+}
+
+type memoryLock struct {
+	value    string
+	expireAt time.Time
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		locks: make(map[string]memoryLock),
+		now:   time.Now,
+	}
+}
+
+// holder 返回未过期的锁的值; 调用时需要持有 p.mu
+func (p *MemoryLocker) holder(key string) (string, bool) {
+	l, ok := p.locks[key]
+	if !ok {
+		return "", false
+	}
+	if !l.expireAt.IsZero() && !p.now().Before(l.expireAt) {
+		delete(p.locks, key)
+		return "", false
+	}
+	return l.value, true
+}
+
+func (p *MemoryLocker) TryLock(ctx context.Context, key string) (unLockFunc func()) {
+	return p.tryLock(ctx, key, EXPIRED_TIME)
+}
+
+func (p *MemoryLocker) TryLockWithDuration(ctx context.Context, key string, duration time.Duration) (unLockFunc func()) {
+	return p.tryLock(ctx, key, duration)
+}
+
+func (p *MemoryLocker) TryLockWithValAndDuration(ctx context.Context, key string, value string, duration time.Duration) bool {
+	if key == "" {
+		panic("empty key")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.holder(key); ok {
+		return false
+	}
+	l := memoryLock{value: value}
+	if duration > 0 {
+		l.expireAt = p.now().Add(duration)
+	}
+	p.locks[key] = l
+	return true
+}
+
+func (p *MemoryLocker) tryLock(ctx context.Context, key string, duration time.Duration) (unLockFunc func()) {
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if !p.TryLockWithValAndDuration(ctx, key, value, duration) {
+		return nil
+	}
+	return func() {
+		p.UnLock(ctx, key, value)
+	}
+}
+
+// UnLock 锁不存在(已经过期)时返回 ErrNotFound, 与 Locker 返回的 redis.Nil 都可以用 IsNotFound 判断
+func (p *MemoryLocker) UnLock(ctx context.Context, key string, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current, ok := p.holder(key)
+	if !ok {
+		return ErrNotFound
+	}
+	if current == value {
+		delete(p.locks, key)
+		return nil
+	}
+	logs.CtxError(ctx, "memory unlock fail, value not equal", logs.String("key", key))
+	return nil
+}
+
+func (p *MemoryLocker) Renew(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if current, ok := p.holder(key); !ok || current != value {
+		return false, nil
+	}
+	l := memoryLock{value: value}
+	if duration > 0 {
+		l.expireAt = p.now().Add(duration)
+	} else {
+		// 与redis相同, 过期时间<=0 时直接删除
+		delete(p.locks, key)
+		return true, nil
+	}
+	p.locks[key] = l
+	return true, nil
+}
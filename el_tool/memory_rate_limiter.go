@@ -0,0 +1,159 @@
+package el_tool
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// memoryRateLimiterSweepEvery 每处理这么多次请求清理一次过期的key
+const memoryRateLimiterSweepEvery = 1024
+
+// MemoryRateLimiter 进程内的 IRateLimiter, 与 RedisRateLimiter 的算法和结果相同, 用于单机限流和测试
+type MemoryRateLimiter struct {
+	algorithm RateLimitAlgorithm
+	mu        sync.Mutex
+	states    map[string]*memoryLimitState
+	calls     int
+	now       func() time.Time
+}
+
+// memoryLimitState 一个key的限流状态, 不同算法使用不同的字段, 时间单位都是毫秒
+type memoryLimitState struct {
+	// 固定窗口: 当前窗口的起始时间和计数
+	window int64
+	count  int
+	// 滑动窗口: 窗口内每次通过的时间, 升序
+	log []int64
+	// 令牌桶: 剩余令牌和上次补充的时间; GCRA: 理论到达时间保存在tat
+	tokens float64
+	last   float64
+	tat    float64
+	// expireAt 之后状态等同于初始状态, 可以清理
+	expireAt int64
+}
+
+func NewMemoryRateLimiter(algorithm RateLimitAlgorithm) *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		algorithm: algorithm,
+		states:    make(map[string]*memoryLimitState),
+		now:       time.Now,
+	}
+}
+
+func (m *MemoryRateLimiter) Allow(ctx context.Context, key string, limit Limit) (*RateLimitResult, error) {
+	return m.AllowN(ctx, key, limit, 1)
+}
+
+func (m *MemoryRateLimiter) AllowN(ctx context.Context, key string, limit Limit, n int) (*RateLimitResult, error) {
+	if err := limit.validate(m.algorithm, n); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := unixMilli(m.now())
+	m.calls++
+	if m.calls%memoryRateLimiterSweepEvery == 0 {
+		m.sweep(now)
+	}
+	state, ok := m.states[key]
+	if !ok || state.expireAt <= now {
+		state = &memoryLimitState{}
+		m.states[key] = state
+	}
+
+	switch m.algorithm {
+	case SlidingWindowLog:
+		return state.slidingWindowLog(limit, n, now), nil
+	case TokenBucket:
+		return state.tokenBucket(limit, n, now), nil
+	case GCRA:
+		return state.gcra(limit, n, now), nil
+	default:
+		return state.fixedWindow(limit, n, now), nil
+	}
+}
+
+func (m *MemoryRateLimiter) sweep(now int64) {
+	for key, state := range m.states {
+		if state.expireAt <= now {
+			delete(m.states, key)
+		}
+	}
+}
+
+func (s *memoryLimitState) fixedWindow(limit Limit, n int, now int64) *RateLimitResult {
+	period := limit.periodMs()
+	window := now - now%period
+	if s.window != window {
+		s.window, s.count = window, 0
+	}
+	s.expireAt = window + period
+	if s.count+n > limit.Rate {
+		return &RateLimitResult{Remaining: limit.Rate - s.count, RetryAfter: msToDuration(float64(window + period - now))}
+	}
+	s.count += n
+	return &RateLimitResult{Allowed: true, Remaining: limit.Rate - s.count}
+}
+
+func (s *memoryLimitState) slidingWindowLog(limit Limit, n int, now int64) *RateLimitResult {
+	period := limit.periodMs()
+	expired := 0
+	for expired < len(s.log) && s.log[expired] <= now-period {
+		expired++
+	}
+	s.log = s.log[expired:]
+	if len(s.log)+n > limit.Rate {
+		// 需要等到第 len+n-Rate 个请求滑出窗口
+		oldest := s.log[len(s.log)+n-limit.Rate-1]
+		s.expireAt = s.log[len(s.log)-1] + period
+		return &RateLimitResult{Remaining: limit.Rate - len(s.log), RetryAfter: msToDuration(float64(oldest + period - now))}
+	}
+	for i := 0; i < n; i++ {
+		s.log = append(s.log, now)
+	}
+	s.expireAt = now + period
+	return &RateLimitResult{Allowed: true, Remaining: limit.Rate - len(s.log)}
+}
+
+func (s *memoryLimitState) tokenBucket(limit Limit, n int, now int64) *RateLimitResult {
+	capacity := float64(limit.burst(TokenBucket))
+	perMs := float64(limit.Rate) / float64(limit.periodMs())
+	if s.expireAt == 0 {
+		s.tokens, s.last = capacity, float64(now)
+	}
+	s.tokens = math.Min(capacity, s.tokens+(float64(now)-s.last)*perMs)
+	s.last = float64(now)
+
+	res := &RateLimitResult{}
+	if s.tokens >= float64(n) {
+		s.tokens -= float64(n)
+		res.Allowed = true
+	} else {
+		res.RetryAfter = msToDuration((float64(n) - s.tokens) / perMs)
+	}
+	res.Remaining = int(s.tokens)
+	s.expireAt = now + int64(math.Ceil((capacity-s.tokens)/perMs)) + 1
+	return res
+}
+
+func (s *memoryLimitState) gcra(limit Limit, n int, now int64) *RateLimitResult {
+	interval := float64(limit.periodMs()) / float64(limit.Rate)
+	burstOffset := interval * float64(limit.burst(GCRA))
+	tat := math.Max(s.tat, float64(now))
+
+	newTat := tat + float64(n)*interval
+	diff := float64(now) - (newTat - burstOffset)
+	if diff < 0 {
+		return &RateLimitResult{
+			Remaining:  int(math.Max(0, (float64(now)-(tat-burstOffset))/interval)),
+			RetryAfter: msToDuration(-diff),
+		}
+	}
+	s.tat = newTat
+	s.expireAt = int64(math.Ceil(newTat))
+	return &RateLimitResult{Allowed: true, Remaining: int(diff / interval)}
+}
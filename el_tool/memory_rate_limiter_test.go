@@ -0,0 +1,82 @@
+package el_tool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestRateLimiter(algorithm RateLimitAlgorithm) (*MemoryRateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := NewMemoryRateLimiter(algorithm)
+	l.now = clock.Now
+	return l, clock
+}
+
+func allowTimes(t *testing.T, l IRateLimiter, limit Limit, times int) (allowed int, last *RateLimitResult) {
+	for i := 0; i < times; i++ {
+		res, err := l.Allow(context.Background(), "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Allowed {
+			allowed++
+		}
+		last = res
+	}
+	return allowed, last
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	limit := Limit{Rate: 5, Period: time.Second}
+	for _, algorithm := range []RateLimitAlgorithm{FixedWindow, SlidingWindowLog, TokenBucket, GCRA} {
+		l, clock := newTestRateLimiter(algorithm)
+		allowed, last := allowTimes(t, l, limit, 8)
+		if allowed != 5 {
+			t.Fatalf("algorithm %v: allowed %v, want 5", algorithm, allowed)
+		}
+		if last.Allowed || last.Remaining != 0 || last.RetryAfter <= 0 || last.RetryAfter > time.Second {
+			t.Fatalf("algorithm %v: unexpected rejected result %+v", algorithm, last)
+		}
+		if last.Err() == nil {
+			t.Fatalf("algorithm %v: rejected result should return error", algorithm)
+		}
+
+		clock.Advance(last.RetryAfter)
+		if res, _ := l.Allow(context.Background(), "k", limit); !res.Allowed {
+			t.Fatalf("algorithm %v: should be allowed after %v, got %+v", algorithm, last.RetryAfter, res)
+		}
+		if res, _ := l.Allow(context.Background(), "other", limit); !res.Allowed || res.Remaining != 4 {
+			t.Fatalf("algorithm %v: keys should be independent, got %+v", algorithm, res)
+		}
+	}
+}
+
+func TestMemoryRateLimiterBurst(t *testing.T) {
+	limit := Limit{Rate: 1, Period: 100 * time.Millisecond, Burst: 3}
+	for _, algorithm := range []RateLimitAlgorithm{TokenBucket, GCRA} {
+		l, clock := newTestRateLimiter(algorithm)
+		if allowed, _ := allowTimes(t, l, limit, 5); allowed != 3 {
+			t.Fatalf("algorithm %v: allowed %v, want burst 3", algorithm, allowed)
+		}
+		clock.Advance(100 * time.Millisecond)
+		if allowed, _ := allowTimes(t, l, limit, 3); allowed != 1 {
+			t.Fatalf("algorithm %v: allowed %v after one interval, want 1", algorithm, allowed)
+		}
+		if _, err := l.AllowN(context.Background(), "k", limit, 4); err != ErrInvalidRateLimit {
+			t.Fatalf("algorithm %v: n over burst should be invalid, got %v", algorithm, err)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package el_tool
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryStorageSweepEvery 每写入这么多次清理一次过期的key
+const memoryStorageSweepEvery = 1024
+
+var (
+	// errWrongType 与redis的 WRONGTYPE 错误相同: 对key执行了与其类型不符的操作
+	errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	// errNotInteger 与redis相同: 对非整数的值执行 IncrBy
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+)
+
+type memoryKind int
+
+const (
+	memoryString memoryKind = iota
+	memoryHash
+	memoryZSet
+)
+
+type memoryEntry struct {
+	kind memoryKind
+	str  string
+	hash map[string]string
+	zset map[string]float64
+	// expireAt 为零值表示不过期
+	expireAt time.Time
+}
+
+// MemoryStorage 进程内的 IStorageV2, 值的格式、过期和 SetNX 语义与 Storage 相同, 用于测试和本地运行
+type MemoryStorage struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	writes  int
+	now     func() time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		entries: make(map[string]*memoryEntry),
+		now:     time.Now,
+	}
+}
+
+// lookup 返回未过期的key, 过期的key会被删除; 调用时需要持有 s.mu
+func (s *MemoryStorage) lookup(key string) *memoryEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !s.now().Before(e.expireAt) {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
+
+// lookupOrCreate 返回kind类型的key, 不存在时创建; 调用时需要持有 s.mu
+func (s *MemoryStorage) lookupOrCreate(key string, kind memoryKind) (*memoryEntry, error) {
+	s.onWrite()
+	e := s.lookup(key)
+	if e == nil {
+		e = &memoryEntry{kind: kind}
+		switch kind {
+		case memoryHash:
+			e.hash = make(map[string]string)
+		case memoryZSet:
+			e.zset = make(map[string]float64)
+		}
+		s.entries[key] = e
+		return e, nil
+	}
+	if e.kind != kind {
+		return nil, errWrongType
+	}
+	return e, nil
+}
+
+// onWrite 每写入 memoryStorageSweepEvery 次清理一次过期的key, 所有写入的路径都需要调用; 调用时需要持有 s.mu
+func (s *MemoryStorage) onWrite() {
+	s.writes++
+	if s.writes%memoryStorageSweepEvery == 0 {
+		s.sweep()
+	}
+}
+
+func (s *MemoryStorage) sweep() {
+	now := s.now()
+	for key, e := range s.entries {
+		if !e.expireAt.IsZero() && !now.Before(e.expireAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *MemoryStorage) expireAt(expiration time.Duration) time.Time {
+	if expiration <= 0 {
+		return time.Time{}
+	}
+	return s.now().Add(expiration)
+}
+
+func (s *MemoryStorage) Set(ctx context.Context, key string, val interface{}, expiration time.Duration) error {
+	str, err := formatStorageValue(val)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onWrite()
+	s.entries[key] = &memoryEntry{kind: memoryString, str: str, expireAt: s.expireAt(expiration)}
+	return nil
+}
+
+func (s *MemoryStorage) SetNX(ctx context.Context, key string, val interface{}, expiration time.Duration) error {
+	str, err := formatStorageValue(val)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lookup(key) != nil {
+		return nil
+	}
+	s.onWrite()
+	s.entries[key] = &memoryEntry{kind: memoryString, str: str, expireAt: s.expireAt(expiration)}
+	return nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, key string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil {
+		return nil, ErrNotFound
+	}
+	if e.kind != memoryString {
+		return nil, errWrongType
+	}
+	return e.str, nil
+}
+
+func (s *MemoryStorage) Del(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vals := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if e := s.lookup(key); e != nil && e.kind == memoryString {
+			vals[i] = e.str
+		}
+	}
+	return vals, nil
+}
+
+func (s *MemoryStorage) MSet(ctx context.Context, kvs map[string]interface{}, expiration time.Duration) error {
+	strs := make(map[string]string, len(kvs))
+	for key, val := range kvs {
+		str, err := formatStorageValue(val)
+		if err != nil {
+			return err
+		}
+		strs[key] = str
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, str := range strs {
+		s.onWrite()
+		s.entries[key] = &memoryEntry{kind: memoryString, str: str, expireAt: s.expireAt(expiration)}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.lookupOrCreate(key, memoryString)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if e.str != "" {
+		if n, err = strconv.ParseInt(e.str, 10, 64); err != nil {
+			return 0, errNotInteger
+		}
+	}
+	n += delta
+	e.str = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func (s *MemoryStorage) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil {
+		return ErrNotFound
+	}
+	// 与redis相同, 过期时间<=0 时直接删除
+	if expiration <= 0 {
+		delete(s.entries, key)
+		return nil
+	}
+	e.expireAt = s.now().Add(expiration)
+	return nil
+}
+
+func (s *MemoryStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil {
+		return 0, ErrNotFound
+	}
+	if e.expireAt.IsZero() {
+		return NoExpiration, nil
+	}
+	return e.expireAt.Sub(s.now()).Truncate(time.Millisecond), nil
+}
+
+func (s *MemoryStorage) HSet(ctx context.Context, key string, field string, val interface{}) error {
+	str, err := formatStorageValue(val)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.lookupOrCreate(key, memoryHash)
+	if err != nil {
+		return err
+	}
+	e.hash[field] = str
+	return nil
+}
+
+func (s *MemoryStorage) HGet(ctx context.Context, key string, field string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil {
+		return "", ErrNotFound
+	}
+	if e.kind != memoryHash {
+		return "", errWrongType
+	}
+	val, ok := e.hash[field]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return val, nil
+}
+
+func (s *MemoryStorage) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]string)
+	e := s.lookup(key)
+	if e == nil {
+		return res, nil
+	}
+	if e.kind != memoryHash {
+		return nil, errWrongType
+	}
+	for field, val := range e.hash {
+		res[field] = val
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) HDel(ctx context.Context, key string, fields ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil {
+		return nil
+	}
+	if e.kind != memoryHash {
+		return errWrongType
+	}
+	for _, field := range fields {
+		delete(e.hash, field)
+	}
+	// 与redis相同, 空的hash会被删除
+	if len(e.hash) == 0 {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ZAdd(ctx context.Context, key string, members ...ZMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.lookupOrCreate(key, memoryZSet)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		e.zset[m.Member] = m.Score
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ZRem(ctx context.Context, key string, members ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil {
+		return nil
+	}
+	if e.kind != memoryZSet {
+		return errWrongType
+	}
+	for _, m := range members {
+		delete(e.zset, m)
+	}
+	if len(e.zset) == 0 {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ZRangeByScore(ctx context.Context, key string, min, max float64, offset, count int64) ([]ZMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil {
+		return []ZMember{}, nil
+	}
+	if e.kind != memoryZSet {
+		return nil, errWrongType
+	}
+
+	members := make([]ZMember, 0, len(e.zset))
+	for m, score := range e.zset {
+		if score >= min && score <= max {
+			members = append(members, ZMember{Member: m, Score: score})
+		}
+	}
+	// 与redis相同, score 相同时按成员的字典序排序
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member < members[j].Member
+	})
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= int64(len(members)) {
+		return []ZMember{}, nil
+	}
+	members = members[offset:]
+	if count > 0 && count < int64(len(members)) {
+		members = members[:count]
+	}
+	return members, nil
+}
+
+// formatStorageValue 与go-redis写入参数的格式相同, 保证内存实现读出的值与redis一致
+func formatStorageValue(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", v)
+	}
+}
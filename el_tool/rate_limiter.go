@@ -0,0 +1,102 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/drip-in/eden_lib/errcode"
+)
+
+var RateLimiterImpl IRateLimiter
+
+func InitRateLimiterImpl(l IRateLimiter) {
+	RateLimiterImpl = l
+}
+
+// ErrInvalidRateLimit Limit 不合法, 或者一次请求的数量超过了允许的突发数, 永远不可能通过
+var ErrInvalidRateLimit = errors.New("el_tool: invalid rate limit")
+
+// RateLimitAlgorithm 限流算法
+type RateLimitAlgorithm int
+
+const (
+	// FixedWindow 固定窗口计数, 实现最简单, 窗口边界处最多可以通过2倍的Rate
+	FixedWindow RateLimitAlgorithm = iota
+	// SlidingWindowLog 滑动窗口日志, 记录窗口内每次请求的时间, 精确但占用内存与Rate成正比
+	SlidingWindowLog
+	// TokenBucket 令牌桶, 以 Rate/Period 的速度补充令牌, 最多积攒Burst个
+	TokenBucket
+	// GCRA 通用信元速率算法, 效果与令牌桶相同, 只需要保存一个时间戳
+	GCRA
+)
+
+// Limit 每Period最多通过Rate次
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	// Burst 令牌桶容量/GCRA允许的突发数, <=0 时等于Rate; 固定窗口和滑动窗口不使用
+	Burst int
+}
+
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+// RateLimitResult 一次限流判断的结果
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining 本次之后还可以立即通过的次数
+	Remaining int
+	// RetryAfter 被拒绝时需要等待多久再重试, 通过时为0
+	RetryAfter time.Duration
+}
+
+// Err 被拒绝时返回 errcode.ErrLocked, 通过时返回nil
+func (r *RateLimitResult) Err() error {
+	if r.Allowed {
+		return nil
+	}
+	return errcode.ErrLocked
+}
+
+type IRateLimiter interface {
+	// Allow 等价于 AllowN(ctx, key, limit, 1)
+	Allow(ctx context.Context, key string, limit Limit) (*RateLimitResult, error)
+	// AllowN 判断key在limit下能否通过n次, 通过时消耗n次配额, 被拒绝时不消耗
+	AllowN(ctx context.Context, key string, limit Limit, n int) (*RateLimitResult, error)
+}
+
+// burst 一次最多可以通过的数量
+func (l Limit) burst(algorithm RateLimitAlgorithm) int {
+	if (algorithm == TokenBucket || algorithm == GCRA) && l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+func (l Limit) validate(algorithm RateLimitAlgorithm, n int) error {
+	if l.Rate <= 0 || l.Period <= 0 || n <= 0 || n > l.burst(algorithm) {
+		return ErrInvalidRateLimit
+	}
+	return nil
+}
+
+// periodMs 以毫秒为单位的Period, 不足1ms按1ms计算
+func (l Limit) periodMs() int64 {
+	if ms := l.Period.Milliseconds(); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+func msToDuration(ms float64) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
@@ -0,0 +1,103 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+)
+
+// Lease 读锁、写锁或信号量许可的一次持有, 持有期间由watchdog每 ttl/3 续期, 续期失败时关闭 Lost()
+type Lease struct {
+	key     string
+	token   string
+	release func(ctx context.Context) (bool, error)
+	stop    chan struct{}
+	lost    chan struct{}
+	once    sync.Once
+}
+
+// newLease 创建持有并启动watchdog, extend 和 release 在不再持有时返回false
+func newLease(key, token string, ttl time.Duration, extend, release func(ctx context.Context) (bool, error)) *Lease {
+	l := &Lease{
+		key:     key,
+		token:   token,
+		release: release,
+		stop:    make(chan struct{}),
+		lost:    make(chan struct{}),
+	}
+	go watchLease(key, ttl, func(ctx context.Context) error {
+		ok, err := extend(ctx)
+		if err == nil && !ok {
+			err = ErrNotLocked
+		}
+		return err
+	}, l.stop, l.lost)
+	return l
+}
+
+func (l *Lease) Token() string {
+	return l.token
+}
+
+// Lost 持有期间失去许可时关闭, 持有者应当停止受保护的操作
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release 释放并停止watchdog, 已经失去许可时返回 ErrNotLocked
+func (l *Lease) Release(ctx context.Context) error {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+	ok, err := l.release(ctx)
+	if err != nil {
+		logs.CtxError(ctx, "redis client release lease", logs.String("err", err.Error()), logs.String("cacheKey", l.key))
+		return err
+	}
+	if !ok {
+		return ErrNotLocked
+	}
+	return nil
+}
+
+// watchLease 每 ttl/3 调用一次extend续期, 直到stop关闭;
+// extend 返回 ErrNotLocked, 或者直到过期都没有续期成功时关闭lost并退出
+func watchLease(key string, ttl time.Duration, extend func(ctx context.Context) error, stop <-chan struct{}, lost chan struct{}) {
+	interval := ttl / 3
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := extend(ctx)
+		cancel()
+		switch {
+		case err == nil:
+			lastRenew = time.Now()
+			continue
+		case errors.Is(err, ErrNotLocked):
+			logs.Warn("[watchLease] lock lost", logs.String("cacheKey", key))
+		case time.Since(lastRenew) >= ttl:
+			logs.Warn("[watchLease] lock expired before renewal", logs.String("cacheKey", key), logs.String("err", err.Error()))
+		default:
+			// redis 暂时不可用, 锁还没有过期, 下次继续续期
+			logs.Warn("[watchLease] renew lock", logs.String("cacheKey", key), logs.String("err", err.Error()))
+			continue
+		}
+		close(lost)
+		return
+	}
+}
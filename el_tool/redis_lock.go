@@ -0,0 +1,233 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+	"github.com/go-redis/redis"
+)
+
+var (
+	// ErrLockContended 锁被其他持有者占用, 且调用方不等待(LockOptions.NoWait)
+	ErrLockContended = errors.New("el_tool: lock contended")
+	// ErrLockTimeout 等待锁的过程中ctx结束
+	ErrLockTimeout = errors.New("el_tool: lock wait timeout")
+	// ErrLockRedis 访问redis失败, 锁的状态未知
+	ErrLockRedis = errors.New("el_tool: lock redis failure")
+)
+
+// LockOptions 阻塞获取锁的参数, 零值使用默认值
+type LockOptions struct {
+	// TTL 锁的过期时间, 持有期间由watchdog自动续期, 默认 EXPIRED_TIME
+	TTL time.Duration
+	// Token 持有者token, 相同token可以重入, 默认随机生成
+	Token string
+	// MinBackoff 第一次重试的等待时长, 之后每次翻倍, 默认10ms
+	MinBackoff time.Duration
+	// MaxBackoff 重试等待时长的上限, 默认500ms
+	MaxBackoff time.Duration
+	// Jitter 重试等待时长的随机抖动比例, 取值[0, 1], 默认0.2; 小于0表示不抖动
+	Jitter float64
+	// Fair 为true时等待者进入redis list排队, 按先来后到获取锁
+	Fair bool
+	// NoWait 为true时锁被占用立即返回 ErrLockContended
+	NoWait bool
+}
+
+const (
+	defaultLockMinBackoff = 10 * time.Millisecond
+	defaultLockMaxBackoff = 500 * time.Millisecond
+	defaultLockJitter     = 0.2
+	// minLockWaiterTimeout 排队者超过该时长没有重试会被移出队列, 防止崩溃的等待者阻塞队列
+	minLockWaiterTimeout = time.Second
+)
+
+// 公平锁: KEYS[2] 为等待队列(list), KEYS[3] 为等待者的过期时间(zset).
+// 先清理队头已经过期的等待者, 锁空闲且自己在队头(或队列为空)时才能获取; 否则排队并刷新自己的过期时间
+var fairAcquireScript = redis.NewScript(`
+	  while true do
+		local head = redis.call('LIndex', KEYS[2], 0)
+		if not head then
+		  break
+		end
+		local deadline = redis.call('ZScore', KEYS[3], head)
+		if deadline and tonumber(deadline) > tonumber(ARGV[3]) then
+		  break
+		end
+		redis.call('LPop', KEYS[2])
+		redis.call('ZRem', KEYS[3], head)
+	  end
+
+	  local owner = redis.call('HGet', KEYS[1], 'owner')
+	  if owner == ARGV[1] then
+		local count = redis.call('HIncrBy', KEYS[1], 'count', 1)
+		redis.call('PExpire', KEYS[1], ARGV[2])
+		return count
+	  end
+	  local head = redis.call('LIndex', KEYS[2], 0)
+	  if not owner and (not head or head == ARGV[1]) then
+		redis.call('HMSet', KEYS[1], 'owner', ARGV[1], 'count', 1)
+		redis.call('PExpire', KEYS[1], ARGV[2])
+		if head then
+		  redis.call('LPop', KEYS[2])
+		  redis.call('ZRem', KEYS[3], ARGV[1])
+		end
+		return 1
+	  end
+
+	  if not redis.call('ZScore', KEYS[3], ARGV[1]) then
+		redis.call('RPush', KEYS[2], ARGV[1])
+	  end
+	  redis.call('ZAdd', KEYS[3], tonumber(ARGV[3]) + tonumber(ARGV[4]), ARGV[1])
+	  redis.call('PExpire', KEYS[2], tonumber(ARGV[4]) * 2)
+	  redis.call('PExpire', KEYS[3], tonumber(ARGV[4]) * 2)
+	  return 0
+	  `)
+
+// 放弃等待时离开队列
+var leaveQueueScript = redis.NewScript(`
+	  redis.call('LRem', KEYS[1], 0, ARGV[1])
+	  return redis.call('ZRem', KEYS[2], ARGV[1])
+	  `)
+
+// Lock 阻塞获取锁, 直到获取成功或ctx结束. opts 为空时使用默认值.
+// 失败时返回的错误可以用 errors.Is 区分: ErrLockContended, ErrLockTimeout, ErrLockRedis
+func (p *Locker) Lock(ctx context.Context, key string, opts *LockOptions) (*Mutex, error) {
+	m := p.NewMutex(key)
+	if opts != nil {
+		m.WithTTL(opts.TTL).WithToken(opts.Token)
+	}
+	if err := m.Lock(ctx, opts); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Lock 阻塞获取锁, 直到获取成功或ctx结束, opts 中的 TTL 和 Token 不生效
+func (m *Mutex) Lock(ctx context.Context, opts *LockOptions) error {
+	o := newLockOptions(opts)
+	if !o.Fair {
+		return waitLock(ctx, m.key, o, m.TryLock, nil)
+	}
+
+	waiterTimeout := 3 * o.MaxBackoff
+	if waiterTimeout < minLockWaiterTimeout {
+		waiterTimeout = minLockWaiterTimeout
+	}
+	try := func(ctx context.Context) (bool, error) {
+		return m.tryLock(ctx, func(c *redis.Client) *redis.Cmd {
+			return fairAcquireScript.Run(c, []string{m.key, m.queueKey(), m.queueTimeoutKey()},
+				m.token, m.ttl.Milliseconds(), unixMilli(time.Now()), waiterTimeout.Milliseconds())
+		})
+	}
+	return waitLock(ctx, m.key, o, try, m.leaveQueue)
+}
+
+// waitLock 按o的退避策略重复调用try, 直到获取成功或ctx结束; 返回错误时总是调用giveUp
+func waitLock(ctx context.Context, key string, o LockOptions, try func(ctx context.Context) (bool, error), giveUp func()) error {
+	fail := func(err error) error {
+		if giveUp != nil {
+			giveUp()
+		}
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		ok, err := try(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fail(fmt.Errorf("%w: key %v: %v", ErrLockTimeout, key, ctx.Err()))
+			}
+			return fail(fmt.Errorf("%w: key %v: %v", ErrLockRedis, key, err))
+		}
+		if ok {
+			return nil
+		}
+		if o.NoWait {
+			return fail(fmt.Errorf("%w: key %v", ErrLockContended, key))
+		}
+
+		timer := time.NewTimer(o.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fail(fmt.Errorf("%w: key %v: %v", ErrLockTimeout, key, ctx.Err()))
+		case <-timer.C:
+		}
+	}
+}
+
+func (m *Mutex) queueKey() string {
+	return m.key + "_QUEUE"
+}
+
+func (m *Mutex) queueTimeoutKey() string {
+	return m.key + "_QUEUE_TIMEOUT"
+}
+
+// leaveQueue 使用新的ctx, 调用方的ctx可能已经结束
+func (m *Mutex) leaveQueue() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := leaveQueueScript.Run(m.locker.redisClient.WithContext(ctx), []string{m.queueKey(), m.queueTimeoutKey()}, m.token).Err()
+	if err != nil {
+		logs.Warn("redis client leave lock queue", logs.String("err", err.Error()), logs.String("cacheKey", m.key))
+	}
+}
+
+// newLockOptions 复制opts并填充默认值
+func newLockOptions(opts *LockOptions) LockOptions {
+	o := LockOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	o.fillDefault()
+	return o
+}
+
+func (o *LockOptions) tokenOrGen() string {
+	if o.Token != "" {
+		return o.Token
+	}
+	return genLockToken()
+}
+
+func (o *LockOptions) fillDefault() {
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = defaultLockMinBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultLockMaxBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+	if o.Jitter == 0 {
+		o.Jitter = defaultLockJitter
+	}
+	if o.Jitter > 1 {
+		o.Jitter = 1
+	}
+}
+
+// backoff 第attempt次重试前的等待时长, 在 [d*(1-Jitter), d*(1+Jitter)] 之间随机
+func (o *LockOptions) backoff(attempt int) time.Duration {
+	d := o.MinBackoff
+	for i := 0; i < attempt && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+	if o.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + o.Jitter*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,155 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestWaitLockGiveUp(t *testing.T) {
+	o := newLockOptions(&LockOptions{MinBackoff: time.Millisecond})
+	cases := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		try  func(ctx context.Context) (bool, error)
+		o    LockOptions
+		want error
+	}{
+		{"redis", nil, func(ctx context.Context) (bool, error) {
+			return false, errors.New("mock redis failure")
+		}, o, ErrLockRedis},
+		{"contended", nil, func(ctx context.Context) (bool, error) {
+			return false, nil
+		}, LockOptions{NoWait: true}, ErrLockContended},
+		{"timeout", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, func(ctx context.Context) (bool, error) {
+			return false, nil
+		}, o, ErrLockTimeout},
+	}
+	for _, c := range cases {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if c.ctx != nil {
+			ctx, cancel = c.ctx()
+		}
+		gaveUp := 0
+		err := waitLock(ctx, "key", c.o, c.try, func() { gaveUp++ })
+		cancel()
+		if !errors.Is(err, c.want) || gaveUp != 1 {
+			t.Errorf("%v: err = %v, giveUp called %v times", c.name, err, gaveUp)
+		}
+	}
+
+	if err := waitLock(context.Background(), "key", o, func(ctx context.Context) (bool, error) {
+		return true, nil
+	}, func() { t.Fatal("giveUp called after acquired") }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisLockGiveUp(t *testing.T) {
+	client, ns := testRedisClient(t)
+	l := NewRedisLocker(ns, client)
+	ctx := context.Background()
+
+	holder, err := l.Lock(ctx, "give_up", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Unlock(ctx)
+	if _, err = l.Lock(ctx, "give_up", &LockOptions{NoWait: true}); !errors.Is(err, ErrLockContended) {
+		t.Fatalf("Lock with NoWait = %v, want ErrLockContended", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err = l.Lock(waitCtx, "give_up", &LockOptions{MinBackoff: 5 * time.Millisecond}); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("Lock = %v, want ErrLockTimeout", err)
+	}
+	// 相同token可以重入
+	m, err := l.Lock(ctx, "give_up", &LockOptions{Token: holder.Token(), NoWait: true})
+	if err != nil {
+		t.Fatalf("reentrant Lock = %v", err)
+	}
+	_ = m.Unlock(ctx)
+}
+
+func TestRedisLockFair(t *testing.T) {
+	client, ns := testRedisClient(t)
+	l := NewRedisLocker(ns, client)
+	ctx := context.Background()
+
+	holder, err := l.Lock(ctx, "fair", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 等待者依次排队, 释放后按排队的顺序获取
+	order := make(chan int, 3)
+	errs := make(chan error, 3)
+	opts := &LockOptions{Fair: true, MinBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			m, err := l.Lock(ctx, "fair", opts)
+			if err != nil {
+				errs <- err
+				return
+			}
+			order <- i
+			time.Sleep(10 * time.Millisecond)
+			errs <- m.Unlock(ctx)
+		}(i)
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := holder.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		if got := <-order; got != i {
+			t.Fatalf("waiter %v acquired at position %v", got, i)
+		}
+	}
+}
+
+func TestRedisLockFairWaiterTimeout(t *testing.T) {
+	client, ns := testRedisClient(t)
+	l := NewRedisLocker(ns, client)
+	ctx := context.Background()
+
+	holder, err := l.Lock(ctx, "timeout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 等待超时的等待者离开队列
+	opts := &LockOptions{Fair: true, MinBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err = l.Lock(waitCtx, "timeout", opts); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("Lock = %v, want ErrLockTimeout", err)
+	}
+	if n, _ := client.LLen(holder.queueKey()).Result(); n != 0 {
+		t.Fatalf("timed out waiter left in queue, len %v", n)
+	}
+
+	// 崩溃的等待者没有离开队列, 过期后被清理, 不会阻塞后面的等待者
+	if err = client.RPush(holder.queueKey(), "crashed").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.ZAdd(holder.queueTimeoutKey(), redis.Z{Score: float64(unixMilli(time.Now().Add(-time.Second))), Member: "crashed"}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err = holder.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	m, err := l.Lock(waitCtx, "timeout", opts)
+	if err != nil {
+		t.Fatalf("Lock behind a crashed waiter: %v", err)
+	}
+	_ = m.Unlock(ctx)
+}
@@ -19,6 +19,12 @@ type Locker struct {
 }
 
 func NewLocker(namespace string, redisClient *redis.Client) ILocker {
+	return NewRedisLocker(namespace, redisClient)
+}
+
+// NewRedisLocker 与 NewLocker 相同, 返回具体类型, 可以通过 NewMutex 创建可重入、自动续期的锁, 或者通过 Lock 阻塞获取;
+// NewRWLock 和 NewSemaphore 创建读写锁和信号量
+func NewRedisLocker(namespace string, redisClient *redis.Client) *Locker {
 	if redisClient == nil {
 		panic("invalid cache config for poi settle cache")
 	}
@@ -26,10 +32,43 @@ func NewLocker(namespace string, redisClient *redis.Client) ILocker {
 	return &Locker{namespace, redisClient}
 }
 
+// 锁的值没有变化时才延长过期时间
+var renewScript = redis.NewScript(`
+	  if redis.call('Get', KEYS[1]) == ARGV[1] then
+		return redis.call('PExpire', KEYS[1], ARGV[2])
+	  end
+	  return 0
+	  `)
+
+// 锁的值没有变化时才删除, 防止本锁超时后解锁别人的锁; 锁不存在时返回-1
+var unlockScript = redis.NewScript(`
+	  local val = redis.call('Get', KEYS[1])
+	  if not val then
+		return -1
+	  end
+	  if val == ARGV[1] then
+		return redis.call('Del', KEYS[1])
+	  end
+	  return 0
+	  `)
+
 func (p *Locker) genCacheKey(key string) string {
 	return fmt.Sprintf("%v_%v", p.namespace, key)
 }
 
+// genMutexKey Mutex 的值是hash, 与 TryLock 的string使用不同的key, 避免同名的锁互相 WRONGTYPE
+func (p *Locker) genMutexKey(key string) string {
+	return fmt.Sprintf("%v_MUTEX_%v", p.namespace, key)
+}
+
+func (p *Locker) genRWLockKey(key string) string {
+	return fmt.Sprintf("%v_RWLOCK_%v", p.namespace, key)
+}
+
+func (p *Locker) genSemaphoreKey(key string) string {
+	return fmt.Sprintf("%v_SEMAPHORE_%v", p.namespace, key)
+}
+
 func (p *Locker) TryLock(ctx context.Context, key string) (unLockFunc func()) {
 	return p.tryLock(ctx, key, EXPIRED_TIME)
 }
@@ -74,21 +113,30 @@ func (p *Locker) tryLock(ctx context.Context, key string, duration time.Duration
 	}
 }
 
+// UnLock 锁不存在(已经过期)时返回 redis.Nil, 与之前的版本相同
 func (p *Locker) UnLock(ctx context.Context, key string, value string) error {
 	cacheKey := p.genCacheKey(key)
-	currentValue, err := p.redisClient.WithContext(ctx).Get(cacheKey).Result()
+	res, err := unlockScript.Run(p.redisClient.WithContext(ctx), []string{cacheKey}, value).Int64()
 	if err != nil {
-		logs.Warn("redis client get", logs.String("err", err.Error()), logs.String("cacheKey", cacheKey))
+		logs.Error("redis client unlock", logs.String("err", err.Error()), logs.String("cacheKey", cacheKey))
 		return err
 	}
-	if currentValue == value { // 防止本锁超时后, 解锁别人的锁
-		_, err = p.redisClient.WithContext(ctx).Del(cacheKey).Result()
-		if err != nil {
-			logs.Error("redis client del", logs.String("err", err.Error()), logs.String("cacheKey", cacheKey))
-			return err
-		}
-	} else {
-		logs.CtxError(ctx, "redis unlock fail, value not equal")
+	if res == -1 {
+		logs.Warn("redis client get", logs.String("err", redis.Nil.Error()), logs.String("cacheKey", cacheKey))
+		return redis.Nil
+	}
+	if res == 0 {
+		logs.CtxError(ctx, "redis unlock fail, value not equal", logs.String("cacheKey", cacheKey))
 	}
 	return nil
 }
+
+func (p *Locker) Renew(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	cacheKey := p.genCacheKey(key)
+	res, err := renewScript.Run(p.redisClient.WithContext(ctx), []string{cacheKey}, value, duration.Milliseconds()).Int64()
+	if err != nil {
+		logs.Error("redis client renew", logs.String("err", err.Error()), logs.String("cacheKey", cacheKey))
+		return false, err
+	}
+	return res == 1, nil
+}
@@ -0,0 +1,209 @@
+package el_tool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+	"github.com/go-redis/redis"
+)
+
+var (
+	// ErrNotLocked 当前持有者没有持有锁, 或者锁已经过期被其他人获取
+	ErrNotLocked = errors.New("el_tool: lock not held")
+)
+
+// 锁是一个hash: owner 为持有者的token, count 为重入次数.
+// 不存在时获取锁, owner 相同时重入, 返回获取后的重入次数, 被其他人持有时返回0
+var acquireScript = redis.NewScript(`
+	  local owner = redis.call('HGet', KEYS[1], 'owner')
+	  if not owner then
+		redis.call('HMSet', KEYS[1], 'owner', ARGV[1], 'count', 1)
+		redis.call('PExpire', KEYS[1], ARGV[2])
+		return 1
+	  end
+	  if owner == ARGV[1] then
+		local count = redis.call('HIncrBy', KEYS[1], 'count', 1)
+		redis.call('PExpire', KEYS[1], ARGV[2])
+		return count
+	  end
+	  return 0
+	  `)
+
+// 释放一次重入, 返回剩余的重入次数, 为0时删除锁; 不是owner时返回-1
+var releaseScript = redis.NewScript(`
+	  if redis.call('HGet', KEYS[1], 'owner') ~= ARGV[1] then
+		return -1
+	  end
+	  local count = redis.call('HIncrBy', KEYS[1], 'count', -1)
+	  if count <= 0 then
+		redis.call('Del', KEYS[1])
+		return 0
+	  end
+	  return count
+	  `)
+
+// owner 没有变化时才延长过期时间
+var extendScript = redis.NewScript(`
+	  if redis.call('HGet', KEYS[1], 'owner') == ARGV[1] then
+		return redis.call('PExpire', KEYS[1], ARGV[2])
+	  end
+	  return 0
+	  `)
+
+// Mutex 可重入、自动续期的分布式锁.
+// 同一个token可以重复获取(包括其他进程使用相同token), 每次获取都需要对应一次Unlock;
+// 持有期间后台watchdog每 ttl/3 续期一次, 续期失败(锁被删除或被他人持有, 或直到过期都无法续期)时关闭 Lost()
+type Mutex struct {
+	locker  *Locker
+	key     string
+	token   string
+	ttl     time.Duration
+	mu      sync.Mutex
+	held    int
+	lost    chan struct{}
+	stopDog chan struct{}
+}
+
+// NewMutex 创建锁句柄, 默认ttl为 EXPIRED_TIME, token 随机生成
+func (p *Locker) NewMutex(key string) *Mutex {
+	if key == "" {
+		panic("empty key")
+	}
+	return &Mutex{
+		locker: p,
+		key:    p.genMutexKey(key),
+		token:  genLockToken(),
+		ttl:    EXPIRED_TIME,
+		lost:   make(chan struct{}),
+	}
+}
+
+// WithTTL 设置锁的过期时间, watchdog 按 ttl/3 续期, 需要在获取锁之前调用
+func (m *Mutex) WithTTL(ttl time.Duration) *Mutex {
+	if ttl > 0 {
+		m.ttl = ttl
+	}
+	return m
+}
+
+// WithToken 指定持有者token, 使用相同token的句柄可以重入同一把锁, 需要在获取锁之前调用
+func (m *Mutex) WithToken(token string) *Mutex {
+	if token != "" {
+		m.token = token
+	}
+	return m
+}
+
+func (m *Mutex) Token() string {
+	return m.token
+}
+
+// Lost 在持有期间失去锁时关闭, 持有者应当停止受锁保护的操作
+func (m *Mutex) Lost() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lost
+}
+
+// TryLock 尝试获取锁, 不阻塞; 已被其他token持有时返回false
+func (m *Mutex) TryLock(ctx context.Context) (bool, error) {
+	return m.tryLock(ctx, func(c *redis.Client) *redis.Cmd {
+		return acquireScript.Run(c, []string{m.key}, m.token, m.ttl.Milliseconds())
+	})
+}
+
+// tryLock acquire 执行获取锁的脚本, 返回获取后的重入次数, 0表示被其他人持有
+func (m *Mutex) tryLock(ctx context.Context, acquire func(c *redis.Client) *redis.Cmd) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-m.lost:
+		// 之前持有的锁已经丢失, 重新获取
+		m.held = 0
+	default:
+	}
+	count, err := acquire(m.locker.redisClient.WithContext(ctx)).Int64()
+	if err != nil {
+		logs.CtxError(ctx, "redis client acquire lock", logs.String("err", err.Error()), logs.String("cacheKey", m.key))
+		return false, err
+	}
+	if count == 0 {
+		return false, nil
+	}
+	if m.held == 0 {
+		m.lost = make(chan struct{})
+		m.startWatchdog()
+	}
+	m.held++
+	return true, nil
+}
+
+// Unlock 释放一次重入, 全部释放后删除锁并停止watchdog; 锁已经不属于当前token时返回 ErrNotLocked
+func (m *Mutex) Unlock(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.held == 0 {
+		return ErrNotLocked
+	}
+	count, err := releaseScript.Run(m.locker.redisClient.WithContext(ctx), []string{m.key}, m.token).Int64()
+	if err != nil {
+		logs.CtxError(ctx, "redis client release lock", logs.String("err", err.Error()), logs.String("cacheKey", m.key))
+		return err
+	}
+	m.held--
+	if count < 0 || m.held == 0 {
+		m.held = 0
+		m.stopWatchdog()
+	}
+	if count < 0 {
+		return ErrNotLocked
+	}
+	return nil
+}
+
+// Extend 立即续期一次, 锁已经不属于当前token时返回 ErrNotLocked
+func (m *Mutex) Extend(ctx context.Context) error {
+	res, err := extendScript.Run(m.locker.redisClient.WithContext(ctx), []string{m.key}, m.token, m.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if res != 1 {
+		return ErrNotLocked
+	}
+	return nil
+}
+
+// startWatchdog 调用时需要持有 m.mu
+func (m *Mutex) startWatchdog() {
+	stop := make(chan struct{})
+	lost := m.lost
+	m.stopDog = stop
+	go m.watchdog(stop, lost)
+}
+
+// stopWatchdog 调用时需要持有 m.mu
+func (m *Mutex) stopWatchdog() {
+	if m.stopDog != nil {
+		close(m.stopDog)
+		m.stopDog = nil
+	}
+}
+
+func (m *Mutex) watchdog(stop <-chan struct{}, lost chan struct{}) {
+	watchLease(m.key, m.ttl, m.Extend, stop, lost)
+}
+
+func genLockToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
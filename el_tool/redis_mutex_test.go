@@ -0,0 +1,154 @@
+package el_tool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+	"github.com/go-redis/redis"
+)
+
+// 设置该环境变量后才会跑依赖 redis 的测试, 例如 EL_TOOL_TEST_REDIS_ADDR=127.0.0.1:6379
+const testRedisAddrEnv = "EL_TOOL_TEST_REDIS_ADDR"
+
+func TestMain(m *testing.M) {
+	// 测试中没有初始化日志, 使用空实现
+	nop := func(msg string, fields ...logs.Field) {}
+	ctxNop := func(ctx context.Context, msg string, fields ...logs.Field) {}
+	logs.Info, logs.Warn, logs.Error = nop, nop, nop
+	logs.CtxInfo, logs.CtxWarn, logs.CtxError = ctxNop, ctxNop, ctxNop
+	os.Exit(m.Run())
+}
+
+func testRedisClient(t *testing.T) (*redis.Client, string) {
+	addr := os.Getenv(testRedisAddrEnv)
+	if addr == "" {
+		t.Skipf("%v not set", testRedisAddrEnv)
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { client.Close() })
+	// 每次运行使用不同的namespace, 避免与之前的数据冲突
+	return client, fmt.Sprintf("el_tool_test_%v", time.Now().UnixNano())
+}
+
+func TestRedisMutexReentrant(t *testing.T) {
+	client, ns := testRedisClient(t)
+	l := NewRedisLocker(ns, client)
+	ctx := context.Background()
+
+	m := l.NewMutex("reentrant")
+	for i := 0; i < 2; i++ {
+		if ok, err := m.TryLock(ctx); err != nil || !ok {
+			t.Fatalf("TryLock #%v = %v, %v", i, ok, err)
+		}
+	}
+	// 相同token的其他句柄可以重入, 不同token不能获取
+	same := l.NewMutex("reentrant").WithToken(m.Token())
+	if ok, err := same.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("TryLock with same token = %v, %v", ok, err)
+	}
+	other := l.NewMutex("reentrant")
+	if ok, err := other.TryLock(ctx); err != nil || ok {
+		t.Fatalf("TryLock with other token = %v, %v", ok, err)
+	}
+	// 与 TryLock 同名的锁互不影响
+	unlock := l.TryLock(ctx, "reentrant")
+	if unlock == nil {
+		t.Fatal("TryLock with the same key as a Mutex failed")
+	}
+	unlock()
+
+	for i := 0; i < 3; i++ {
+		h := m
+		if i == 2 {
+			h = same
+		}
+		if ok, _ := other.TryLock(ctx); ok {
+			t.Fatalf("lock released after %v of 3 unlocks", i)
+		}
+		if err := h.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock #%v: %v", i, err)
+		}
+	}
+	if ok, err := other.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("lock should be free after all unlocks: %v, %v", ok, err)
+	}
+	if err := m.Unlock(ctx); err != ErrNotLocked {
+		t.Fatalf("Unlock without holding = %v", err)
+	}
+	_ = other.Unlock(ctx)
+}
+
+func TestRedisMutexWatchdog(t *testing.T) {
+	client, ns := testRedisClient(t)
+	l := NewRedisLocker(ns, client)
+	ctx := context.Background()
+
+	ttl := 300 * time.Millisecond
+	m := l.NewMutex("watchdog").WithTTL(ttl)
+	if ok, err := m.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	// 持有时间超过ttl, watchdog 续期后锁仍然有效
+	time.Sleep(3 * ttl)
+	select {
+	case <-m.Lost():
+		t.Fatal("lock lost while watchdog is running")
+	default:
+	}
+	if ok, _ := l.NewMutex("watchdog").TryLock(ctx); ok {
+		t.Fatal("lock expired while watchdog is running")
+	}
+	if err := m.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unlock 之后 watchdog 停止, 不再续期
+	if ok, err := m.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("TryLock again = %v, %v", ok, err)
+	}
+	if err := m.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := client.Exists(m.key).Result(); err != nil || n != 0 {
+		t.Fatalf("lock should be deleted after Unlock: %v, %v", n, err)
+	}
+}
+
+func TestRedisMutexLost(t *testing.T) {
+	client, ns := testRedisClient(t)
+	l := NewRedisLocker(ns, client)
+	ctx := context.Background()
+
+	ttl := 300 * time.Millisecond
+	m := l.NewMutex("lost").WithTTL(ttl)
+	if ok, err := m.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("TryLock = %v, %v", ok, err)
+	}
+	lost := m.Lost()
+	// 锁被删除后由其他人获取, watchdog 续期失败并关闭 Lost
+	if err := client.Del(m.key).Err(); err != nil {
+		t.Fatal(err)
+	}
+	other := l.NewMutex("lost").WithTTL(ttl)
+	if ok, err := other.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("TryLock after delete = %v, %v", ok, err)
+	}
+	select {
+	case <-lost:
+	case <-time.After(ttl):
+		t.Fatal("Lost should be closed when the lock is taken by others")
+	}
+	if err := m.Extend(ctx); err != ErrNotLocked {
+		t.Fatalf("Extend lost lock = %v", err)
+	}
+	if err := m.Unlock(ctx); err != ErrNotLocked {
+		t.Fatalf("Unlock lost lock = %v", err)
+	}
+	if err := other.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
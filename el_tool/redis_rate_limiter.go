@@ -0,0 +1,167 @@
+package el_tool
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 以下脚本的时间单位都是毫秒, ARGV[1] 为当前时间; 返回 {是否通过, 剩余次数, 需要等待的毫秒数}
+
+// 固定窗口: KEYS[1] 为当前窗口的计数; ARGV[2] rate, ARGV[3] period, ARGV[4] n, ARGV[5] 窗口结束时间
+var fixedWindowScript = redis.NewScript(`
+	  local rate = tonumber(ARGV[2])
+	  local n = tonumber(ARGV[4])
+	  local count = tonumber(redis.call('Get', KEYS[1]) or '0')
+	  if count + n > rate then
+		return {0, rate - count, tonumber(ARGV[5]) - tonumber(ARGV[1])}
+	  end
+	  count = redis.call('IncrBy', KEYS[1], n)
+	  if count == n then
+		redis.call('PExpire', KEYS[1], ARGV[3])
+	  end
+	  return {1, rate - count, 0}
+	  `)
+
+// 滑动窗口日志: KEYS[1] 为窗口内的请求(zset, score 为请求时间); ARGV[2] rate, ARGV[3] period, ARGV[4] n, ARGV[5] 成员前缀
+var slidingWindowLogScript = redis.NewScript(`
+	  local now = tonumber(ARGV[1])
+	  local rate = tonumber(ARGV[2])
+	  local period = tonumber(ARGV[3])
+	  local n = tonumber(ARGV[4])
+	  redis.call('ZRemRangeByScore', KEYS[1], '-inf', now - period)
+	  local count = redis.call('ZCard', KEYS[1])
+	  if count + n > rate then
+		local oldest = redis.call('ZRange', KEYS[1], count + n - rate - 1, count + n - rate - 1, 'WITHSCORES')
+		return {0, rate - count, tonumber(oldest[2]) + period - now}
+	  end
+	  for i = 1, n do
+		redis.call('ZAdd', KEYS[1], now, ARGV[5] .. ':' .. i)
+	  end
+	  redis.call('PExpire', KEYS[1], period)
+	  return {1, rate - count - n, 0}
+	  `)
+
+// 令牌桶: KEYS[1] 为hash{tokens, last}; ARGV[2] rate, ARGV[3] period, ARGV[4] n, ARGV[5] 容量
+var tokenBucketScript = redis.NewScript(`
+	  local now = tonumber(ARGV[1])
+	  local perMs = tonumber(ARGV[2]) / tonumber(ARGV[3])
+	  local n = tonumber(ARGV[4])
+	  local capacity = tonumber(ARGV[5])
+	  local state = redis.call('HMGet', KEYS[1], 'tokens', 'last')
+	  local tokens = tonumber(state[1]) or capacity
+	  local last = tonumber(state[2]) or now
+	  tokens = math.min(capacity, tokens + math.max(0, now - last) * perMs)
+
+	  local allowed = 0
+	  local retry = 0
+	  if tokens >= n then
+		tokens = tokens - n
+		allowed = 1
+	  else
+		retry = (n - tokens) / perMs
+	  end
+	  redis.call('HMSet', KEYS[1], 'tokens', tostring(tokens), 'last', ARGV[1])
+	  redis.call('PExpire', KEYS[1], math.ceil((capacity - tokens) / perMs) + 1)
+	  return {allowed, math.floor(tokens), tostring(retry)}
+	  `)
+
+// GCRA: KEYS[1] 为理论到达时间(tat); ARGV[2] 发放间隔, ARGV[3] 突发偏移量, ARGV[4] n
+var gcraScript = redis.NewScript(`
+	  local now = tonumber(ARGV[1])
+	  local interval = tonumber(ARGV[2])
+	  local burstOffset = tonumber(ARGV[3])
+	  local tat = math.max(tonumber(redis.call('Get', KEYS[1]) or '0'), now)
+	  local newTat = tat + tonumber(ARGV[4]) * interval
+	  local diff = now - (newTat - burstOffset)
+	  if diff < 0 then
+		return {0, math.floor(math.max(0, (now - (tat - burstOffset)) / interval)), tostring(-diff)}
+	  end
+	  redis.call('Set', KEYS[1], tostring(newTat), 'PX', math.ceil(newTat - now) + 1)
+	  return {1, math.floor(diff / interval), '0'}
+	  `)
+
+// RedisRateLimiter 基于redis的 IRateLimiter, 所有副本共享配额; key 的命名规则与 Locker 相同
+type RedisRateLimiter struct {
+	namespace   string
+	redisClient *redis.Client
+	algorithm   RateLimitAlgorithm
+}
+
+func NewRedisRateLimiter(namespace string, redisClient *redis.Client, algorithm RateLimitAlgorithm) IRateLimiter {
+	if redisClient == nil {
+		panic("invalid redis client for rate limiter")
+	}
+	return &RedisRateLimiter{
+		namespace:   namespace,
+		redisClient: redisClient,
+		algorithm:   algorithm,
+	}
+}
+
+func (p *RedisRateLimiter) genCacheKey(key string) string {
+	return fmt.Sprintf("%v_RATE_%v", p.namespace, key)
+}
+
+func (p *RedisRateLimiter) Allow(ctx context.Context, key string, limit Limit) (*RateLimitResult, error) {
+	return p.AllowN(ctx, key, limit, 1)
+}
+
+func (p *RedisRateLimiter) AllowN(ctx context.Context, key string, limit Limit, n int) (*RateLimitResult, error) {
+	if err := limit.validate(p.algorithm, n); err != nil {
+		return nil, err
+	}
+
+	client := p.redisClient.WithContext(ctx)
+	cacheKey := p.genCacheKey(key)
+	now := unixMilli(time.Now())
+	period := limit.periodMs()
+	var cmd *redis.Cmd
+	switch p.algorithm {
+	case SlidingWindowLog:
+		cmd = slidingWindowLogScript.Run(client, []string{cacheKey}, now, limit.Rate, period, n, genLockToken())
+	case TokenBucket:
+		cmd = tokenBucketScript.Run(client, []string{cacheKey}, now, limit.Rate, period, n, limit.burst(TokenBucket))
+	case GCRA:
+		interval := float64(period) / float64(limit.Rate)
+		cmd = gcraScript.Run(client, []string{cacheKey}, now, interval, interval*float64(limit.burst(GCRA)), n)
+	default:
+		window := now - now%period
+		windowKey := fmt.Sprintf("%v_%v", cacheKey, window)
+		cmd = fixedWindowScript.Run(client, []string{windowKey}, now, limit.Rate, period, n, window+period)
+	}
+	return parseRateLimitResult(cmd)
+}
+
+func parseRateLimitResult(cmd *redis.Cmd) (*RateLimitResult, error) {
+	vals, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := vals.([]interface{})
+	if !ok || len(arr) != 3 {
+		return nil, fmt.Errorf("el_tool: unexpected rate limit script result %v", vals)
+	}
+	allowed, _ := arr[0].(int64)
+	remaining, _ := arr[1].(int64)
+	var retryMs float64
+	switch v := arr[2].(type) {
+	case int64:
+		retryMs = float64(v)
+	case string:
+		if retryMs, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("el_tool: unexpected rate limit retry %v", v)
+		}
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &RateLimitResult{
+		Allowed:    allowed == 1,
+		Remaining:  int(remaining),
+		RetryAfter: msToDuration(retryMs),
+	}, nil
+}
@@ -0,0 +1,81 @@
+package el_tool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var rateLimitAlgorithms = []RateLimitAlgorithm{FixedWindow, SlidingWindowLog, TokenBucket, GCRA}
+
+// alignWindow 等到下一个窗口开始, 避免固定窗口在测试中途切换
+func alignWindow(period time.Duration) {
+	now := unixMilli(time.Now())
+	ms := period.Milliseconds()
+	time.Sleep(time.Duration(ms-now%ms) * time.Millisecond)
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	client, ns := testRedisClient(t)
+	ctx := context.Background()
+	limit := Limit{Rate: 5, Period: 500 * time.Millisecond}
+	for _, algorithm := range rateLimitAlgorithms {
+		l := NewRedisRateLimiter(fmt.Sprintf("%v_%v", ns, algorithm), client, algorithm)
+		alignWindow(limit.Period)
+		allowed, last := allowTimes(t, l, limit, 8)
+		if allowed != 5 {
+			t.Fatalf("algorithm %v: allowed %v, want 5", algorithm, allowed)
+		}
+		if last.Allowed || last.Remaining != 0 || last.RetryAfter <= 0 || last.RetryAfter > limit.Period {
+			t.Fatalf("algorithm %v: unexpected rejected result %+v", algorithm, last)
+		}
+		if res, err := l.Allow(ctx, "other", limit); err != nil || !res.Allowed || res.Remaining != 4 {
+			t.Fatalf("algorithm %v: keys should be independent, got %+v, %v", algorithm, res, err)
+		}
+
+		time.Sleep(last.RetryAfter + 10*time.Millisecond)
+		if res, err := l.Allow(ctx, "k", limit); err != nil || !res.Allowed {
+			t.Fatalf("algorithm %v: should be allowed after %v, got %+v, %v", algorithm, last.RetryAfter, res, err)
+		}
+	}
+}
+
+func TestRedisRateLimiterAllowN(t *testing.T) {
+	client, ns := testRedisClient(t)
+	ctx := context.Background()
+	limit := Limit{Rate: 5, Period: time.Second}
+	for _, algorithm := range rateLimitAlgorithms {
+		l := NewRedisRateLimiter(fmt.Sprintf("%v_%v", ns, algorithm), client, algorithm)
+		alignWindow(limit.Period)
+		if res, err := l.AllowN(ctx, "n", limit, 3); err != nil || !res.Allowed || res.Remaining != 2 {
+			t.Fatalf("algorithm %v: AllowN = %+v, %v", algorithm, res, err)
+		}
+		// 配额不足时不消耗剩余的配额
+		if res, err := l.AllowN(ctx, "n", limit, 3); err != nil || res.Allowed || res.Remaining != 2 {
+			t.Fatalf("algorithm %v: AllowN over limit = %+v, %v", algorithm, res, err)
+		}
+		if res, err := l.AllowN(ctx, "n", limit, 2); err != nil || !res.Allowed || res.Remaining != 0 {
+			t.Fatalf("algorithm %v: AllowN rest = %+v, %v", algorithm, res, err)
+		}
+	}
+}
+
+func TestRedisRateLimiterBurst(t *testing.T) {
+	client, ns := testRedisClient(t)
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Period: 200 * time.Millisecond, Burst: 3}
+	for _, algorithm := range []RateLimitAlgorithm{TokenBucket, GCRA} {
+		l := NewRedisRateLimiter(fmt.Sprintf("%v_%v", ns, algorithm), client, algorithm)
+		if allowed, _ := allowTimes(t, l, limit, 5); allowed != 3 {
+			t.Fatalf("algorithm %v: allowed %v, want burst 3", algorithm, allowed)
+		}
+		time.Sleep(limit.Period)
+		if allowed, _ := allowTimes(t, l, limit, 3); allowed != 1 {
+			t.Fatalf("algorithm %v: allowed %v after one interval, want 1", algorithm, allowed)
+		}
+		if _, err := l.AllowN(ctx, "k", limit, 4); err != ErrInvalidRateLimit {
+			t.Fatalf("algorithm %v: n over burst should be invalid, got %v", algorithm, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package el_tool
+
+import (
+	"context"
+	"time"
+
+	"github.com/drip-in/eden_lib/logs"
+	"github.com/go-redis/redis"
+)
+
+// 读锁: KEYS[1] 写锁(string), KEYS[2] 读者(zset, score 为租约到期时间), KEYS[3] 等待中的写者(string).
+// 有写者持有或等待时不能获取读锁, 防止写者饥饿
+var readAcquireScript = redis.NewScript(`
+	  local writer = redis.call('Get', KEYS[1])
+	  if writer and writer ~= ARGV[1] then
+		return 0
+	  end
+	  local pending = redis.call('Get', KEYS[3])
+	  if pending and pending ~= ARGV[1] then
+		return 0
+	  end
+	  redis.call('ZRemRangeByScore', KEYS[2], '-inf', ARGV[3])
+	  redis.call('ZAdd', KEYS[2], tonumber(ARGV[3]) + tonumber(ARGV[2]), ARGV[1])
+	  if redis.call('PTTL', KEYS[2]) < tonumber(ARGV[2]) then
+		redis.call('PExpire', KEYS[2], ARGV[2])
+	  end
+	  return 1
+	  `)
+
+// 写锁: 清理过期读者后, 没有其他写者和读者时获取; 被读者占用时登记为等待中的写者
+var writeAcquireScript = redis.NewScript(`
+	  local writer = redis.call('Get', KEYS[1])
+	  if writer then
+		return 0
+	  end
+	  redis.call('ZRemRangeByScore', KEYS[2], '-inf', ARGV[3])
+	  local pending = redis.call('Get', KEYS[3])
+	  if redis.call('ZCard', KEYS[2]) > 0 or (pending and pending ~= ARGV[1]) then
+		if not pending then
+		  redis.call('Set', KEYS[3], ARGV[1], 'PX', ARGV[4])
+		elseif pending == ARGV[1] then
+		  redis.call('PExpire', KEYS[3], ARGV[4])
+		end
+		return 0
+	  end
+	  if pending then
+		redis.call('Del', KEYS[3])
+	  end
+	  redis.call('Set', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	  return 1
+	  `)
+
+// zset 中的持有者(读者或信号量许可)仍然持有时延长租约
+var leaseExtendScript = redis.NewScript(`
+	  local deadline = redis.call('ZScore', KEYS[1], ARGV[1])
+	  if not deadline or tonumber(deadline) < tonumber(ARGV[3]) then
+		return 0
+	  end
+	  redis.call('ZAdd', KEYS[1], tonumber(ARGV[3]) + tonumber(ARGV[2]), ARGV[1])
+	  if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+		redis.call('PExpire', KEYS[1], ARGV[2])
+	  end
+	  return 1
+	  `)
+
+// RWLock 分布式读写锁, 多个读者可以同时持有, 写者独占; 有写者等待时新的读者需要等待.
+// 读锁和写锁都不可重入, 每次获取返回一个 Lease, 持有期间自动续期
+type RWLock struct {
+	locker *Locker
+	key    string
+	ttl    time.Duration
+}
+
+// NewRWLock 创建读写锁, 默认ttl为 EXPIRED_TIME
+func (p *Locker) NewRWLock(key string) *RWLock {
+	if key == "" {
+		panic("empty key")
+	}
+	return &RWLock{
+		locker: p,
+		key:    p.genRWLockKey(key),
+		ttl:    EXPIRED_TIME,
+	}
+}
+
+// WithTTL 设置租约时长, watchdog 按 ttl/3 续期
+func (l *RWLock) WithTTL(ttl time.Duration) *RWLock {
+	if ttl > 0 {
+		l.ttl = ttl
+	}
+	return l
+}
+
+func (l *RWLock) writeKey() string {
+	return l.key + "_WRITE"
+}
+
+func (l *RWLock) readersKey() string {
+	return l.key + "_READERS"
+}
+
+func (l *RWLock) pendingWriterKey() string {
+	return l.key + "_WRITE_PENDING"
+}
+
+// RLock 阻塞获取读锁, opts 中的 Fair 不生效
+func (l *RWLock) RLock(ctx context.Context, opts *LockOptions) (*Lease, error) {
+	o := newLockOptions(opts)
+	token := o.tokenOrGen()
+	keys := []string{l.writeKey(), l.readersKey(), l.pendingWriterKey()}
+	try := func(ctx context.Context) (bool, error) {
+		res, err := readAcquireScript.Run(l.locker.redisClient.WithContext(ctx), keys, token, l.ttl.Milliseconds(), unixMilli(time.Now())).Int64()
+		return res == 1, err
+	}
+	if err := waitLock(ctx, l.key, o, try, nil); err != nil {
+		return nil, err
+	}
+
+	extend := func(ctx context.Context) (bool, error) {
+		res, err := leaseExtendScript.Run(l.locker.redisClient.WithContext(ctx), []string{l.readersKey()}, token, l.ttl.Milliseconds(), unixMilli(time.Now())).Int64()
+		return res == 1, err
+	}
+	release := func(ctx context.Context) (bool, error) {
+		res, err := l.locker.redisClient.WithContext(ctx).ZRem(l.readersKey(), token).Result()
+		return res == 1, err
+	}
+	return newLease(l.readersKey(), token, l.ttl, extend, release), nil
+}
+
+// Lock 阻塞获取写锁, opts 中的 Fair 不生效; 等待期间会阻止新的读者获取读锁
+func (l *RWLock) Lock(ctx context.Context, opts *LockOptions) (*Lease, error) {
+	o := newLockOptions(opts)
+	token := o.tokenOrGen()
+	keys := []string{l.writeKey(), l.readersKey(), l.pendingWriterKey()}
+	// 等待中的写者需要在下次重试前续期, 否则让出给其他写者
+	pendingTTL := 3 * o.MaxBackoff
+	if pendingTTL < minLockWaiterTimeout {
+		pendingTTL = minLockWaiterTimeout
+	}
+	try := func(ctx context.Context) (bool, error) {
+		res, err := writeAcquireScript.Run(l.locker.redisClient.WithContext(ctx), keys, token, l.ttl.Milliseconds(),
+			unixMilli(time.Now()), pendingTTL.Milliseconds()).Int64()
+		return res == 1, err
+	}
+	giveUp := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := unlockScript.Run(l.locker.redisClient.WithContext(ctx), []string{l.pendingWriterKey()}, token).Err()
+		if err != nil {
+			// 登记会在 pendingTTL 之后过期, 期间新的读者需要等待
+			logs.Warn("redis client clear pending writer", logs.String("err", err.Error()), logs.String("cacheKey", l.key))
+		}
+	}
+	if err := waitLock(ctx, l.key, o, try, giveUp); err != nil {
+		return nil, err
+	}
+
+	extend := func(ctx context.Context) (bool, error) {
+		res, err := renewScript.Run(l.locker.redisClient.WithContext(ctx), []string{l.writeKey()}, token, l.ttl.Milliseconds()).Int64()
+		return res == 1, err
+	}
+	release := func(ctx context.Context) (bool, error) {
+		res, err := unlockScript.Run(l.locker.redisClient.WithContext(ctx), []string{l.writeKey()}, token).Int64()
+		return res == 1, err
+	}
+	return newLease(l.writeKey(), token, l.ttl, extend, release), nil
+}
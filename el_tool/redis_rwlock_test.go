@@ -0,0 +1,91 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRedisRWLockWriterPreference(t *testing.T) {
+	client, ns := testRedisClient(t)
+	l := NewRedisLocker(ns, client).NewRWLock("rw")
+	ctx := context.Background()
+	noWait := &LockOptions{NoWait: true}
+
+	r1, err := l.RLock(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := l.RLock(ctx, noWait)
+	if err != nil {
+		t.Fatalf("readers should share the lock: %v", err)
+	}
+
+	// 写者等待期间新的读者不能获取
+	writer := make(chan *Lease, 1)
+	go func() {
+		w, err := l.Lock(ctx, &LockOptions{MinBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+		if err != nil {
+			t.Error(err)
+		}
+		writer <- w
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err = l.RLock(ctx, noWait); !errors.Is(err, ErrLockContended) {
+		t.Fatalf("RLock with a pending writer = %v", err)
+	}
+	if err = r1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = r2.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var w *Lease
+	select {
+	case w = <-writer:
+	case <-time.After(time.Second):
+		t.Fatal("writer should acquire after readers release")
+	}
+	if w == nil {
+		t.FailNow()
+	}
+	if _, err = l.RLock(ctx, noWait); !errors.Is(err, ErrLockContended) {
+		t.Fatalf("RLock while writer holds = %v", err)
+	}
+	if _, err = l.Lock(ctx, noWait); !errors.Is(err, ErrLockContended) {
+		t.Fatalf("Lock while writer holds = %v", err)
+	}
+	if err = w.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r, err := l.RLock(ctx, noWait)
+	if err != nil {
+		t.Fatalf("RLock after writer releases = %v", err)
+	}
+	_ = r.Release(ctx)
+}
+
+func TestRedisRWLockWriterGiveUp(t *testing.T) {
+	client, ns := testRedisClient(t)
+	l := NewRedisLocker(ns, client).NewRWLock("giveup")
+	ctx := context.Background()
+
+	r, err := l.RLock(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 写者放弃等待后清除登记, 不再阻塞新的读者
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err = l.Lock(waitCtx, nil); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("Lock = %v, want ErrLockTimeout", err)
+	}
+	r2, err := l.RLock(ctx, &LockOptions{NoWait: true})
+	if err != nil {
+		t.Fatalf("RLock after writer gave up = %v", err)
+	}
+	_ = r2.Release(ctx)
+	_ = r.Release(ctx)
+}
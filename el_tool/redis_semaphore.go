@@ -0,0 +1,91 @@
+package el_tool
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 信号量: KEYS[1] 为持有者(zset, score 为租约到期时间). 清理过期的持有者后, 持有者少于ARGV[4]时获取
+var semaphoreAcquireScript = redis.NewScript(`
+	  redis.call('ZRemRangeByScore', KEYS[1], '-inf', ARGV[3])
+	  if not redis.call('ZScore', KEYS[1], ARGV[1]) and redis.call('ZCard', KEYS[1]) >= tonumber(ARGV[4]) then
+		return 0
+	  end
+	  redis.call('ZAdd', KEYS[1], tonumber(ARGV[3]) + tonumber(ARGV[2]), ARGV[1])
+	  if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+		redis.call('PExpire', KEYS[1], ARGV[2])
+	  end
+	  return 1
+	  `)
+
+// Semaphore 分布式计数信号量, 所有副本共享 permits 个许可, 每个许可是一个自动续期的租约;
+// 持有者崩溃后许可在ttl之后自动回收
+type Semaphore struct {
+	locker  *Locker
+	key     string
+	permits int
+	ttl     time.Duration
+}
+
+// NewSemaphore 创建信号量, 默认ttl为 EXPIRED_TIME
+func (p *Locker) NewSemaphore(key string, permits int) *Semaphore {
+	if key == "" {
+		panic("empty key")
+	}
+	if permits <= 0 {
+		panic("permits must be positive")
+	}
+	return &Semaphore{
+		locker:  p,
+		key:     p.genSemaphoreKey(key),
+		permits: permits,
+		ttl:     EXPIRED_TIME,
+	}
+}
+
+// WithTTL 设置租约时长, watchdog 按 ttl/3 续期
+func (s *Semaphore) WithTTL(ttl time.Duration) *Semaphore {
+	if ttl > 0 {
+		s.ttl = ttl
+	}
+	return s
+}
+
+// Acquire 阻塞获取一个许可, opts 中的 Fair 不生效
+func (s *Semaphore) Acquire(ctx context.Context, opts *LockOptions) (*Lease, error) {
+	o := newLockOptions(opts)
+	token := o.tokenOrGen()
+	try := func(ctx context.Context) (bool, error) {
+		res, err := semaphoreAcquireScript.Run(s.locker.redisClient.WithContext(ctx), []string{s.key}, token,
+			s.ttl.Milliseconds(), unixMilli(time.Now()), s.permits).Int64()
+		return res == 1, err
+	}
+	if err := waitLock(ctx, s.key, o, try, nil); err != nil {
+		return nil, err
+	}
+
+	extend := func(ctx context.Context) (bool, error) {
+		res, err := leaseExtendScript.Run(s.locker.redisClient.WithContext(ctx), []string{s.key}, token, s.ttl.Milliseconds(), unixMilli(time.Now())).Int64()
+		return res == 1, err
+	}
+	release := func(ctx context.Context) (bool, error) {
+		res, err := s.locker.redisClient.WithContext(ctx).ZRem(s.key, token).Result()
+		return res == 1, err
+	}
+	return newLease(s.key, token, s.ttl, extend, release), nil
+}
+
+// Available 当前剩余的许可数, 只用于观测
+func (s *Semaphore) Available(ctx context.Context) (int, error) {
+	held, err := s.locker.redisClient.WithContext(ctx).ZCount(s.key, "("+strconv.FormatInt(unixMilli(time.Now()), 10), "+inf").Result()
+	if err != nil {
+		return 0, err
+	}
+	if left := s.permits - int(held); left > 0 {
+		return left, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,55 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRedisSemaphorePermits(t *testing.T) {
+	client, ns := testRedisClient(t)
+	s := NewRedisLocker(ns, client).NewSemaphore("sem", 2)
+	ctx := context.Background()
+	noWait := &LockOptions{NoWait: true}
+
+	available := func(want int) {
+		t.Helper()
+		if n, err := s.Available(ctx); err != nil || n != want {
+			t.Fatalf("Available = %v, %v; want %v", n, err, want)
+		}
+	}
+	available(2)
+	a, err := s.Acquire(ctx, noWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 相同token重复获取不占用新的许可
+	dup, err := s.Acquire(ctx, &LockOptions{NoWait: true, Token: a.Token()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	available(1)
+	b, err := s.Acquire(ctx, noWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	available(0)
+	if _, err = s.Acquire(ctx, noWait); !errors.Is(err, ErrLockContended) {
+		t.Fatalf("Acquire without permits = %v", err)
+	}
+
+	if err = a.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	available(1)
+	if err = dup.Release(ctx); err != ErrNotLocked {
+		t.Fatalf("Release a released permit = %v", err)
+	}
+	c, err := s.Acquire(ctx, noWait)
+	if err != nil {
+		t.Fatalf("Acquire after release = %v", err)
+	}
+	_ = b.Release(ctx)
+	_ = c.Release(ctx)
+	available(2)
+}
@@ -2,35 +2,196 @@ package el_tool
 
 import (
 	"context"
-	"github.com/go-redis/redis"
+	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/go-redis/redis"
 )
 
+// Storage 基于redis的 IStorageV2, 通过 NewPrefixedStorage 创建时所有key都加上 "<namespace>_" 前缀, 与 Locker 相同
 type Storage struct {
 	namespace   string
 	redisClient *redis.Client
+	prefix      bool
+	legacyNil   bool // Get key不存在时直接返回 redis.Nil
 }
 
+// NewStorage key 不加前缀, Get key不存在时返回 redis.Nil, 与之前的版本相同; 新代码使用 NewStorageV2
 func NewStorage(namespace string, redisClient *redis.Client) IStorage {
 	if redisClient == nil {
 		panic("invalid cache config for poi settle cache")
 	}
 
-	return &Storage{namespace, redisClient}
+	return &Storage{namespace: namespace, redisClient: redisClient, legacyNil: true}
+}
+
+// NewStorageV2 key 不加前缀, 需要按namespace隔离时使用 NewPrefixedStorage
+func NewStorageV2(namespace string, redisClient *redis.Client) IStorageV2 {
+	if redisClient == nil {
+		panic("invalid cache config for poi settle cache")
+	}
+
+	return &Storage{namespace: namespace, redisClient: redisClient}
+}
+
+// NewPrefixedStorage namespace 不为空时所有key都加上 "<namespace>_" 前缀
+func NewPrefixedStorage(namespace string, redisClient *redis.Client) IStorageV2 {
+	if redisClient == nil {
+		panic("invalid cache config for poi settle cache")
+	}
+
+	return &Storage{namespace: namespace, redisClient: redisClient, prefix: true}
+}
+
+func (s *Storage) genCacheKey(key string) string {
+	if !s.prefix || s.namespace == "" {
+		return key
+	}
+	return fmt.Sprintf("%v_%v", s.namespace, key)
+}
+
+func (s *Storage) genCacheKeys(keys []string) []string {
+	cacheKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		cacheKeys = append(cacheKeys, s.genCacheKey(key))
+	}
+	return cacheKeys
 }
 
 func (s *Storage) Set(ctx context.Context, key string, val interface{}, expiration time.Duration) error {
-	return s.redisClient.WithContext(ctx).Set(key, val, expiration).Err()
+	return s.redisClient.WithContext(ctx).Set(s.genCacheKey(key), val, expiration).Err()
 }
 
 func (s *Storage) SetNX(ctx context.Context, key string, val interface{}, expiration time.Duration) error {
-	return s.redisClient.WithContext(ctx).SetNX(key, val, expiration).Err()
+	return s.redisClient.WithContext(ctx).SetNX(s.genCacheKey(key), val, expiration).Err()
 }
 
 func (s *Storage) Get(ctx context.Context, key string) (interface{}, error) {
-	return s.redisClient.WithContext(ctx).Get(key).Result()
+	val, err := s.redisClient.WithContext(ctx).Get(s.genCacheKey(key)).Result()
+	if err == redis.Nil && !s.legacyNil {
+		return nil, ErrNotFound
+	}
+	return val, err
 }
 
 func (s *Storage) Del(ctx context.Context, keys ...string) error {
-	return s.redisClient.WithContext(ctx).Del(keys...).Err()
+	return s.redisClient.WithContext(ctx).Del(s.genCacheKeys(keys)...).Err()
+}
+
+func (s *Storage) MGet(ctx context.Context, keys ...string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return s.redisClient.WithContext(ctx).MGet(s.genCacheKeys(keys)...).Result()
+}
+
+func (s *Storage) MSet(ctx context.Context, kvs map[string]interface{}, expiration time.Duration) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	_, err := s.redisClient.WithContext(ctx).Pipelined(func(pipe redis.Pipeliner) error {
+		for key, val := range kvs {
+			pipe.Set(s.genCacheKey(key), val, expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *Storage) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.redisClient.WithContext(ctx).IncrBy(s.genCacheKey(key), delta).Result()
+}
+
+func (s *Storage) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	ok, err := s.redisClient.WithContext(ctx).PExpire(s.genCacheKey(key), expiration).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Storage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.redisClient.WithContext(ctx).PTTL(s.genCacheKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL: -2 表示key不存在, -1 表示没有过期时间
+	switch ttl {
+	case -2 * time.Millisecond:
+		return 0, ErrNotFound
+	case -1 * time.Millisecond:
+		return NoExpiration, nil
+	}
+	return ttl, nil
+}
+
+func (s *Storage) HSet(ctx context.Context, key string, field string, val interface{}) error {
+	return s.redisClient.WithContext(ctx).HSet(s.genCacheKey(key), field, val).Err()
+}
+
+func (s *Storage) HGet(ctx context.Context, key string, field string) (string, error) {
+	val, err := s.redisClient.WithContext(ctx).HGet(s.genCacheKey(key), field).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return val, err
+}
+
+func (s *Storage) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return s.redisClient.WithContext(ctx).HGetAll(s.genCacheKey(key)).Result()
+}
+
+func (s *Storage) HDel(ctx context.Context, key string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return s.redisClient.WithContext(ctx).HDel(s.genCacheKey(key), fields...).Err()
+}
+
+func (s *Storage) ZAdd(ctx context.Context, key string, members ...ZMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	zs := make([]redis.Z, 0, len(members))
+	for _, m := range members {
+		zs = append(zs, redis.Z{Score: m.Score, Member: m.Member})
+	}
+	return s.redisClient.WithContext(ctx).ZAdd(s.genCacheKey(key), zs...).Err()
+}
+
+func (s *Storage) ZRem(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		args = append(args, m)
+	}
+	return s.redisClient.WithContext(ctx).ZRem(s.genCacheKey(key), args...).Err()
+}
+
+func (s *Storage) ZRangeByScore(ctx context.Context, key string, min, max float64, offset, count int64) ([]ZMember, error) {
+	opt := redis.ZRangeBy{
+		Min: strconv.FormatFloat(min, 'f', -1, 64),
+		Max: strconv.FormatFloat(max, 'f', -1, 64),
+	}
+	if offset > 0 || count > 0 {
+		if count <= 0 {
+			count = -1
+		}
+		opt.Offset, opt.Count = offset, count
+	}
+	zs, err := s.redisClient.WithContext(ctx).ZRangeByScoreWithScores(s.genCacheKey(key), opt).Result()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ZMember, 0, len(zs))
+	for _, z := range zs {
+		members = append(members, ZMember{Member: fmt.Sprint(z.Member), Score: z.Score})
+	}
+	return members, nil
 }
@@ -0,0 +1,110 @@
+package el_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	// ErrNotFound key不存在, errors.Is(ErrNotFound, redis.Nil) 为true, 但 err == redis.Nil 不成立;
+	// NewStorage 的 Get 和 Locker 的 UnLock 保持之前的行为, 仍然返回 redis.Nil
+	ErrNotFound error = notFoundError{}
+)
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string {
+	return "el_tool: key not found"
+}
+
+func (notFoundError) Is(target error) bool {
+	return target == redis.Nil
+}
+
+// NoExpiration TTL 查询的key没有设置过期时间
+const NoExpiration time.Duration = -1
+
+// IsNotFound 兼容还在返回 redis.Nil 的 IStorage 实现
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || err == redis.Nil
+}
+
+// GetAs 读取key并用codec反序列化为T, codec 为空时使用 JsonCodec; key不存在时返回 ErrNotFound
+func GetAs[T any](ctx context.Context, s IStorage, key string, codec Codec) (T, error) {
+	var val T
+	raw, err := s.Get(ctx, key)
+	if err != nil {
+		if err == redis.Nil {
+			err = ErrNotFound
+		}
+		return val, err
+	}
+	err = decodeStorageValue(raw, &val, codec)
+	return val, err
+}
+
+// SetAs 用codec序列化val后写入key, codec 为空时使用 JsonCodec
+func SetAs[T any](ctx context.Context, s IStorage, key string, val T, expiration time.Duration, codec Codec) error {
+	data, err := storageCodec(codec).Marshal(val)
+	if err != nil {
+		return err
+	}
+	return s.Set(ctx, key, data, expiration)
+}
+
+// MGetAs 批量读取并反序列化, 不存在的key不会出现在结果中
+func MGetAs[T any](ctx context.Context, s IStorageV2, keys []string, codec Codec) (map[string]T, error) {
+	raws, err := s.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]T, len(keys))
+	for i, raw := range raws {
+		if raw == nil {
+			continue
+		}
+		var val T
+		if err = decodeStorageValue(raw, &val, codec); err != nil {
+			return nil, fmt.Errorf("decode key %v: %w", keys[i], err)
+		}
+		res[keys[i]] = val
+	}
+	return res, nil
+}
+
+// MSetAs 批量序列化后写入
+func MSetAs[T any](ctx context.Context, s IStorageV2, kvs map[string]T, expiration time.Duration, codec Codec) error {
+	raws := make(map[string]interface{}, len(kvs))
+	for key, val := range kvs {
+		data, err := storageCodec(codec).Marshal(val)
+		if err != nil {
+			return fmt.Errorf("encode key %v: %w", key, err)
+		}
+		raws[key] = data
+	}
+	return s.MSet(ctx, raws, expiration)
+}
+
+func decodeStorageValue(raw interface{}, val interface{}, codec Codec) error {
+	var data []byte
+	switch r := raw.(type) {
+	case string:
+		data = []byte(r)
+	case []byte:
+		data = r
+	default:
+		return fmt.Errorf("unexpected storage value type %T", raw)
+	}
+	return storageCodec(codec).Unmarshal(data, val)
+}
+
+func storageCodec(codec Codec) Codec {
+	if codec == nil {
+		return JsonCodec
+	}
+	return codec
+}
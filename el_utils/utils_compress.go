@@ -10,9 +10,13 @@ import (
 func GzipEncode(in []byte) (ret []byte, err error) {
 	var buffer bytes.Buffer
 	writer := gzip.NewWriter(&buffer)
-	defer writer.Close()
 	_, err = writer.Write(in)
 	if err != nil {
+		_ = writer.Close()
+		return
+	}
+	// 必须先Close把剩余数据和尾部校验写入buffer, 否则压缩结果不完整
+	if err = writer.Close(); err != nil {
 		return
 	}
 	return buffer.Bytes(), nil
@@ -2,10 +2,39 @@ package gopool
 
 import (
 	"context"
+	"errors"
+	"log"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+var (
+	// ErrPoolClosed pool 已经关闭, 不再接收新的任务
+	ErrPoolClosed = errors.New("gopool: pool closed")
+	// ErrPoolFull 任务队列已满, 任务被拒绝
+	ErrPoolFull = errors.New("gopool: pool full")
+)
+
+// RejectPolicy 任务队列已满(所有 worker 都在忙)时如何处理新的任务
+type RejectPolicy int
+
+const (
+	// RejectBlock 阻塞直到队列有空位、ctx 结束或者 pool 关闭, 默认策略
+	RejectBlock RejectPolicy = iota
+	// RejectBlockTimeout 最多阻塞 Options.BlockTimeout, 超时返回 ErrPoolFull
+	RejectBlockTimeout
+	// RejectDrop 丢弃任务, Submit 返回nil; CtxGo 提交的任务不会被丢弃
+	RejectDrop
+	// RejectCallerRuns 在调用者的 goroutine 中执行任务
+	RejectCallerRuns
+	// RejectError 立即返回 ErrPoolFull
+	RejectError
+)
+
+const defaultQueueLen = 1
+
 // Option represents the optional function.
 type Option func(opts *Options)
 
@@ -14,22 +43,60 @@ func loadOptions(options ...Option) *Options {
 	for _, option := range options {
 		option(opts)
 	}
+	if opts.QueueLen <= 0 {
+		opts.QueueLen = defaultQueueLen
+	}
 	return opts
 }
 
+// WithQueueLen 设置任务队列的长度, 所有 worker 都在忙时任务在队列中等待
+func WithQueueLen(n int) Option {
+	return func(opts *Options) {
+		opts.QueueLen = n
+	}
+}
+
+// WithRejectPolicy 设置队列已满时的处理策略, blockTimeout 只对 RejectBlockTimeout 生效
+func WithRejectPolicy(policy RejectPolicy, blockTimeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.RejectPolicy = policy
+		opts.BlockTimeout = blockTimeout
+	}
+}
+
+// WithPanicHandler 设置 worker panic 时的处理方法
+func WithPanicHandler(f func(interface{})) Option {
+	return func(opts *Options) {
+		opts.PanicHandler = f
+	}
+}
+
+// WithLogger 设置打印 panic 和被丢弃任务的 logger
+func WithLogger(logger Logger) Option {
+	return func(opts *Options) {
+		opts.Logger = logger
+	}
+}
+
 type Pool interface {
 	// 更新 goroutine pool 的容量
 	SetCap(cap int32)
 	// 执行 f
 	Go(f func())
-	// 传入 ctx 和 f，panic 打日志时带上 logid
+	// 传入 ctx 和 f，panic 打日志时带上 logid; 保证f一定会被执行, 任务被拒绝时新起一个 goroutine 执行
 	CtxGo(ctx context.Context, f func())
+	// Submit 提交任务, 队列已满时按 RejectPolicy 处理; pool 已经关闭时返回 ErrPoolClosed
+	Submit(ctx context.Context, f func()) error
+	// TrySubmit 提交任务, 不阻塞; 队列已满时返回 ErrPoolFull, pool 已经关闭时返回 ErrPoolClosed
+	TrySubmit(ctx context.Context, f func()) error
 	// panic 的时候调用额外的 handler
 	SetPanicHandler(f func(context.Context, interface{}))
 	// 获取当前正在运行的 goroutine 数量
 	WorkerCount() int32
-	// Close 会停止接收新的任务，等到旧的任务全部执行完成之后，所有的 worker 会自动退出
+	// Close 会停止接收新的任务，等到旧的任务全部执行完成之后，所有的 worker 会自动退出; 不等待 worker 退出
 	Close()
+	// Shutdown 关闭 pool 并等待队列中的任务执行完、所有 worker 退出, ctx 结束时返回 ctx.Err()
+	Shutdown(ctx context.Context) error
 }
 
 var taskPool sync.Pool
@@ -63,25 +130,32 @@ type pool struct {
 	// 配置信息
 	options *Options
 	// 任务管道
-	taskCh    chan *task
-	taskLock  sync.Mutex
+	taskCh chan *task
+	// 提交任务时持有读锁, 关闭 taskCh 时持有写锁, 避免向已关闭的管道发送
+	taskLock  sync.RWMutex
 	taskCount int32
 
 	// 记录正在运行的 worker 数量
 	workerCount int32
+	workerWg    sync.WaitGroup
 
-	// 用来标记是否关闭
-	closed int32
+	// 用来标记是否关闭, 由 taskLock 保护
+	closed bool
+	// 关闭时 close, 唤醒阻塞在提交任务上的调用者
+	done      chan struct{}
+	closeOnce sync.Once
 
 	// worker panic 的时候会调用这个方法
 	panicHandler func(context.Context, interface{})
 }
 
 func NewPool(cap int32, options ...Option) Pool {
+	opts := loadOptions(options...)
 	p := &pool{
 		cap:     cap,
-		taskCh:  make(chan *task, 1),
-		options: loadOptions(options...),
+		taskCh:  make(chan *task, opts.QueueLen),
+		options: opts,
+		done:    make(chan struct{}),
 	}
 	return p
 }
@@ -95,25 +169,141 @@ func (p *pool) Go(f func()) {
 }
 
 func (p *pool) CtxGo(ctx context.Context, f func()) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	policy := p.options.RejectPolicy
+	if policy == RejectDrop {
+		policy = RejectError
+	}
+	// 队列已满、pool 已经关闭或者 ctx 结束时不丢弃任务, 与没有队列限制时的行为一致
+	if err := p.submit(ctx, f, policy); err != nil {
+		go p.execute(ctx, f)
+	}
+}
+
+func (p *pool) Submit(ctx context.Context, f func()) error {
+	return p.submit(ctx, f, p.options.RejectPolicy)
+}
+
+func (p *pool) TrySubmit(ctx context.Context, f func()) error {
+	return p.submit(ctx, f, RejectError)
+}
+
+func (p *pool) submit(ctx context.Context, f func(), policy RejectPolicy) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	t := taskPool.Get().(*task)
 	t.ctx = ctx
 	t.f = f
-	p.taskCh <- t
-	//fmt.Printf("task: %+v", t)
+	err := p.enqueue(t, policy)
+	if err == nil {
+		return nil
+	}
+	t.Recycle()
+	switch {
+	case err != ErrPoolFull:
+		return err
+	case policy == RejectDrop:
+		return nil
+	case policy == RejectCallerRuns:
+		// 不持有锁执行, 避免阻塞 Close
+		p.execute(ctx, f)
+		return nil
+	}
+	return err
+}
+
+// enqueue 把任务放入队列, 队列已满时按 policy 阻塞或返回 ErrPoolFull
+func (p *pool) enqueue(t *task, policy RejectPolicy) error {
+	p.taskLock.RLock()
+	defer p.taskLock.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.taskCh <- t:
+		p.afterEnqueue()
+		return nil
+	default:
+	}
+	// 队列已满, 容量调大之后可能还可以增加 worker
+	p.tryAddWorker()
+
+	var timeout <-chan time.Time
+	switch policy {
+	case RejectBlock:
+	case RejectBlockTimeout:
+		timer := time.NewTimer(p.options.BlockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	default:
+		return ErrPoolFull
+	}
+	select {
+	case p.taskCh <- t:
+		p.afterEnqueue()
+		return nil
+	case <-p.done:
+		return ErrPoolClosed
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	case <-timeout:
+		return ErrPoolFull
+	}
+}
+
+// afterEnqueue 调用时需要持有 taskLock 的读锁
+func (p *pool) afterEnqueue() {
 	atomic.AddInt32(&p.taskCount, 1)
-	// 如果 pool 已经被关闭了，就 panic
-	if atomic.LoadInt32(&p.closed) == 1 {
-		panic("use closed pool")
+	p.tryAddWorker()
+}
+
+// tryAddWorker 满足以下任意一个条件时启动新的 worker：
+// 1. 目前的 worker 数量小于上限 p.cap
+// 2. 目前没有 worker
+// 调用时需要持有 taskLock 的读锁, 保证 workerWg.Add 在 Shutdown 的 Wait 之前
+func (p *pool) tryAddWorker() {
+	for {
+		n := p.WorkerCount()
+		if n >= atomic.LoadInt32(&p.cap) && n != 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&p.workerCount, n, n+1) {
+			break
+		}
 	}
-	// 满足以下任意一个条件：
-	// 1. 目前的 worker 数量小于上限 p.cap
-	// 2. 目前没有 worker
-	if p.WorkerCount() < atomic.LoadInt32(&p.cap) || p.WorkerCount() == 0 {
-		p.incWorkerCount()
-		w := workerPool.Get().(*worker)
-		w.pool = p
-		w.run()
+	p.workerWg.Add(1)
+	w := workerPool.Get().(*worker)
+	w.pool = p
+	w.run()
+}
+
+// execute 执行任务并处理 panic
+func (p *pool) execute(ctx context.Context, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch {
+			case p.panicHandler != nil:
+				p.panicHandler(ctx, r)
+			case p.options.PanicHandler != nil:
+				p.options.PanicHandler(r)
+			default:
+				p.logf("GOPOOL: panic in pool: %v: %s", r, debug.Stack())
+			}
+		}
+	}()
+	f()
+}
+
+func (p *pool) logf(format string, args ...interface{}) {
+	if p.options.Logger != nil {
+		p.options.Logger.Printf(format, args...)
+		return
 	}
+	log.Printf(format, args...)
 }
 
 func (p *pool) SetPanicHandler(f func(context.Context, interface{})) {
@@ -126,12 +316,29 @@ func (p *pool) WorkerCount() int32 {
 
 // Close 会停止接收新的任务，等到旧的任务全部执行完成之后，所有的 worker 会自动退出
 func (p *pool) Close() {
-	atomic.StoreInt32(&p.closed, 1)
-	p.taskCh <- nil
+	p.closeOnce.Do(func() {
+		// 先唤醒阻塞在提交任务上的调用者, 让它们释放读锁
+		close(p.done)
+		p.taskLock.Lock()
+		p.closed = true
+		close(p.taskCh)
+		p.taskLock.Unlock()
+	})
 }
 
-func (p *pool) incWorkerCount() {
-	atomic.AddInt32(&p.workerCount, 1)
+func (p *pool) Shutdown(ctx context.Context) error {
+	p.Close()
+	stopped := make(chan struct{})
+	go func() {
+		p.workerWg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (p *pool) decWorkerCount() {
@@ -141,10 +348,19 @@ func (p *pool) decWorkerCount() {
 // Options contains all options which will be applied when instantiating an ants pool.
 type Options struct {
 	// PanicHandler is used to handle panics from each worker goroutine.
-	// if nil, panics will be thrown out again from worker goroutines.
+	// if nil, panics will be logged by Logger.
 	PanicHandler func(interface{})
 
 	// Logger is the customized logger for logging info, if it is not set,
 	// default standard logger from log package is used.
 	Logger Logger
+
+	// QueueLen 任务队列的长度, 默认为1
+	QueueLen int
+
+	// RejectPolicy 队列已满时的处理策略, 默认 RejectBlock
+	RejectPolicy RejectPolicy
+
+	// BlockTimeout RejectBlockTimeout 策略下最多阻塞的时长
+	BlockTimeout time.Duration
 }
@@ -1,12 +1,14 @@
 package gopool
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"runtime"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const benchmarkTimes = 10000
@@ -44,6 +46,121 @@ func TestPoolPanic(t *testing.T) {
 	p.Go(testPanicFunc)
 }
 
+// busyPool 返回一个 worker 被阻塞、队列已满的 pool, 关闭 release 后恢复
+func busyPool(t *testing.T, options ...Option) (Pool, chan struct{}) {
+	p := NewPool(1, options...)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := p.TrySubmit(context.Background(), func() {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := p.TrySubmit(context.Background(), func() {}); err != nil {
+		t.Fatal(err)
+	}
+	return p, release
+}
+
+func TestPoolRejectPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	p, release := busyPool(t, WithRejectPolicy(RejectError, 0))
+	if err := p.Submit(ctx, func() {}); err != ErrPoolFull {
+		t.Fatalf("RejectError: %v", err)
+	}
+	close(release)
+	p.Close()
+
+	p, release = busyPool(t, WithRejectPolicy(RejectBlockTimeout, 20*time.Millisecond))
+	start := time.Now()
+	if err := p.Submit(ctx, func() {}); err != ErrPoolFull || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("RejectBlockTimeout: %v after %v", err, time.Since(start))
+	}
+	close(release)
+	p.Close()
+
+	var dropped int32
+	p, release = busyPool(t, WithRejectPolicy(RejectDrop, 0))
+	if err := p.Submit(ctx, func() { atomic.AddInt32(&dropped, 1) }); err != nil {
+		t.Fatalf("RejectDrop: %v", err)
+	}
+	close(release)
+	if err := p.Shutdown(ctx); err != nil || atomic.LoadInt32(&dropped) != 0 {
+		t.Fatalf("RejectDrop: %v, dropped task ran %v times", err, dropped)
+	}
+
+	var ranInCaller bool
+	p, release = busyPool(t, WithRejectPolicy(RejectCallerRuns, 0))
+	if err := p.Submit(ctx, func() { ranInCaller = true }); err != nil || !ranInCaller {
+		t.Fatalf("RejectCallerRuns: %v, ran %v", err, ranInCaller)
+	}
+	close(release)
+	p.Close()
+
+	p, release = busyPool(t)
+	blockCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(blockCtx, func() {}); err != context.DeadlineExceeded {
+		t.Fatalf("RejectBlock with ctx: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- p.Submit(ctx, func() {})
+	}()
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("RejectBlock: %v", err)
+	}
+	p.Close()
+}
+
+func TestPoolShutdown(t *testing.T) {
+	p := NewPool(4, WithQueueLen(100))
+	var n int32
+	for i := 0; i < 100; i++ {
+		if err := p.TrySubmit(context.Background(), func() {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&n, 1)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n != 100 || p.WorkerCount() != 0 {
+		t.Fatalf("executed %v tasks, %v workers left", n, p.WorkerCount())
+	}
+	if err := p.Submit(context.Background(), func() {}); err != ErrPoolClosed {
+		t.Fatalf("Submit after Shutdown: %v", err)
+	}
+	// 关闭之后 CtxGo 不再 panic, 重复关闭也是安全的
+	p.Go(func() {})
+	p.Close()
+}
+
+func TestPoolCloseWakesBlockedSubmit(t *testing.T) {
+	p, release := busyPool(t)
+	done := make(chan error)
+	go func() {
+		done <- p.Submit(context.Background(), func() {})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.Close()
+	if err := <-done; err != ErrPoolClosed {
+		t.Fatalf("blocked Submit after Close: %v", err)
+	}
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func BenchmarkPool(b *testing.B) {
 	fmt.Println(runtime.GOMAXPROCS(0))
 	p := NewPool(int32(runtime.GOMAXPROCS(0)))
@@ -78,3 +195,40 @@ func BenchmarkGo(b *testing.B) {
 		wg.Wait()
 	}
 }
+
+func TestPoolCtxGoAlwaysRuns(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	cases := []struct {
+		name    string
+		ctx     context.Context
+		options []Option
+	}{
+		{"RejectDrop", context.Background(), []Option{WithRejectPolicy(RejectDrop, 0)}},
+		{"RejectError", context.Background(), []Option{WithRejectPolicy(RejectError, 0)}},
+		{"RejectBlockTimeout", context.Background(), []Option{WithRejectPolicy(RejectBlockTimeout, time.Millisecond)}},
+		{"ctx done", cancelled, nil},
+	}
+	for _, c := range cases {
+		p, release := busyPool(t, c.options...)
+		ran := make(chan struct{})
+		p.CtxGo(c.ctx, func() { close(ran) })
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: task rejected by a full pool was not run", c.name)
+		}
+		close(release)
+		p.Close()
+	}
+
+	p := NewPool(1)
+	p.Close()
+	ran := make(chan struct{})
+	p.Go(func() { close(ran) })
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("task submitted to a closed pool was not run")
+	}
+}
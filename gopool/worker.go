@@ -21,30 +21,20 @@ func newWorker() interface{} {
 
 func (w *worker) run() {
 	go func() {
+		// taskCh 关闭并且队列中的任务都执行完之后退出
 		for t := range w.pool.taskCh {
-			if t == nil {
-				// 如果没有任务要做了，就释放资源，退出
-				w.close()
-				w.Recycle()
-				return
-			}
-
 			atomic.AddInt32(&w.pool.taskCount, -1)
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						w.pool.panicHandler(t.ctx, r)
-					}
-				}()
-				t.f()
-			}()
+			w.pool.execute(t.ctx, t.f)
 			t.Recycle()
 		}
+		w.close()
+		w.Recycle()
 	}()
 }
 
 func (w *worker) close() {
 	w.pool.decWorkerCount()
+	w.pool.workerWg.Done()
 }
 
 func (w *worker) zero() {
@@ -204,6 +204,11 @@ func (sfg *SnowFlakeIdGenerator) NextId() (int64, error) {
 	return curTs, nil
 }
 
+//实现 IIdGenerator
+func (sfg *SnowFlakeIdGenerator) Get() (int64, error) {
+	return sfg.NextId()
+}
+
 //解析生成的ID
 func (sfg *SnowFlakeIdGenerator) Parse(id int64) (int64, int64, int64, error) {
 	//如果还没有初始化
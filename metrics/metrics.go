@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"time"
+)
+
+var (
+	// MetricsImpl 库内各组件默认使用的指标上报实现, 默认不上报
+	MetricsImpl IMetrics = NopMetrics{}
+)
+
+func InitMetricsImpl(m IMetrics) {
+	if m == nil {
+		m = NopMetrics{}
+	}
+	MetricsImpl = m
+}
+
+// Tag 指标的维度
+type Tag struct {
+	Key   string
+	Value string
+}
+
+func T(key, value string) Tag {
+	return Tag{Key: key, Value: value}
+}
+
+// IMetrics 指标上报接口, 由业务对接 prometheus 等监控系统; 实现需要并发安全, 且不能阻塞调用方
+type IMetrics interface {
+	// Counter 计数器累加value
+	Counter(name string, value int64, tags ...Tag)
+	// Gauge 设置当前值
+	Gauge(name string, value float64, tags ...Tag)
+	// Timer 记录一次耗时
+	Timer(name string, d time.Duration, tags ...Tag)
+}
+
+// NopMetrics 不上报任何指标
+type NopMetrics struct{}
+
+func (NopMetrics) Counter(name string, value int64, tags ...Tag) {}
+
+func (NopMetrics) Gauge(name string, value float64, tags ...Tag) {}
+
+func (NopMetrics) Timer(name string, d time.Duration, tags ...Tag) {}
+
+// IsNop 是否为不上报的实现, 用于跳过代价较高的指标采集
+func IsNop(m IMetrics) bool {
+	_, ok := m.(NopMetrics)
+	return m == nil || ok
+}